@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/golang-jwt/jwt"
 
@@ -22,6 +23,8 @@ func main() {
 	host := flag.String("host", "http://localhost:8080", "Server base URL (http)")
 	wsHost := flag.String("ws", "ws://localhost:8080/ws", "WebSocket URL")
 	showCurl := flag.Bool("curl", false, "Show curl commands")
+	groups := flag.String("groups", "", "Comma-separated groups claim, e.g. admin")
+	tenant := flag.String("tenant", "", "Optional tenant claim for multi-tenant deployments")
 
 	flag.Parse()
 
@@ -33,11 +36,12 @@ func main() {
 		StandardClaims: jwt.StandardClaims{
 			Subject: *subject,
 		},
-		Name:  *name,
-		Email: *email,
-		Phone: *phone,
-		Sub:   *subject,
-	})
+		Name:   *name,
+		Email:  *email,
+		Phone:  *phone,
+		Sub:    *subject,
+		Tenant: *tenant,
+	}, splitGroups(*groups)...)
 	if err != nil {
 		log.Fatalf("failed to encode JWT: %v", err)
 	}
@@ -63,7 +67,7 @@ func main() {
 	fmt.Printf("  -d '{\"score\":%v}'\n\n", *score)
 
 	fmt.Println("# Watch websocket events (requires wscat or similar)")
-	fmt.Printf("wscat -c %s\n\n", *wsHost)
+	fmt.Printf("wscat -c \"%s?access_token=$TOKEN\"\n\n", *wsHost)
 
 	fmt.Println("# Fetch leaderboard segment")
 	fmt.Printf("curl -s -X GET %s/leaderboard \\\n", *host)
@@ -71,6 +75,16 @@ func main() {
 	fmt.Println("  -d '{\"from\":0,\"limit\":10}'")
 }
 
+func splitGroups(raw string) []string {
+	var groups []string
+	for _, g := range strings.Split(raw, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
 func randomSubject() string {
 	buf := make([]byte, 6)
 	if _, err := rand.Read(buf); err != nil {
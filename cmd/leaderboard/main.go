@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/privacy"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/transfer"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const usage = `Leaderboard admin tool. Uses the same configuration (STORE__*, REDIS__*) as the server.
+
+Usage:
+  leaderboard export [-scope global|quiz:<id>] [-format csv|json|ndjson] [-out file] [-tenant id]
+  leaderboard import [-scope global|quiz:<id>] [-format csv|json|ndjson] [-in file] [-mode merge|replace] [-dry-run] [-tenant id]
+  leaderboard snapshot create [-label text] [-tenant id]
+  leaderboard snapshot list [-tenant id]
+  leaderboard snapshot restore -id <snapshot> [-tenant id]
+  leaderboard reindex [-tenant id]
+  leaderboard migrate [-dry-run] [-tenant id]
+  leaderboard migrate-members [-dry-run] [-tenant id]
+  leaderboard user export -id <user> [-out file] [-tenant id]
+  leaderboard user erase -id <user> [-mode erase|anonymize] [-out file] [-tenant id]
+`
+
+const (
+	modeMerge   = "merge"
+	modeReplace = "replace"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "snapshot":
+		err = runSnapshot(os.Args[2:])
+	case "reindex":
+		err = runReindex(os.Args[2:])
+	case "migrate":
+		err = runMigrate(os.Args[2:])
+	case "migrate-members":
+		err = runMigrateMembers(os.Args[2:])
+	case "user":
+		err = runUser(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	scopeFlag := fs.String("scope", string(repositories.GlobalScope), "Leaderboard scope: global or quiz:<id>")
+	formatFlag := fs.String("format", "", "Output format: csv, json or ndjson (default from -out extension, else json)")
+	out := fs.String("out", "", "Output file (default stdout)")
+	tenant := fs.String("tenant", "", "Tenant whose leaderboard to export")
+	fs.Parse(args)
+
+	scope, err := repositories.ParseScope(*scopeFlag)
+	if err != nil {
+		return err
+	}
+	format, err := transfer.ParseFormat(*formatFlag, *out)
+	if err != nil {
+		return err
+	}
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+
+	store, closeFn, err := openScopeStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := transfer.Export(ctx, store, scope, *tenant, format, w)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("exported %d entries from %s as %s", n, scope, format)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	scopeFlag := fs.String("scope", string(repositories.GlobalScope), "Leaderboard scope: global or quiz:<id>")
+	formatFlag := fs.String("format", "", "Input format: csv, json or ndjson (default from -in extension, else json)")
+	in := fs.String("in", "", "Input file (default stdin)")
+	mode := fs.String("mode", modeMerge, "merge adds entries; replace removes every existing entry of the scope first")
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing")
+	tenant := fs.String("tenant", "", "Tenant whose leaderboard to import into")
+	fs.Parse(args)
+
+	scope, err := repositories.ParseScope(*scopeFlag)
+	if err != nil {
+		return err
+	}
+	format, err := transfer.ParseFormat(*formatFlag, *in)
+	if err != nil {
+		return err
+	}
+	if *mode != modeMerge && *mode != modeReplace {
+		return fmt.Errorf("mode must be %s or %s", modeMerge, modeReplace)
+	}
+	replace := *mode == modeReplace
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	entries, err := transfer.Decode(format, r)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", format, err)
+	}
+	for _, entry := range entries {
+		if !scope.Contains(entry) {
+			return fmt.Errorf("entry for user %q belongs to quiz %q, outside scope %s", entry.UserID, entry.QuizID, scope)
+		}
+	}
+
+	store, closeFn, err := openScopeStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	current, err := transfer.ReadScope(ctx, store, scope)
+	if err != nil {
+		return fmt.Errorf("read leaderboard: %w", err)
+	}
+	plan := transfer.PlanImport(current, entries, replace)
+
+	summary, _ := json.Marshal(plan)
+	if *dryRun {
+		log.Printf("dry run: %s import into %s would apply %s", *mode, scope, summary)
+		return nil
+	}
+
+	if err := store.ImportScores(ctx, scope, entries, replace); err != nil {
+		return err
+	}
+
+	log.Printf("%s import into %s applied %s", *mode, scope, summary)
+	return nil
+}
+
+func runSnapshot(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ExitOnError)
+	label := fs.String("label", "", "Free-form note stored with the snapshot")
+	id := fs.String("id", "", "Snapshot to restore")
+	tenant := fs.String("tenant", "", "Tenant whose leaderboards to snapshot")
+	fs.Parse(args[1:])
+
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	if backend.Snapshots == nil {
+		return fmt.Errorf("store driver does not support snapshots")
+	}
+
+	switch args[0] {
+	case "create":
+		meta, err := backend.Snapshots.Create(ctx, *label)
+		if err != nil {
+			return err
+		}
+		log.Printf("created snapshot %s with %d entries and %d memberships", meta.ID, meta.Entries, meta.Memberships)
+
+	case "list":
+		metas, err := backend.Snapshots.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, meta := range metas {
+			fmt.Printf("%s\t%d entries\t%d memberships\t%s\n", meta.ID, meta.Entries, meta.Memberships, meta.Label)
+		}
+
+	case "restore":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		meta, err := backend.Snapshots.Restore(ctx, *id)
+		if err != nil {
+			return err
+		}
+		log.Printf("restored snapshot %s with %d entries and %d memberships", meta.ID, meta.Entries, meta.Memberships)
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// runReindex backfills the user index that moderation uses to remove a user's entries.
+func runReindex(args []string) error {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	tenant := fs.String("tenant", "", "Tenant whose leaderboard to reindex")
+	fs.Parse(args)
+
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	moderator, ok := backend.Repo.(repositories.Moderator)
+	if !ok {
+		return fmt.Errorf("store driver does not support moderation")
+	}
+
+	indexed, err := moderator.ReindexUsers(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("indexed %d entries", indexed)
+	return nil
+}
+
+// runMigrate applies the pending schema migrations of a tenant and prints the report.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report pending migrations without applying them")
+	tenant := fs.String("tenant", "", "Tenant whose keys to migrate")
+	fs.Parse(args)
+
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	runner, err := backend.Migrations(cfg.Migrations)
+	if err != nil {
+		return err
+	}
+	if runner == nil {
+		return fmt.Errorf("store driver keeps no Redis schema to migrate")
+	}
+
+	report, err := runner.Run(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// runMigrateMembers converts leaderboard members written as entry JSON to compact IDs.
+func runMigrateMembers(args []string) error {
+	fs := flag.NewFlagSet("migrate-members", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Count legacy members without converting them")
+	tenant := fs.String("tenant", "", "Tenant whose leaderboard to migrate")
+	fs.Parse(args)
+
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	migrator, ok := backend.Repo.(repositories.MemberMigrator)
+	if !ok {
+		return fmt.Errorf("store driver does not support member migration")
+	}
+
+	converted, err := migrator.MigrateMembers(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		log.Printf("dry run: %d entries to convert", converted)
+		return nil
+	}
+	log.Printf("converted %d entries", converted)
+	return nil
+}
+
+// runUser answers data-subject requests: export prints everything stored about a user and
+// erase removes it, printing the sealed erasure report.
+func runUser(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	id := fs.String("id", "", "User whose data to export or erase")
+	mode := fs.String("mode", string(privacy.ModeErase), "erase deletes the user's entries; anonymize keeps them under a random id")
+	out := fs.String("out", "", "Output file (default stdout)")
+	tenant := fs.String("tenant", "", "Tenant holding the user's data")
+	fs.Parse(args[1:])
+
+	if *id == "" {
+		return fmt.Errorf("-id is required")
+	}
+
+	cfg := configs.Load()
+	ctx, err := tenantContext(cfg.Tenancy, *tenant)
+	if err != nil {
+		return err
+	}
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	source, ok := backend.Repo.(privacy.Source)
+	if !ok {
+		return fmt.Errorf("store driver does not support privacy requests")
+	}
+	history, _ := backend.Bus.(events.Purger)
+	service := privacy.NewService(source, backend.Snapshots, history)
+
+	var result any
+	switch args[0] {
+	case "export":
+		if result, err = service.Export(ctx, *id); err != nil {
+			return err
+		}
+
+	case "erase":
+		eraseMode, err := privacy.ParseMode(*mode)
+		if err != nil {
+			return err
+		}
+		report, err := service.Erase(ctx, *id, eraseMode)
+		if err != nil {
+			return err
+		}
+		if !report.Verified {
+			log.Printf("erasure of user %s left data behind; see the report", *id)
+		}
+		result = report
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+func openScopeStore(cfg *configs.Config) (repositories.ScopeStore, func() error, error) {
+	backend, err := bootstrap.Open(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store, ok := backend.Repo.(repositories.ScopeStore)
+	if !ok {
+		backend.Close()
+		return nil, nil, fmt.Errorf("store driver does not support leaderboard export/import")
+	}
+	return store, backend.Close, nil
+}
+
+// tenantContext resolves the -tenant flag against the configured tenants, so their overrides
+// apply and a mistyped ID is rejected rather than addressing an empty keyspace.
+func tenantContext(cfg configs.TenancyConfig, id string) (context.Context, error) {
+	tenants, err := bootstrap.Tenants(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		if tenant.ID == id {
+			return pkg.WithTenant(context.Background(), tenant), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown tenant %q", id)
+}
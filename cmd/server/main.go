@@ -2,36 +2,74 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/redis/go-redis/v9"
-
 	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/migrations"
 	"github.com/sunary/emu-game/internal/repositories"
 	"github.com/sunary/emu-game/internal/server"
+	"github.com/sunary/emu-game/pkg"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	cfg := configs.Load()
-
-	redis, err := initRedis(cfg.Redis)
+	tenants, err := bootstrap.Tenants(cfg.Tenancy)
 	if err != nil {
-		log.Fatalf("failed to initialize score repository: %v", err)
+		log.Fatalf("failed to load tenants: %v", err)
 	}
-	defer redis.Close()
 
-	repo, err := repositories.NewRedisRepository(redis)
+	backend, err := bootstrap.Open(cfg)
 	if err != nil {
 		log.Fatalf("failed to initialize score repository: %v", err)
 	}
+	defer backend.Close()
 
-	srv := server.New(ctx, cfg.Server.Addr, repo, redis)
+	if cfg.Migrations.OnStart {
+		runner, err := backend.Migrations(cfg.Migrations)
+		if err != nil {
+			log.Fatalf("failed to initialize schema migrations: %v", err)
+		}
+		if runner != nil {
+			for _, tenant := range tenants {
+				report, err := runner.Run(pkg.WithTenant(ctx, tenant), false)
+				switch {
+				case errors.Is(err, migrations.ErrSchemaAhead):
+					// A rollback: the newer layout stays readable or the newer binary returns.
+					log.Printf("tenant %q schema is at version %d, newer than %d", tenant.ID, report.From, runner.Latest())
+				case err != nil:
+					log.Fatalf("failed to migrate tenant %q: %v", tenant.ID, err)
+				case len(report.Steps) > 0:
+					log.Printf("migrated tenant %q schema from version %d to %d", tenant.ID, report.From, report.To)
+				}
+			}
+		}
+	}
+
+	if cachedRepo, ok := backend.Repo.(*repositories.CachedRepository); ok && cfg.Store.RebuildOnStart {
+		for _, tenant := range tenants {
+			report, err := cachedRepo.Rebuild(pkg.WithTenant(ctx, tenant))
+			if err != nil {
+				log.Fatalf("failed to rebuild tenant %q leaderboard cache: %v", tenant.ID, err)
+			}
+			log.Printf("rebuilt tenant %q leaderboard cache: %v", tenant.ID, report.Boards)
+		}
+	}
+
+	if backend.Snapshots != nil && cfg.Snapshots.Interval > 0 {
+		go backend.Snapshots.Schedule(ctx, cfg.Snapshots.Interval, cfg.Snapshots.Retain, tenants)
+	}
+
+	srv, err := server.New(ctx, cfg, backend)
+	if err != nil {
+		log.Fatalf("failed to initialize server: %v", err)
+	}
 
 	log.Printf("game server listening on %s", cfg.Server.Addr)
 
@@ -67,15 +105,3 @@ func main() {
 		}
 	}
 }
-
-func initRedis(cfg configs.RedisConfig) (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
-	if err := client.Ping(context.Background()).Err(); err != nil {
-		return nil, fmt.Errorf("redis ping failed: %w", err)
-	}
-	return client, nil
-}
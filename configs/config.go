@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -15,19 +16,126 @@ import (
 var defaultConfig []byte
 
 type Config struct {
-	Server Server      `yaml:"server" mapstructure:"server"`
-	Redis  RedisConfig `yaml:"redis" mapstructure:"redis"`
+	Server     Server          `yaml:"server" mapstructure:"server"`
+	Redis      RedisConfig     `yaml:"redis" mapstructure:"redis"`
+	Store      StoreConfig     `yaml:"store" mapstructure:"store"`
+	Tenancy    TenancyConfig   `yaml:"tenancy" mapstructure:"tenancy"`
+	Snapshots  SnapshotConfig  `yaml:"snapshots" mapstructure:"snapshots"`
+	Migrations MigrationConfig `yaml:"migrations" mapstructure:"migrations"`
+	Events     EventsConfig    `yaml:"events" mapstructure:"events"`
+	Presence   PresenceConfig  `yaml:"presence" mapstructure:"presence"`
 }
 
 type Server struct {
-	Addr string `yaml:"addr" mapstructure:"addr"`
+	Addr             string                 `yaml:"addr" mapstructure:"addr"`
+	LeaderboardCache LeaderboardCacheConfig `yaml:"leaderboard_cache" mapstructure:"leaderboard_cache"`
+	Websocket        WebsocketConfig        `yaml:"websocket" mapstructure:"websocket"`
+	SSE              SSEConfig              `yaml:"sse" mapstructure:"sse"`
 }
 
-// RedisConfig ...
+// WebsocketConfig controls who may hold a /ws connection. With RequireAuth, a connection
+// that offered no token on the upgrade must send an auth message within AuthTimeout;
+// otherwise anonymous connections receive the public events of their tenant.
+//
+// Each connection buffers up to SendQueue events. SlowConsumer decides what happens when
+// its queue is full: drop_oldest, drop_newest or disconnect.
+//
+// DeltaTop is how many ranks of a scope leaderboard topics follow; 0 disables them.
+//
+// Compression enables permessage-deflate for clients that offer it.
+type WebsocketConfig struct {
+	RequireAuth  bool          `yaml:"require_auth" mapstructure:"require_auth"`
+	AuthTimeout  time.Duration `yaml:"auth_timeout" mapstructure:"auth_timeout"`
+	SendQueue    int           `yaml:"send_queue" mapstructure:"send_queue"`
+	SlowConsumer string        `yaml:"slow_consumer" mapstructure:"slow_consumer"`
+	DeltaTop     int           `yaml:"delta_top" mapstructure:"delta_top"`
+	Compression  bool          `yaml:"compression" mapstructure:"compression"`
+}
+
+// SSEConfig tunes the /events stream. Streams share the websocket hub, so RequireAuth,
+// SendQueue, SlowConsumer and DeltaTop of WebsocketConfig apply to them too. A comment is
+// sent after KeepAlive without events, so proxies keep idle streams open.
+type SSEConfig struct {
+	KeepAlive time.Duration `yaml:"keep_alive" mapstructure:"keep_alive"`
+}
+
+// LeaderboardCacheConfig bounds the in-process cache of leaderboard slices. Only slices that
+// end within the top MaxRank entries are cached, at most Entries of them; Entries of 0
+// disables the cache.
+type LeaderboardCacheConfig struct {
+	Entries int   `yaml:"entries" mapstructure:"entries"`
+	MaxRank int64 `yaml:"max_rank" mapstructure:"max_rank"`
+}
+
+// RedisConfig selects the deployment topology through Mode:
+//   - "standalone" (default): a single server at Addr.
+//   - "sentinel": Sentinel-managed failover; Addrs lists the sentinels and MasterName the monitored master.
+//   - "cluster": Redis Cluster; Addrs lists the seed nodes and DB must be 0.
 type RedisConfig struct {
-	Addr     string `yaml:"addr" mapstructure:"addr"`
-	Password string `yaml:"password" mapstructure:"password"`
-	DB       int    `yaml:"db" mapstructure:"db"`
+	Mode             string   `yaml:"mode" mapstructure:"mode"`
+	Addr             string   `yaml:"addr" mapstructure:"addr"`
+	Addrs            []string `yaml:"addrs" mapstructure:"addrs"`
+	MasterName       string   `yaml:"master_name" mapstructure:"master_name"`
+	SentinelPassword string   `yaml:"sentinel_password" mapstructure:"sentinel_password"`
+	Password         string   `yaml:"password" mapstructure:"password"`
+	DB               int      `yaml:"db" mapstructure:"db"`
+}
+
+// StoreConfig selects the repository backend: "redis" (default), "bolt" for a
+// single-binary deployment that keeps everything in a local file, or "durable" to record
+// scores in the local file and serve the leaderboard from Redis as a rebuildable cache.
+type StoreConfig struct {
+	Driver         string `yaml:"driver" mapstructure:"driver"`
+	Path           string `yaml:"path" mapstructure:"path"`
+	RebuildOnStart bool   `yaml:"rebuild_on_start" mapstructure:"rebuild_on_start"`
+}
+
+// TenancyConfig enables per-organization isolation. The tenant of a request comes from the
+// JWT "tenant" claim or, failing that, from the Host header via the Hosts lists below.
+type TenancyConfig struct {
+	Enabled bool                    `yaml:"enabled" mapstructure:"enabled"`
+	Tenants map[string]TenantConfig `yaml:"tenants" mapstructure:"tenants"`
+}
+
+// TenantConfig holds the host names and configuration overrides of one tenant; zero values
+// fall back to the global defaults.
+type TenantConfig struct {
+	Hosts            []string      `yaml:"hosts" mapstructure:"hosts"`
+	MembershipTTL    time.Duration `yaml:"membership_ttl" mapstructure:"membership_ttl"`
+	LeaderboardLimit int64         `yaml:"leaderboard_limit" mapstructure:"leaderboard_limit"`
+}
+
+// SnapshotConfig selects where leaderboard snapshots are kept: "dir" (default) writes JSON
+// files under Dir, "redis" stores them next to the leaderboards. A positive Interval takes a
+// snapshot of every tenant on that schedule, keeping the newest Retain of each (0 keeps all).
+type SnapshotConfig struct {
+	Storage  string        `yaml:"storage" mapstructure:"storage"`
+	Dir      string        `yaml:"dir" mapstructure:"dir"`
+	Interval time.Duration `yaml:"interval" mapstructure:"interval"`
+	Retain   int           `yaml:"retain" mapstructure:"retain"`
+}
+
+// MigrationConfig controls the Redis schema migrations. With OnStart the server applies the
+// pending migrations of every tenant before serving. LockTTL bounds how long a crashed
+// instance can block the others; LockWait is how long an instance waits for another one
+// that is migrating.
+type MigrationConfig struct {
+	OnStart  bool          `yaml:"on_start" mapstructure:"on_start"`
+	LockTTL  time.Duration `yaml:"lock_ttl" mapstructure:"lock_ttl"`
+	LockWait time.Duration `yaml:"lock_wait" mapstructure:"lock_wait"`
+}
+
+// EventsConfig bounds the event history kept per tenant for clients that resume after a
+// disconnect. Retention is a number of events; Redis keeps about that many.
+type EventsConfig struct {
+	Retention int `yaml:"retention" mapstructure:"retention"`
+}
+
+// PresenceConfig controls websocket presence. Every Heartbeat each instance renews its claim
+// on the users it holds; the users of an instance silent for TTL are taken offline.
+type PresenceConfig struct {
+	Heartbeat time.Duration `yaml:"heartbeat" mapstructure:"heartbeat"`
+	TTL       time.Duration `yaml:"ttl" mapstructure:"ttl"`
 }
 
 func Load() *Config {
@@ -0,0 +1,234 @@
+// Package bootstrap builds the storage backends selected by configuration, shared by the
+// server and the admin tools so they always agree on drivers and key layout.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/migrations"
+	"github.com/sunary/emu-game/internal/presence"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	StoreDriverRedis   = "redis"
+	StoreDriverBolt    = "bolt"
+	StoreDriverDurable = "durable"
+
+	SnapshotStorageDir   = "dir"
+	SnapshotStorageRedis = "redis"
+
+	redisModeSentinel = "sentinel"
+	redisModeCluster  = "cluster"
+)
+
+// Backend is the repository and event bus for the configured store driver.
+type Backend struct {
+	Repo repositories.Repository
+	Bus  events.Bus
+	// Redis is nil for the bolt driver.
+	Redis redis.UniversalClient
+	// Snapshots is nil when the repository cannot be snapshotted.
+	Snapshots *snapshots.Manager
+	// Presence is shared through Redis, or kept in memory for the bolt driver.
+	Presence presence.Store
+
+	closers []func() error
+}
+
+func Open(cfg *configs.Config) (*Backend, error) {
+	b := &Backend{}
+
+	switch cfg.Store.Driver {
+	case StoreDriverBolt:
+		boltRepo, err := repositories.NewBoltRepository(cfg.Store.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open bolt store: %w", err)
+		}
+		b.closers = append(b.closers, boltRepo.Close)
+
+		b.Repo = boltRepo
+		b.Bus = events.NewLocalBus(cfg.Events.Retention)
+
+	case StoreDriverDurable:
+		boltRepo, err := repositories.NewBoltRepository(cfg.Store.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open durable score store: %w", err)
+		}
+		b.closers = append(b.closers, boltRepo.Close)
+
+		redisRepo, err := b.openRedis(cfg.Redis)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+
+		b.Repo = repositories.NewCachedRepository(boltRepo, redisRepo)
+		b.Bus = events.NewRedisBus(b.Redis, int64(cfg.Events.Retention))
+
+	case StoreDriverRedis, "":
+		redisRepo, err := b.openRedis(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Repo = redisRepo
+		b.Bus = events.NewRedisBus(b.Redis, int64(cfg.Events.Retention))
+
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", cfg.Store.Driver)
+	}
+
+	b.closers = append(b.closers, b.Bus.Close)
+
+	if err := b.openPresence(cfg.Presence); err != nil {
+		b.Close()
+		return nil, err
+	}
+	if err := b.openSnapshots(cfg.Snapshots); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Backend) openPresence(cfg configs.PresenceConfig) error {
+	if b.Redis == nil {
+		b.Presence = presence.NewLocalStore()
+		return nil
+	}
+	store, err := presence.NewRedisStore(b.Redis, cfg.TTL)
+	if err != nil {
+		return fmt.Errorf("open presence: %w", err)
+	}
+	b.Presence = store
+	return nil
+}
+
+func (b *Backend) openSnapshots(cfg configs.SnapshotConfig) error {
+	source, ok := b.Repo.(snapshots.Source)
+	if !ok {
+		return nil
+	}
+
+	var store snapshots.Store
+	switch cfg.Storage {
+	case SnapshotStorageDir, "":
+		store = snapshots.NewDirStore(cfg.Dir)
+	case SnapshotStorageRedis:
+		if b.Redis == nil {
+			return fmt.Errorf("snapshot storage %q requires a redis store driver", cfg.Storage)
+		}
+		store = snapshots.NewRedisStore(b.Redis)
+	default:
+		return fmt.Errorf("unknown snapshot storage %q", cfg.Storage)
+	}
+
+	b.Snapshots = snapshots.NewManager(source, store, b.Bus)
+	return nil
+}
+
+func (b *Backend) openRedis(cfg configs.RedisConfig) (*repositories.RedisRepository, error) {
+	client, err := NewRedis(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b.Redis = client
+	b.closers = append(b.closers, client.Close)
+
+	return repositories.NewRedisRepository(client)
+}
+
+// Migrations returns the schema migration runner of the Redis key layout, or nil for the
+// bolt driver, which keeps no Redis keys.
+func (b *Backend) Migrations(cfg configs.MigrationConfig) (*migrations.Runner, error) {
+	if b.Redis == nil {
+		return nil, nil
+	}
+
+	steps, err := migrations.ForRepository(b.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return migrations.NewRunner(b.Redis, steps, cfg.LockTTL, cfg.LockWait)
+}
+
+// Close releases every resource in reverse order of acquisition.
+func (b *Backend) Close() error {
+	var errs []error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	b.closers = nil
+	return errors.Join(errs...)
+}
+
+// Tenants lists the default tenant followed by every configured tenant, with overrides applied.
+// It fails on a configured tenant ID that would be unsafe in keys and channels.
+func Tenants(cfg configs.TenancyConfig) ([]pkg.Tenant, error) {
+	ids := make([]string, 0, len(cfg.Tenants))
+	for id := range cfg.Tenants {
+		if !pkg.ValidTenantID(id) {
+			return nil, fmt.Errorf("invalid tenant ID %q", id)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tenants := []pkg.Tenant{{}}
+	if !cfg.Enabled {
+		return tenants, nil
+	}
+
+	for _, id := range ids {
+		tenants = append(tenants, pkg.Tenant{
+			ID:               id,
+			MembershipTTL:    cfg.Tenants[id].MembershipTTL,
+			LeaderboardLimit: cfg.Tenants[id].LeaderboardLimit,
+		})
+	}
+	return tenants, nil
+}
+
+// NewRedis builds a universal client for the configured topology and checks connectivity.
+func NewRedis(cfg configs.RedisConfig) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	switch cfg.Mode {
+	case redisModeSentinel:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+		})
+	case redisModeCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    cfg.Addrs,
+			Password: cfg.Password,
+		})
+	default:
+		client = redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+	}
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis ping failed: %w", err)
+	}
+	return client, nil
+}
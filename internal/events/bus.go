@@ -0,0 +1,348 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	RedisChannel = "emu-game:events"
+
+	tenantChannelPrefix = "emu-game:tenant:"
+	tenantChannelSuffix = ":events"
+
+	localBufferSize = 256
+
+	// DefaultRetention is how many recent events of a tenant are kept for replay.
+	DefaultRetention = 10000
+)
+
+// Message is an event payload together with the tenant it was published for. Seq numbers
+// the tenant's events from 1 without gaps; it is 0 for events of publishers that predate it.
+type Message struct {
+	Tenant  string
+	Seq     uint64
+	Payload []byte
+}
+
+// Bus fans out raw event payloads to every subscriber, across instances when backed by Redis.
+// Publish scopes the event to the tenant of ctx; subscribers receive every tenant's events
+// and are responsible for routing them.
+type Bus interface {
+	Publish(ctx context.Context, payload []byte) error
+	Subscribe(ctx context.Context) (<-chan Message, error)
+	Close() error
+}
+
+// TenantChannel names the Pub/Sub channel of a tenant; the default tenant keeps RedisChannel.
+func TenantChannel(tenant string) string {
+	if tenant == "" {
+		return RedisChannel
+	}
+	return tenantChannelPrefix + tenant + tenantChannelSuffix
+}
+
+func channelTenant(channel string) string {
+	if channel == RedisChannel {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(channel, tenantChannelPrefix), tenantChannelSuffix)
+}
+
+// Replayer is implemented by buses that retain recent events. Replay returns up to limit
+// events of the tenant of ctx numbered after seq, oldest first. complete is false when
+// retention already dropped some of the events following seq.
+type Replayer interface {
+	Replay(ctx context.Context, after uint64, limit int64) (msgs []Message, complete bool, err error)
+}
+
+// Purger is implemented by buses that retain recent events. Purge deletes the retained
+// events of the tenant of ctx about userID, and the notifications waiting in their inbox, and
+// returns how many it deleted. The numbering of the other events is unchanged; replays skip
+// the deleted ones.
+type Purger interface {
+	Purge(ctx context.Context, userID string) (int, error)
+}
+
+// Announcer is implemented by buses that can relay an event live without numbering or
+// retaining it. Subscribers receive it with Seq 0.
+type Announcer interface {
+	Announce(ctx context.Context, payload []byte) error
+}
+
+// RedisBus numbers every event and appends it to a capped Redis Stream of its tenant, then
+// relays it through Pub/Sub so every server instance receives it live. Subscribers that see
+// a hole in the numbering, e.g. after a reconnect, fill it from the stream.
+type RedisBus struct {
+	client    redis.UniversalClient
+	channel   string
+	retention int64
+}
+
+// NewRedisBus accepts any universal client and keeps about retention events per tenant
+// (DefaultRetention when 0). PUBLISH is propagated to every node of a Cluster, so the
+// channel needs no hash tag; the counter and stream share the tenant's slot.
+func NewRedisBus(client redis.UniversalClient, retention int64) *RedisBus {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &RedisBus{client: client, channel: RedisChannel, retention: retention}
+}
+
+func (b *RedisBus) Publish(ctx context.Context, payload []byte) error {
+	tenant := pkg.GetTenant(ctx).ID
+	keys := repositories.KeyspaceFor(b.client, tenant)
+	return publishScript.Run(ctx, b.client, []string{keys.EventSeq(), keys.EventStream(), keys.EventFloor()},
+		payload, b.retention, TenantChannel(tenant)).Err()
+}
+
+func (b *RedisBus) Announce(ctx context.Context, payload []byte) error {
+	return b.client.Publish(ctx, TenantChannel(pkg.GetTenant(ctx).ID), payload).Err()
+}
+
+func (b *RedisBus) Replay(ctx context.Context, after uint64, limit int64) ([]Message, bool, error) {
+	return b.replay(ctx, pkg.GetTenant(ctx).ID, after, limit)
+}
+
+func (b *RedisBus) Purge(ctx context.Context, userID string) (int, error) {
+	removed, err := b.purge(ctx, pkg.GetTenant(ctx).ID, userID)
+	if err != nil {
+		return removed, err
+	}
+	held, err := b.purgeInbox(ctx, userID)
+	return removed + held, err
+}
+
+func (b *RedisBus) Subscribe(ctx context.Context) (<-chan Message, error) {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	if err := pubsub.PSubscribe(ctx, TenantChannel("*")); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	// Wait for both subscription confirmations so events published right after are not missed.
+	for i := 0; i < 2; i++ {
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return nil, err
+		}
+	}
+
+	out := make(chan Message)
+	go func() {
+		defer close(out)
+		defer pubsub.Close()
+
+		// last holds the latest sequence delivered per tenant, to spot missed events.
+		last := make(map[string]uint64)
+		emit := func(msg Message) bool {
+			select {
+			case out <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case raw, ok := <-ch:
+				if !ok {
+					return
+				}
+				msg := decodeMessage(channelTenant(raw.Channel), raw.Payload)
+				if msg.Seq == 0 {
+					if !emit(msg) {
+						return
+					}
+					continue
+				}
+
+				prev, seen := last[msg.Tenant]
+				if seen && msg.Seq <= prev {
+					continue
+				}
+				if seen && msg.Seq > prev+1 {
+					missed, err := b.backfill(ctx, msg.Tenant, prev, msg.Seq)
+					if err != nil {
+						log.Printf("failed to backfill events %d-%d of tenant %q: %v", prev+1, msg.Seq-1, msg.Tenant, err)
+					}
+					for _, m := range missed {
+						if !emit(m) {
+							return
+						}
+					}
+				}
+				last[msg.Tenant] = msg.Seq
+				if !emit(msg) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *RedisBus) Close() error {
+	return nil
+}
+
+// LocalBus delivers events in-process; used for single-binary deployments without Redis.
+// It numbers events like RedisBus and keeps the latest retention of each tenant in memory.
+type LocalBus struct {
+	mu        sync.RWMutex
+	subs      map[chan Message]struct{}
+	closed    bool
+	retention int
+	seq       map[string]uint64
+	history   map[string][]Message
+	// floor is the highest sequence of each tenant that retention dropped.
+	floor map[string]uint64
+	// inbox holds the kept notifications of each tenant by user.
+	inbox map[string]map[string][][]byte
+}
+
+// NewLocalBus keeps retention events per tenant for replay (DefaultRetention when 0). Its
+// history and inboxes live in memory and do not survive a restart.
+func NewLocalBus(retention int) *LocalBus {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &LocalBus{
+		subs:      make(map[chan Message]struct{}),
+		retention: retention,
+		seq:       make(map[string]uint64),
+		history:   make(map[string][]Message),
+		floor:     make(map[string]uint64),
+		inbox:     make(map[string]map[string][][]byte),
+	}
+}
+
+func (b *LocalBus) Publish(ctx context.Context, payload []byte) error {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq[tenant]++
+	msg := Message{Tenant: tenant, Seq: b.seq[tenant], Payload: payload}
+	history := append(b.history[tenant], msg)
+	if drop := len(history) - b.retention; drop > 0 {
+		b.floor[tenant] = history[drop-1].Seq
+		history = history[drop:]
+	}
+	b.history[tenant] = history
+
+	for ch := range b.subs {
+		// Like Redis Pub/Sub, delivery is best-effort: a subscriber that falls behind loses events
+		// instead of blocking the publisher.
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Announce(ctx context.Context, payload []byte) error {
+	msg := Message{Tenant: pkg.GetTenant(ctx).ID, Payload: payload}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Replay(ctx context.Context, after uint64, limit int64) ([]Message, bool, error) {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if after >= b.seq[tenant] {
+		return nil, after == b.seq[tenant], nil
+	}
+	// Purges leave holes in the history, so look the position up rather than computing it;
+	// holes above the floor are not gaps.
+	history := b.history[tenant]
+	start := sort.Search(len(history), func(i int) bool { return history[i].Seq > after })
+	end := min(len(history), start+int(limit))
+	return append([]Message(nil), history[start:end]...), after >= b.floor[tenant], nil
+}
+
+func (b *LocalBus) Purge(ctx context.Context, userID string) (int, error) {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.history[tenant][:0]
+	for _, msg := range b.history[tenant] {
+		if !mentions(msg.Payload, userID) {
+			kept = append(kept, msg)
+		}
+	}
+	removed := len(b.history[tenant]) - len(kept)
+	clear(b.history[tenant][len(kept):])
+	b.history[tenant] = kept
+
+	removed += len(b.inbox[tenant][userID])
+	delete(b.inbox[tenant], userID)
+	return removed, nil
+}
+
+func (b *LocalBus) Subscribe(ctx context.Context) (<-chan Message, error) {
+	ch := make(chan Message, localBufferSize)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, nil
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.unsubscribe(ch)
+	}()
+
+	return ch, nil
+}
+
+func (b *LocalBus) unsubscribe(ch chan Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+func (b *LocalBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	b.closed = true
+	return nil
+}
@@ -0,0 +1,283 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/pkg"
+)
+
+func receive(t *testing.T, ch <-chan Message) Message {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+		return Message{}
+	}
+}
+
+func TestLocalBusFanOut(t *testing.T) {
+	bus := NewLocalBus(0)
+	defer bus.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+	second, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, bus.Publish(ctx, []byte(`{"event":"ping"}`)))
+
+	require.Equal(t, Message{Seq: 1, Payload: []byte(`{"event":"ping"}`)}, receive(t, first))
+	require.Equal(t, Message{Seq: 1, Payload: []byte(`{"event":"ping"}`)}, receive(t, second))
+}
+
+func TestLocalBusUnsubscribeOnCancel(t *testing.T) {
+	bus := NewLocalBus(0)
+	defer bus.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-ch:
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRedisBusPublishSubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	bus := NewRedisBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, bus.Publish(ctx, []byte(`{"event":"ping"}`)))
+	require.Equal(t, Message{Seq: 1, Payload: []byte(`{"event":"ping"}`)}, receive(t, ch))
+
+	require.NoError(t, bus.Publish(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}), []byte(`{"event":"pong"}`)))
+	require.Equal(t, Message{Tenant: "acme", Seq: 1, Payload: []byte(`{"event":"pong"}`)}, receive(t, ch), "tenants are numbered independently")
+}
+
+func TestLocalBusCarriesTenant(t *testing.T) {
+	bus := NewLocalBus(0)
+	defer bus.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, bus.Publish(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}), []byte(`{}`)))
+	require.Equal(t, Message{Tenant: "acme", Seq: 1, Payload: []byte(`{}`)}, receive(t, ch))
+}
+
+func TestTenantChannel(t *testing.T) {
+	require.Equal(t, "emu-game:events", TenantChannel(""))
+	require.Equal(t, "emu-game:tenant:acme:events", TenantChannel("acme"))
+	require.Equal(t, "acme", channelTenant(TenantChannel("acme")))
+	require.Equal(t, "", channelTenant(TenantChannel("")))
+}
+
+func publishN(t *testing.T, ctx context.Context, bus Bus, n int) {
+	t.Helper()
+
+	for i := 1; i <= n; i++ {
+		require.NoError(t, bus.Publish(ctx, []byte(fmt.Sprintf(`{"n":%d}`, i))))
+	}
+}
+
+func seqs(msgs []Message) []uint64 {
+	out := make([]uint64, len(msgs))
+	for i, msg := range msgs {
+		out[i] = msg.Seq
+	}
+	return out
+}
+
+func TestReplayHonoursRetention(t *testing.T) {
+	mr := miniredis.RunT(t)
+	buses := map[string]interface {
+		Bus
+		Replayer
+	}{
+		"redis": NewRedisBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 2),
+		"local": NewLocalBus(2),
+	}
+
+	for name, bus := range buses {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			publishN(t, ctx, bus, 5)
+
+			msgs, complete, err := bus.Replay(ctx, 3, 10)
+			require.NoError(t, err)
+			require.True(t, complete)
+			require.Equal(t, []uint64{4, 5}, seqs(msgs))
+			require.Equal(t, `{"n":4}`, string(msgs[0].Payload))
+
+			msgs, complete, err = bus.Replay(ctx, 4, 1)
+			require.NoError(t, err)
+			require.True(t, complete)
+			require.Equal(t, []uint64{5}, seqs(msgs))
+
+			msgs, complete, err = bus.Replay(ctx, 1, 10)
+			require.NoError(t, err)
+			require.False(t, complete, "events 2 and 3 fell out of retention")
+			require.Equal(t, []uint64{4, 5}, seqs(msgs))
+
+			msgs, complete, err = bus.Replay(ctx, 5, 10)
+			require.NoError(t, err)
+			require.True(t, complete)
+			require.Empty(t, msgs)
+
+			_, complete, err = bus.Replay(ctx, 9, 10)
+			require.NoError(t, err)
+			require.False(t, complete, "a client ahead of the counter must reload")
+
+			_, complete, err = bus.Replay(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}), 0, 10)
+			require.NoError(t, err)
+			require.True(t, complete, "other tenants have their own history")
+		})
+	}
+}
+
+func TestRedisBusBackfillsMissedEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	bus := NewRedisBus(client, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+	publishN(t, ctx, bus, 1)
+	require.Equal(t, uint64(1), receive(t, ch).Seq)
+
+	// An event this instance never saw live, as if Pub/Sub dropped it during a reconnect.
+	require.NoError(t, client.Incr(ctx, "emu-game:events:seq").Err())
+	require.NoError(t, client.XAdd(ctx, &redis.XAddArgs{Stream: "emu-game:events:stream", ID: "2-0", Values: []string{"payload", `{"n":2}`}}).Err())
+
+	publishN(t, ctx, bus, 1)
+	missed := receive(t, ch)
+	require.Equal(t, Message{Seq: 2, Payload: []byte(`{"n":2}`)}, missed)
+	require.Equal(t, uint64(3), receive(t, ch).Seq)
+}
+
+func TestDecodeMessageAcceptsUnsequencedPayloads(t *testing.T) {
+	require.Equal(t, Message{Tenant: "acme", Seq: 7, Payload: []byte(`{"a":1}`)}, decodeMessage("acme", `7:{"a":1}`))
+	require.Equal(t, Message{Payload: []byte(`{"a":"1:2"}`)}, decodeMessage("", `{"a":"1:2"}`))
+}
+
+func TestPurgeDropsUserEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	buses := map[string]interface {
+		Bus
+		Replayer
+		Purger
+	}{
+		"redis": NewRedisBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0),
+		"local": NewLocalBus(0),
+	}
+
+	for name, bus := range buses {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, user := range []string{"user-1", "user-2", "user-1"} {
+				require.NoError(t, Publish(ctx, bus, SubmitQuiz, map[string]string{"user_id": user}))
+			}
+			require.NoError(t, Publish(ctx, bus, LeaderboardRestored, map[string]string{}))
+
+			removed, err := bus.Purge(ctx, "user-1")
+			require.NoError(t, err)
+			require.Equal(t, 2, removed)
+
+			msgs, complete, err := bus.Replay(ctx, 0, 10)
+			require.NoError(t, err)
+			require.True(t, complete, "purged events are not a gap")
+			require.Equal(t, []uint64{2, 4}, seqs(msgs))
+
+			removed, err = bus.Purge(ctx, "user-1")
+			require.NoError(t, err)
+			require.Zero(t, removed)
+		})
+	}
+}
+
+func TestInboxKeepsNotificationsUntilDelivered(t *testing.T) {
+	mr := miniredis.RunT(t)
+	buses := map[string]interface {
+		Bus
+		Inbox
+		Purger
+	}{
+		"redis": NewRedisBus(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0),
+		"local": NewLocalBus(0),
+	}
+
+	for name, bus := range buses {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first, err := Notify(ctx, bus, Notification{UserID: "user-1", Kind: "overtaken", Inbox: true})
+			require.NoError(t, err)
+			require.NotEmpty(t, first.ID)
+			_, err = Notify(ctx, bus, Notification{UserID: "user-1", Kind: "quiz_ending", Inbox: true})
+			require.NoError(t, err)
+			_, err = Notify(ctx, bus, Notification{UserID: "user-1", Kind: "live_only"})
+			require.NoError(t, err)
+
+			payload, err := json.Marshal(first)
+			require.NoError(t, err)
+			require.NoError(t, bus.Delivered(ctx, "user-1", payload))
+
+			waiting, err := bus.Drain(ctx, "user-1")
+			require.NoError(t, err)
+			require.Len(t, waiting, 1)
+			require.Contains(t, string(waiting[0]), `"kind":"quiz_ending"`)
+
+			waiting, err = bus.Drain(ctx, "user-1")
+			require.NoError(t, err)
+			require.Empty(t, waiting)
+
+			for i := 0; i < InboxSize+5; i++ {
+				require.NoError(t, bus.Keep(ctx, "user-2", []byte(fmt.Sprintf(`{"n":%d}`, i))))
+			}
+			waiting, err = bus.Drain(ctx, "user-2")
+			require.NoError(t, err)
+			require.Len(t, waiting, InboxSize)
+			require.Equal(t, `{"n":5}`, string(waiting[0]), "the oldest are dropped first")
+
+			require.NoError(t, bus.Keep(ctx, "user-3", []byte(`{}`)))
+			waiting, err = bus.Drain(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}), "user-3")
+			require.NoError(t, err)
+			require.Empty(t, waiting, "inboxes belong to a tenant")
+
+			removed, err := bus.Purge(ctx, "user-3")
+			require.NoError(t, err)
+			require.Equal(t, 1, removed)
+		})
+	}
+}
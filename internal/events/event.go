@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	SubmitQuiz          = "submit_quiz_event"
+	LeaderboardRestored = "leaderboard_restored_event"
+	Moderation          = "moderation_event"
+	UserNotification    = "user_notification_event"
+	Presence            = "presence_event"
+)
+
+// Event is the envelope published on the bus and relayed to websocket clients.
+type Event struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func (m Event) MarshalBinary() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// Publish wraps data in an Event envelope and publishes it for the tenant of ctx.
+func Publish(ctx context.Context, bus Bus, name string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	payload, err := Event{Event: name, Data: raw}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return bus.Publish(ctx, payload)
+}
+
+// Announce is Publish for frequent events not worth replaying: on buses that implement
+// Announcer the event is relayed live without a sequence number and is not retained.
+func Announce(ctx context.Context, bus Bus, name string, data any) error {
+	announcer, ok := bus.(Announcer)
+	if !ok {
+		return Publish(ctx, bus, name, data)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	payload, err := Event{Event: name, Data: raw}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return announcer.Announce(ctx, payload)
+}
+
+// mentions reports whether an event concerns userID, going by the user_id of its data.
+func mentions(payload []byte, userID string) bool {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return false
+	}
+	var data struct {
+		UserID string `json:"user_id"`
+	}
+	return json.Unmarshal(event.Data, &data) == nil && data.UserID == userID
+}
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// InboxSize caps the notifications waiting for one user; the oldest are dropped first.
+	InboxSize = 100
+	// InboxTTL is how long a user's inbox outlives its latest notification.
+	InboxTTL = 7 * 24 * time.Hour
+)
+
+// ErrNoInbox is returned by Notify for a notification to keep on a bus without inboxes.
+var ErrNoInbox = errors.New("event bus keeps no inbox")
+
+// Notification is a message for one user, delivered to every connection they hold on
+// whichever instance holds it.
+type Notification struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"user_id"`
+	Kind      string          `json:"kind"`
+	Message   string          `json:"message,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	// Inbox keeps the notification until a connection of the user receives it, so a user
+	// with no connection gets it when they next connect.
+	Inbox bool `json:"inbox,omitempty"`
+}
+
+// Inbox is implemented by buses that hold notifications for users until they connect.
+// Payloads are the data of UserNotification events exactly as published; all methods act
+// on the tenant of ctx.
+type Inbox interface {
+	Keep(ctx context.Context, userID string, payload []byte) error
+	// Delivered removes a kept notification once a connection of its user received it.
+	Delivered(ctx context.Context, userID string, payload []byte) error
+	// Drain removes and returns the notifications waiting for a user, oldest first.
+	Drain(ctx context.Context, userID string) ([][]byte, error)
+}
+
+// Notify publishes a notification for the tenant of ctx, filling in its ID and time when
+// unset. A notification with Inbox is kept before it is published, so it cannot be missed by
+// a user connecting meanwhile.
+func Notify(ctx context.Context, bus Bus, n Notification) (Notification, error) {
+	if n.ID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return n, err
+		}
+		n.ID = hex.EncodeToString(b)
+	}
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return n, err
+	}
+	if n.Inbox {
+		inbox, ok := bus.(Inbox)
+		if !ok {
+			return n, ErrNoInbox
+		}
+		if err := inbox.Keep(ctx, n.UserID, data); err != nil {
+			return n, fmt.Errorf("keep notification: %w", err)
+		}
+	}
+	return n, Publish(ctx, bus, UserNotification, json.RawMessage(data))
+}
+
+func (b *RedisBus) inboxKey(ctx context.Context, userID string) string {
+	return repositories.KeyspaceFor(b.client, pkg.GetTenant(ctx).ID).Inbox(userID)
+}
+
+func (b *RedisBus) Keep(ctx context.Context, userID string, payload []byte) error {
+	key := b.inboxKey(ctx, userID)
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.LTrim(ctx, key, -InboxSize, -1)
+		pipe.Expire(ctx, key, InboxTTL)
+		return nil
+	})
+	return err
+}
+
+func (b *RedisBus) Delivered(ctx context.Context, userID string, payload []byte) error {
+	return b.client.LRem(ctx, b.inboxKey(ctx, userID), 1, payload).Err()
+}
+
+func (b *RedisBus) Drain(ctx context.Context, userID string) ([][]byte, error) {
+	key := b.inboxKey(ctx, userID)
+	var waiting *redis.StringSliceCmd
+	if _, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		waiting = pipe.LRange(ctx, key, 0, -1)
+		pipe.Del(ctx, key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	payloads := make([][]byte, len(waiting.Val()))
+	for i, payload := range waiting.Val() {
+		payloads[i] = []byte(payload)
+	}
+	return payloads, nil
+}
+
+// purgeInbox deletes the inbox of a user and returns how many notifications it held.
+func (b *RedisBus) purgeInbox(ctx context.Context, userID string) (int, error) {
+	key := b.inboxKey(ctx, userID)
+	var held *redis.IntCmd
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		held = pipe.LLen(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	return int(held.Val()), err
+}
+
+func (b *LocalBus) Keep(ctx context.Context, userID string, payload []byte) error {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	inboxes, ok := b.inbox[tenant]
+	if !ok {
+		inboxes = make(map[string][][]byte)
+		b.inbox[tenant] = inboxes
+	}
+	waiting := append(inboxes[userID], payload)
+	if drop := len(waiting) - InboxSize; drop > 0 {
+		waiting = waiting[drop:]
+	}
+	inboxes[userID] = waiting
+	return nil
+}
+
+func (b *LocalBus) Delivered(ctx context.Context, userID string, payload []byte) error {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waiting := b.inbox[tenant][userID]
+	for i, kept := range waiting {
+		if string(kept) == string(payload) {
+			b.inbox[tenant][userID] = append(waiting[:i:i], waiting[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Drain(ctx context.Context, userID string) ([][]byte, error) {
+	tenant := pkg.GetTenant(ctx).ID
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waiting := b.inbox[tenant][userID]
+	delete(b.inbox[tenant], userID)
+	return waiting, nil
+}
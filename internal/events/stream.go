@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+// publishScript numbers an event, appends it to the tenant's stream and relays it live as
+// "<seq>:<payload>" in one step, so the stream and the channel agree on the order. When
+// retention trims the stream it records the highest sequence it dropped as the floor.
+// KEYS[1] sequence, KEYS[2] stream, KEYS[3] floor; ARGV[1] payload, ARGV[2] retention,
+// ARGV[3] channel.
+var publishScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('XADD', KEYS[2], seq .. '-0', 'payload', ARGV[1])
+if redis.call('XTRIM', KEYS[2], 'MAXLEN', '~', ARGV[2]) > 0 then
+	local oldest = redis.call('XRANGE', KEYS[2], '-', '+', 'COUNT', 1)[1][1]
+	redis.call('SET', KEYS[3], tonumber(string.match(oldest, '^%d+')) - 1)
+end
+redis.call('PUBLISH', ARGV[3], seq .. ':' .. ARGV[1])
+return seq
+`)
+
+// purgePage is how many stream entries a purge reads at a time.
+const purgePage = 500
+
+func streamID(seq uint64) string {
+	return strconv.FormatUint(seq, 10) + "-0"
+}
+
+// decodeMessage splits the sequence prefix off a Pub/Sub payload. Payloads of publishers
+// that predate sequences are plain JSON and keep Seq 0.
+func decodeMessage(tenant, raw string) Message {
+	if prefix, payload, ok := strings.Cut(raw, ":"); ok {
+		if seq, err := strconv.ParseUint(prefix, 10, 64); err == nil {
+			return Message{Tenant: tenant, Seq: seq, Payload: []byte(payload)}
+		}
+	}
+	return Message{Tenant: tenant, Payload: []byte(raw)}
+}
+
+func streamMessages(tenant string, entries []redis.XMessage) []Message {
+	msgs := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.ID, "-")
+		seq, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		payload, _ := entry.Values["payload"].(string)
+		msgs = append(msgs, Message{Tenant: tenant, Seq: seq, Payload: []byte(payload)})
+	}
+	return msgs
+}
+
+func (b *RedisBus) replay(ctx context.Context, tenant string, after uint64, limit int64) ([]Message, bool, error) {
+	keys := repositories.KeyspaceFor(b.client, tenant)
+
+	pipe := b.client.Pipeline()
+	seqCmd := pipe.Get(ctx, keys.EventSeq())
+	floorCmd := pipe.Get(ctx, keys.EventFloor())
+	rangeCmd := pipe.XRangeN(ctx, keys.EventStream(), streamID(after+1), "+", limit)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, false, err
+	}
+
+	var current, floor uint64
+	for _, read := range []struct {
+		cmd *redis.StringCmd
+		dst *uint64
+	}{{seqCmd, &current}, {floorCmd, &floor}} {
+		n, err := read.cmd.Uint64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, false, err
+		}
+		*read.dst = n
+	}
+	if after >= current {
+		// A client ahead of the counter saw a stream that no longer exists.
+		return nil, after == current, nil
+	}
+
+	// Holes above the floor are purged events, not gaps.
+	return streamMessages(tenant, rangeCmd.Val()), after >= floor, nil
+}
+
+// purge deletes the retained events of a tenant about userID, scanning the stream in pages.
+func (b *RedisBus) purge(ctx context.Context, tenant, userID string) (int, error) {
+	keys := repositories.KeyspaceFor(b.client, tenant)
+
+	removed := 0
+	start := "-"
+	for {
+		entries, err := b.client.XRangeN(ctx, keys.EventStream(), start, "+", purgePage).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		var ids []string
+		for _, entry := range entries {
+			if payload, _ := entry.Values["payload"].(string); mentions([]byte(payload), userID) {
+				ids = append(ids, entry.ID)
+			}
+		}
+		if len(ids) > 0 {
+			n, err := b.client.XDel(ctx, keys.EventStream(), ids...).Result()
+			removed += int(n)
+			if err != nil {
+				return removed, err
+			}
+		}
+
+		if len(entries) < purgePage {
+			return removed, nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// backfill reads the events between prev and next, both excluded, that a subscriber missed.
+func (b *RedisBus) backfill(ctx context.Context, tenant string, prev, next uint64) ([]Message, error) {
+	keys := repositories.KeyspaceFor(b.client, tenant)
+	entries, err := b.client.XRangeN(ctx, keys.EventStream(), streamID(prev+1), streamID(next-1), b.retention).Result()
+	if err != nil {
+		return nil, err
+	}
+	return streamMessages(tenant, entries), nil
+}
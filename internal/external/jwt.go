@@ -27,7 +27,18 @@ func ValidateJWT(authHeader string) (*pkg.Payload, error) {
 		return nil, ErrBadFormat
 	}
 
-	payload, err := pkg.DecodeJWT(raw)
+	return ValidateToken(raw)
+}
+
+// ValidateToken validates a bare token, for clients that cannot send an Authorization
+// header such as browsers opening a websocket.
+func ValidateToken(token string) (*pkg.Payload, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrEmptyToken
+	}
+
+	payload, err := pkg.DecodeJWT(token)
 	if err != nil {
 		return nil, fmt.Errorf("decode jwt: %w", err)
 	}
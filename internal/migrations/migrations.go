@@ -0,0 +1,219 @@
+// Package migrations upgrades the Redis key layout of a tenant in place. Each tenant records
+// the last schema version applied to its keys; Run applies the migrations above it in order
+// while holding a lock, so only one instance migrates a tenant at a time.
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// lockPoll is how often a waiting instance retries the migration lock.
+const lockPoll = 250 * time.Millisecond
+
+var (
+	// ErrLocked means another instance held the migration lock for the whole wait.
+	ErrLocked = errors.New("migrations: another instance holds the migration lock")
+	// ErrLockLost means the lock expired while migrating, so another instance may have
+	// started; raise the lock TTL above the duration of the slowest migration.
+	ErrLockLost = errors.New("migrations: migration lock expired while migrating")
+	// ErrSchemaAhead means the keys were migrated by a newer binary than this one.
+	ErrSchemaAhead = errors.New("migrations: schema is newer than this binary")
+)
+
+// releaseScript deletes the lock only while it still holds the caller's token.
+// KEYS[1] lock; ARGV[1] token.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshScript extends the lock only while it still holds the caller's token.
+// KEYS[1] lock; ARGV[1] token, ARGV[2] TTL in milliseconds.
+var refreshScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Migration is one step of the key layout.
+type Migration struct {
+	Version int
+	Name    string
+	// Apply upgrades the request tenant and returns how many entries or keys it changed, or
+	// would change when dryRun is set. It must be idempotent: a run that fails before the
+	// version is recorded repeats it.
+	Apply func(ctx context.Context, dryRun bool) (int, error)
+}
+
+// Step reports one migration that was applied, or would be in a dry run.
+type Step struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Changed int    `json:"changed"`
+}
+
+// Report records a run for one tenant.
+type Report struct {
+	Tenant string `json:"tenant,omitempty"`
+	DryRun bool   `json:"dry_run"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Steps  []Step `json:"steps"`
+}
+
+type Runner struct {
+	client     redis.UniversalClient
+	migrations []Migration
+	lockTTL    time.Duration
+	lockWait   time.Duration
+}
+
+// NewRunner checks that migrations are numbered 1, 2, 3... in order. The lock expires after
+// lockTTL unless refreshed between migrations; Run waits up to lockWait for another
+// instance to release it.
+func NewRunner(client redis.UniversalClient, migrations []Migration, lockTTL, lockWait time.Duration) (*Runner, error) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrations: %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Apply == nil {
+			return nil, fmt.Errorf("migrations: %q has no Apply", m.Name)
+		}
+	}
+	if lockTTL <= 0 {
+		return nil, fmt.Errorf("migrations: lock TTL must be positive")
+	}
+	return &Runner{client: client, migrations: migrations, lockTTL: lockTTL, lockWait: lockWait}, nil
+}
+
+// Latest is the version the keys have once every migration is applied.
+func (r *Runner) Latest() int {
+	return len(r.migrations)
+}
+
+// Version reads the schema version of the request tenant; 0 means never migrated.
+func (r *Runner) Version(ctx context.Context) (int, error) {
+	version, err := r.client.Get(ctx, r.keys(ctx).SchemaVersion()).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Run applies the pending migrations of the request tenant, recording the version after
+// each one so a failure resumes where it stopped. A dry run takes no lock and writes
+// nothing; it reports what each pending migration would change.
+func (r *Runner) Run(ctx context.Context, dryRun bool) (*Report, error) {
+	report := &Report{Tenant: pkg.GetTenant(ctx).ID, DryRun: dryRun, Steps: []Step{}}
+
+	if !dryRun {
+		token, err := r.lock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseScript.Run(context.WithoutCancel(ctx), r.client, []string{r.keys(ctx).SchemaLock()}, token)
+
+		return report, r.apply(ctx, report, token)
+	}
+
+	current, err := r.Version(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+	report.From, report.To = current, current
+	if current > r.Latest() {
+		return report, ErrSchemaAhead
+	}
+	for _, m := range r.migrations[current:] {
+		changed, err := m.Apply(ctx, true)
+		if err != nil {
+			return report, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		report.Steps = append(report.Steps, Step{Version: m.Version, Name: m.Name, Changed: changed})
+		report.To = m.Version
+	}
+	return report, nil
+}
+
+// apply runs the pending migrations under the lock held with token.
+func (r *Runner) apply(ctx context.Context, report *Report, token string) error {
+	keys := r.keys(ctx)
+
+	// Read under the lock: an instance that held it before may have migrated already.
+	current, err := r.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	report.From, report.To = current, current
+	if current > r.Latest() {
+		return ErrSchemaAhead
+	}
+
+	for _, m := range r.migrations[current:] {
+		held, err := refreshScript.Run(ctx, r.client, []string{keys.SchemaLock()}, token, r.lockTTL.Milliseconds()).Int()
+		if err != nil {
+			return err
+		}
+		if held == 0 {
+			return ErrLockLost
+		}
+
+		changed, err := m.Apply(ctx, false)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if err := r.client.Set(ctx, keys.SchemaVersion(), m.Version, 0).Err(); err != nil {
+			return fmt.Errorf("record schema version %d: %w", m.Version, err)
+		}
+		report.Steps = append(report.Steps, Step{Version: m.Version, Name: m.Name, Changed: changed})
+		report.To = m.Version
+	}
+	return nil
+}
+
+// lock takes the tenant's migration lock, waiting up to lockWait, and returns its token.
+func (r *Runner) lock(ctx context.Context) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	key := r.keys(ctx).SchemaLock()
+	deadline := time.Now().Add(r.lockWait)
+	for {
+		ok, err := r.client.SetNX(ctx, key, token, r.lockTTL).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return token, nil
+		}
+		if !time.Now().Before(deadline) {
+			return "", ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(lockPoll):
+		}
+	}
+}
+
+func (r *Runner) keys(ctx context.Context) repositories.Keyspace {
+	return repositories.KeyspaceFor(r.client, pkg.GetTenant(ctx).ID)
+}
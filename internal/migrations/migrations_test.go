@@ -0,0 +1,191 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func newClient(t *testing.T) (redis.UniversalClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
+
+// recorder returns migrations that log every call as "<name>" or "<name>:dry".
+func recorder(calls *[]string, names ...string) []Migration {
+	migrations := make([]Migration, len(names))
+	for i, name := range names {
+		migrations[i] = Migration{
+			Version: i + 1,
+			Name:    name,
+			Apply: func(_ context.Context, dryRun bool) (int, error) {
+				if dryRun {
+					*calls = append(*calls, name+":dry")
+				} else {
+					*calls = append(*calls, name)
+				}
+				return i, nil
+			},
+		}
+	}
+	return migrations
+}
+
+func TestRunAppliesPendingMigrationsInOrder(t *testing.T) {
+	client, mr := newClient(t)
+	ctx := context.Background()
+
+	var calls []string
+	runner, err := NewRunner(client, recorder(&calls, "first", "second"), time.Minute, 0)
+	require.NoError(t, err)
+
+	report, err := runner.Run(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, calls)
+	require.Equal(t, &Report{From: 0, To: 2, Steps: []Step{
+		{Version: 1, Name: "first", Changed: 0},
+		{Version: 2, Name: "second", Changed: 1},
+	}}, report)
+	require.False(t, mr.Exists("emu-game:schema:lock"), "the lock is released")
+
+	version, err := runner.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	report, err = runner.Run(ctx, false)
+	require.NoError(t, err)
+	require.Empty(t, report.Steps, "applied migrations never run again")
+	require.Len(t, calls, 2)
+
+	runner, err = NewRunner(client, recorder(&calls, "first", "second", "third"), time.Minute, 0)
+	require.NoError(t, err)
+	report, err = runner.Run(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.From)
+	require.Equal(t, []string{"first", "second", "third"}, calls)
+}
+
+func TestRunDryRunWritesNothing(t *testing.T) {
+	client, mr := newClient(t)
+	ctx := context.Background()
+
+	var calls []string
+	runner, err := NewRunner(client, recorder(&calls, "first", "second"), time.Minute, 0)
+	require.NoError(t, err)
+
+	report, err := runner.Run(ctx, true)
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, 2, report.To)
+	require.Equal(t, []string{"first:dry", "second:dry"}, calls)
+	require.False(t, mr.Exists("emu-game:schema:version"))
+}
+
+func TestRunStopsAtFailedMigration(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	failing := errors.New("boom")
+	runner, err := NewRunner(client, []Migration{
+		{Version: 1, Name: "ok", Apply: func(context.Context, bool) (int, error) { return 0, nil }},
+		{Version: 2, Name: "broken", Apply: func(context.Context, bool) (int, error) { return 0, failing }},
+	}, time.Minute, 0)
+	require.NoError(t, err)
+
+	report, err := runner.Run(ctx, false)
+	require.ErrorIs(t, err, failing)
+	require.Equal(t, 1, report.To)
+
+	version, err := runner.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version, "a retry resumes at the failed migration")
+}
+
+func TestRunRespectsLockAndTenant(t *testing.T) {
+	client, mr := newClient(t)
+
+	var calls []string
+	runner, err := NewRunner(client, recorder(&calls, "first"), time.Minute, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, mr.Set("emu-game:schema:lock", "other-instance"))
+	_, err = runner.Run(context.Background(), false)
+	require.ErrorIs(t, err, ErrLocked)
+	require.Empty(t, calls)
+
+	// Tenants migrate independently, under their own lock and version.
+	acme := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "acme"})
+	_, err = runner.Run(acme, false)
+	require.NoError(t, err)
+	version, err := mr.Get("emu-game:tenant:acme:schema:version")
+	require.NoError(t, err)
+	require.Equal(t, "1", version)
+	require.False(t, mr.Exists("emu-game:schema:version"))
+}
+
+func TestRunRejectsNewerSchema(t *testing.T) {
+	client, mr := newClient(t)
+
+	var calls []string
+	runner, err := NewRunner(client, recorder(&calls, "first"), time.Minute, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, mr.Set("emu-game:schema:version", "5"))
+	report, err := runner.Run(context.Background(), false)
+	require.ErrorIs(t, err, ErrSchemaAhead)
+	require.Equal(t, 5, report.From)
+	require.Empty(t, calls)
+}
+
+func TestNewRunnerRejectsGaps(t *testing.T) {
+	_, err := NewRunner(nil, []Migration{{Version: 2, Name: "skipped", Apply: func(context.Context, bool) (int, error) { return 0, nil }}}, time.Minute, 0)
+	require.Error(t, err)
+}
+
+func TestForRepositoryMigratesMembersAndIndexesUsers(t *testing.T) {
+	client, mr := newClient(t)
+	ctx := context.Background()
+
+	repo, err := repositories.NewRedisRepository(client)
+	require.NoError(t, err)
+	_, err = mr.ZAdd("emu-game:scores", 10, `{"user_id":"user-1","quiz_id":"quiz-1","score":10}`)
+	require.NoError(t, err)
+
+	steps, err := ForRepository(repo)
+	require.NoError(t, err)
+	runner, err := NewRunner(client, steps, time.Minute, 0)
+	require.NoError(t, err)
+
+	report, err := runner.Run(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, []Step{{Version: 1, Name: "compact-members", Changed: 1}, {Version: 2, Name: "index-users", Changed: 1}}, report.Steps)
+	scores, err := repo.UserScores(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, scores, "a dry run indexes nothing")
+
+	report, err = runner.Run(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, []Step{{Version: 1, Name: "compact-members", Changed: 1}, {Version: 2, Name: "index-users", Changed: 1}}, report.Steps)
+
+	entry := models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}
+	members, err := mr.ZMembers("emu-game:scores")
+	require.NoError(t, err)
+	require.Equal(t, []string{repositories.EntryID(entry)}, members)
+
+	scores, err = repo.UserScores(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{entry}, scores, "entries recorded before the user index are indexed")
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+// scanPage is how many board entries a dry run of index-users counts per request.
+const scanPage = 1000
+
+// userIndexer backfills the user index read by moderation, user scores and erasure.
+type userIndexer interface {
+	repositories.Moderator
+	repositories.ScopeStore
+}
+
+// ForRepository lists the migrations of the Redis key layout, oldest first. Append new ones;
+// never renumber or remove a released migration.
+func ForRepository(repo repositories.Repository) ([]Migration, error) {
+	members, ok := repo.(repositories.MemberMigrator)
+	if !ok {
+		return nil, fmt.Errorf("migrations: repository %T cannot migrate leaderboard members", repo)
+	}
+	index, ok := repo.(userIndexer)
+	if !ok {
+		return nil, fmt.Errorf("migrations: repository %T cannot index users", repo)
+	}
+
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "compact-members",
+			Apply: func(ctx context.Context, dryRun bool) (int, error) {
+				return members.MigrateMembers(ctx, dryRun)
+			},
+		},
+		{
+			// Entries recorded before the user index existed are invisible to it until indexed.
+			Version: 2,
+			Name:    "index-users",
+			Apply: func(ctx context.Context, dryRun bool) (int, error) {
+				if dryRun {
+					return countEntries(ctx, index)
+				}
+				return index.ReindexUsers(ctx)
+			},
+		},
+	}, nil
+}
+
+// countEntries counts the entries of the global board, which ReindexUsers indexes.
+func countEntries(ctx context.Context, store repositories.ScopeStore) (int, error) {
+	var count int
+	for from := int64(0); ; from += scanPage {
+		page, err := store.ListScopeScores(ctx, repositories.GlobalScope, from, scanPage)
+		if err != nil {
+			return count, err
+		}
+		count += len(page)
+		if len(page) < scanPage {
+			return count, nil
+		}
+	}
+}
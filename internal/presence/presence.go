@@ -0,0 +1,149 @@
+// Package presence records which users hold a websocket connection, across the whole tenant
+// and per quiz, so every instance can answer "who is online" and "how many play this quiz".
+package presence
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// Actions of a Change.
+const (
+	ActionJoin  = "join"
+	ActionLeave = "leave"
+)
+
+// Change is a user becoming present in a scope or leaving it, with the number of users
+// present afterwards. A user counts once however many connections or instances hold them.
+type Change struct {
+	Scope  repositories.Scope
+	UserID string
+	Action string
+	Count  int64
+}
+
+// Store records the users an instance holds connections of. Scopes are the tenant as a whole
+// (repositories.GlobalScope) or one quiz; every method acts on the tenant of ctx.
+type Store interface {
+	// Join claims a user for this instance. ok is false when the user was already present
+	// through another instance, or already claimed by this one.
+	Join(ctx context.Context, scope repositories.Scope, userID string) (change Change, ok bool, err error)
+	// Leave drops this instance's claim; ok is false while another instance still holds the user.
+	Leave(ctx context.Context, scope repositories.Scope, userID string) (change Change, ok bool, err error)
+	Count(ctx context.Context, scope repositories.Scope) (int64, error)
+	// Members pages through the users present in a scope, in no particular order. The first
+	// page is read with cursor 0; next is 0 after the last page.
+	Members(ctx context.Context, scope repositories.Scope, cursor uint64, limit int64) (members []string, next uint64, err error)
+	Present(ctx context.Context, scope repositories.Scope, userID string) (bool, error)
+	// Heartbeat renews this instance's claims and releases those of instances that stopped
+	// renewing theirs, returning the users that left as a result. lapsed reports that this
+	// instance may have lost its own claims; callers claim their users again.
+	Heartbeat(ctx context.Context) (changes []Change, lapsed bool, err error)
+	// Release drops every claim of this instance, for a clean shutdown.
+	Release(ctx context.Context) ([]Change, error)
+}
+
+// LocalStore keeps presence in memory, for single-instance deployments without Redis.
+type LocalStore struct {
+	mu sync.RWMutex
+	// present holds the users of each tenant and scope.
+	present map[string]map[repositories.Scope]map[string]struct{}
+}
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{present: make(map[string]map[repositories.Scope]map[string]struct{})}
+}
+
+func (s *LocalStore) users(ctx context.Context, scope repositories.Scope) map[string]struct{} {
+	return s.present[pkg.GetTenant(ctx).ID][scope]
+}
+
+func (s *LocalStore) Join(ctx context.Context, scope repositories.Scope, userID string) (Change, bool, error) {
+	tenant := pkg.GetTenant(ctx).ID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scopes, ok := s.present[tenant]
+	if !ok {
+		scopes = make(map[repositories.Scope]map[string]struct{})
+		s.present[tenant] = scopes
+	}
+	users, ok := scopes[scope]
+	if !ok {
+		users = make(map[string]struct{})
+		scopes[scope] = users
+	}
+	_, held := users[userID]
+	users[userID] = struct{}{}
+	return Change{Scope: scope, UserID: userID, Action: ActionJoin, Count: int64(len(users))}, !held, nil
+}
+
+func (s *LocalStore) Leave(ctx context.Context, scope repositories.Scope, userID string) (Change, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := s.users(ctx, scope)
+	_, held := users[userID]
+	delete(users, userID)
+	return Change{Scope: scope, UserID: userID, Action: ActionLeave, Count: int64(len(users))}, held, nil
+}
+
+func (s *LocalStore) Count(ctx context.Context, scope repositories.Scope) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.users(ctx, scope))), nil
+}
+
+// Members sorts the users so a cursor, an offset here, stays meaningful between pages.
+func (s *LocalStore) Members(ctx context.Context, scope repositories.Scope, cursor uint64, limit int64) ([]string, uint64, error) {
+	s.mu.RLock()
+	members := make([]string, 0, len(s.users(ctx, scope)))
+	for userID := range s.users(ctx, scope) {
+		members = append(members, userID)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(members)
+	if cursor >= uint64(len(members)) {
+		return []string{}, 0, nil
+	}
+	end := min(uint64(len(members)), cursor+uint64(limit))
+	next := end
+	if end == uint64(len(members)) {
+		next = 0
+	}
+	return members[cursor:end], next, nil
+}
+
+func (s *LocalStore) Present(ctx context.Context, scope repositories.Scope, userID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.users(ctx, scope)[userID]
+	return ok, nil
+}
+
+// Heartbeat has nothing to renew: a single instance cannot outlive its own claims.
+func (s *LocalStore) Heartbeat(ctx context.Context) ([]Change, bool, error) {
+	return nil, false, nil
+}
+
+func (s *LocalStore) Release(ctx context.Context) ([]Change, error) {
+	tenant := pkg.GetTenant(ctx).ID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []Change
+	for scope, users := range s.present[tenant] {
+		for userID := range users {
+			delete(users, userID)
+			changes = append(changes, Change{Scope: scope, UserID: userID, Action: ActionLeave, Count: int64(len(users))})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,137 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func TestStoresCountEachUserOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisStore, err := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 0)
+	require.NoError(t, err)
+	stores := map[string]Store{"redis": redisStore, "local": NewLocalStore()}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "acme"})
+			quiz := repositories.QuizScope("quiz-1")
+
+			change, ok, err := store.Join(ctx, quiz, "user-1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, Change{Scope: quiz, UserID: "user-1", Action: ActionJoin, Count: 1}, change)
+			_, ok, err = store.Join(ctx, quiz, "user-1")
+			require.NoError(t, err)
+			require.False(t, ok, "an instance claims a user once")
+			_, ok, err = store.Join(ctx, quiz, "user-2")
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			count, err := store.Count(ctx, quiz)
+			require.NoError(t, err)
+			require.Equal(t, int64(2), count)
+			members, next, err := store.Members(ctx, quiz, 0, 100)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"user-1", "user-2"}, members)
+			require.Zero(t, next)
+
+			other := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "globex"})
+			count, err = store.Count(other, quiz)
+			require.NoError(t, err)
+			require.Zero(t, count, "presence is kept per tenant")
+			present, err := store.Present(ctx, repositories.GlobalScope, "user-1")
+			require.NoError(t, err)
+			require.False(t, present, "being in a quiz is tracked apart from being online")
+
+			change, ok, err = store.Leave(ctx, quiz, "user-1")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, Change{Scope: quiz, UserID: "user-1", Action: ActionLeave, Count: 1}, change)
+
+			changes, err := store.Release(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []Change{{Scope: quiz, UserID: "user-2", Action: ActionLeave, Count: 0}}, changes)
+		})
+	}
+}
+
+func TestRedisStoreSharesUsersBetweenInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "acme"})
+
+	a, err := NewRedisStore(client, 0)
+	require.NoError(t, err)
+	b, err := NewRedisStore(client, 0)
+	require.NoError(t, err)
+
+	_, ok, err := a.Join(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = b.Join(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.False(t, ok, "the user was already online through the other instance")
+
+	_, ok, err = a.Leave(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.False(t, ok, "the other instance still holds the user")
+	present, err := b.Present(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.True(t, present)
+
+	_, ok, err = b.Leave(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestRedisStoreReleasesCrashedInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "acme"})
+	now := time.Now()
+
+	crashed, err := NewRedisStore(client, time.Minute)
+	require.NoError(t, err)
+	crashed.now = func() time.Time { return now }
+	alive, err := NewRedisStore(client, time.Minute)
+	require.NoError(t, err)
+	alive.now = func() time.Time { return now }
+
+	quiz := repositories.QuizScope("quiz-1")
+	for _, scope := range []repositories.Scope{repositories.GlobalScope, quiz} {
+		_, _, err := crashed.Join(ctx, scope, "user-1")
+		require.NoError(t, err)
+		_, _, err = alive.Join(ctx, scope, "user-2")
+		require.NoError(t, err)
+	}
+
+	changes, lapsed, err := alive.Heartbeat(ctx)
+	require.NoError(t, err)
+	require.False(t, lapsed)
+	require.Empty(t, changes, "claims are kept until their TTL")
+
+	now = now.Add(2 * time.Minute)
+	changes, lapsed, err = alive.Heartbeat(ctx)
+	require.NoError(t, err)
+	require.False(t, lapsed, "a heartbeat renews this instance before looking for expired ones")
+	require.ElementsMatch(t, []Change{
+		{Scope: repositories.GlobalScope, UserID: "user-1", Action: ActionLeave, Count: 1},
+		{Scope: quiz, UserID: "user-1", Action: ActionLeave, Count: 1},
+	}, changes)
+
+	members, _, err := alive.Members(ctx, quiz, 0, 100)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2"}, members)
+
+	_, lapsed, err = crashed.Heartbeat(ctx)
+	require.NoError(t, err)
+	require.True(t, lapsed, "an instance back from a long pause finds its claims released")
+}
@@ -0,0 +1,183 @@
+package presence
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// DefaultTTL is how long the claims of an instance outlive its last heartbeat.
+const DefaultTTL = 45 * time.Second
+
+// claimScript adds or drops an instance's claim on a user, counting the instances holding
+// each user so the user leaves only with the last one.
+// KEYS[1] scope presence hash, KEYS[2] instance claims, KEYS[3] instance registry;
+// ARGV[1] user, ARGV[2] claim, ARGV[3] 1 to join or -1 to leave, ARGV[4] instance,
+// ARGV[5] claim expiry in ms. Returns {changed, holders, users present}.
+var claimScript = redis.NewScript(`
+if ARGV[3] == '1' then
+	redis.call('ZADD', KEYS[3], ARGV[5], ARGV[4])
+	if redis.call('SADD', KEYS[2], ARGV[2]) == 0 then
+		return {0, 0, redis.call('HLEN', KEYS[1])}
+	end
+	local holders = redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+	return {holders == 1 and 1 or 0, holders, redis.call('HLEN', KEYS[1])}
+end
+if redis.call('SREM', KEYS[2], ARGV[2]) == 0 then
+	return {0, 0, redis.call('HLEN', KEYS[1])}
+end
+local holders = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+if holders <= 0 then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+end
+return {holders <= 0 and 1 or 0, holders, redis.call('HLEN', KEYS[1])}
+`)
+
+// RedisStore shares presence between instances. Each instance records its claims on users;
+// a user is present while any instance claims them. Instances renew their claims with
+// heartbeats, and an instance that stops, even by crashing, has its claims released by the
+// next heartbeat of another instance after the TTL.
+type RedisStore struct {
+	client   redis.UniversalClient
+	instance string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// NewRedisStore claims users under a random instance ID, kept for ttl after each heartbeat
+// (DefaultTTL when 0). Keys live in the tenant's slot, so scripts work on a Cluster.
+func NewRedisStore(client redis.UniversalClient, ttl time.Duration) (*RedisStore, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &RedisStore{client: client, instance: hex.EncodeToString(b), ttl: ttl, now: time.Now}, nil
+}
+
+// claim names a scope and user in an instance's claims; neither scopes nor user IDs hold NUL.
+func claim(scope repositories.Scope, userID string) string {
+	return string(scope) + "\x00" + userID
+}
+
+func (s *RedisStore) expiry() int64 {
+	return s.now().Add(s.ttl).UnixMilli()
+}
+
+func (s *RedisStore) Join(ctx context.Context, scope repositories.Scope, userID string) (Change, bool, error) {
+	return s.run(ctx, s.instance, scope, userID, 1)
+}
+
+func (s *RedisStore) Leave(ctx context.Context, scope repositories.Scope, userID string) (Change, bool, error) {
+	return s.run(ctx, s.instance, scope, userID, -1)
+}
+
+func (s *RedisStore) run(ctx context.Context, instance string, scope repositories.Scope, userID string, delta int) (Change, bool, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	res, err := claimScript.Run(ctx, s.client,
+		[]string{keys.Presence(scope), keys.PresenceInstance(instance), keys.PresenceInstances()},
+		userID, claim(scope, userID), delta, instance, s.expiry()).Int64Slice()
+	if err != nil {
+		return Change{}, false, err
+	}
+
+	change := Change{Scope: scope, UserID: userID, Action: ActionJoin, Count: res[2]}
+	if delta < 0 {
+		change.Action = ActionLeave
+	}
+	return change, res[0] == 1, nil
+}
+
+func (s *RedisStore) Count(ctx context.Context, scope repositories.Scope) (int64, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	return s.client.HLen(ctx, keys.Presence(scope)).Result()
+}
+
+func (s *RedisStore) Members(ctx context.Context, scope repositories.Scope, cursor uint64, limit int64) ([]string, uint64, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	pairs, next, err := s.client.HScan(ctx, keys.Presence(scope), cursor, "", limit).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	members := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		members = append(members, pairs[i])
+	}
+	return members, next, nil
+}
+
+func (s *RedisStore) Present(ctx context.Context, scope repositories.Scope, userID string) (bool, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	return s.client.HExists(ctx, keys.Presence(scope), userID).Result()
+}
+
+func (s *RedisStore) Heartbeat(ctx context.Context) ([]Change, bool, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	now := s.now()
+	added, err := s.client.ZAdd(ctx, keys.PresenceInstances(), redis.Z{Score: float64(s.expiry()), Member: s.instance}).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("renew presence: %w", err)
+	}
+	// Not being registered means this instance held no claims, or missed heartbeats for so
+	// long that another instance released them.
+	lapsed := added == 1
+
+	expired, err := s.client.ZRangeByScore(ctx, keys.PresenceInstances(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, lapsed, fmt.Errorf("list expired instances: %w", err)
+	}
+
+	var changes []Change
+	for _, instance := range expired {
+		released, err := s.release(ctx, instance)
+		changes = append(changes, released...)
+		if err != nil {
+			return changes, lapsed, fmt.Errorf("release instance %s: %w", instance, err)
+		}
+	}
+	return changes, lapsed, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context) ([]Change, error) {
+	return s.release(ctx, s.instance)
+}
+
+// release drops every claim of an instance, then the instance itself. A release interrupted
+// halfway is finished by the next heartbeat, as claims are dropped one at a time.
+func (s *RedisStore) release(ctx context.Context, instance string) ([]Change, error) {
+	keys := repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+	claims, err := s.client.SMembers(ctx, keys.PresenceInstance(instance)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, c := range claims {
+		scope, userID, ok := strings.Cut(c, "\x00")
+		if !ok {
+			continue
+		}
+		change, left, err := s.run(ctx, instance, repositories.Scope(scope), userID, -1)
+		if err != nil {
+			return changes, err
+		}
+		if left {
+			changes = append(changes, change)
+		}
+	}
+	return changes, s.client.ZRem(ctx, keys.PresenceInstances(), instance).Err()
+}
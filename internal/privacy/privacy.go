@@ -0,0 +1,318 @@
+// Package privacy answers data-subject requests: it exports everything stored about a user
+// and erases or anonymizes it across every leaderboard scope and snapshot.
+//
+// The service stores no profile or answer history; a user's data is their quiz membership,
+// their leaderboard entries (global and per quiz), the IDs of attempts they uploaded from
+// offline play, their ban state, the snapshots that captured any of those and, until
+// retention drops them, the events about them kept for replay.
+package privacy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// Mode selects what Erase does with the user's leaderboard entries.
+type Mode string
+
+const (
+	// ModeErase deletes the entries.
+	ModeErase Mode = "erase"
+	// ModeAnonymize keeps the entries, and so every other user's rank, under a random id
+	// that cannot be traced back to the user.
+	ModeAnonymize Mode = "anonymize"
+)
+
+const anonymousPrefix = "anon-"
+
+// scanPage is how many board entries a scan for a user's entries reads per request.
+const scanPage = 1000
+
+var ErrInvalidMode = errors.New("privacy: mode must be erase or anonymize")
+
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		return ModeErase, nil
+	case ModeErase, ModeAnonymize:
+		return Mode(s), nil
+	}
+	return "", ErrInvalidMode
+}
+
+// Source is a repository that supports moderation and whole-board access.
+type Source interface {
+	repositories.Repository
+	repositories.ScopeStore
+	repositories.Moderator
+}
+
+// UserData is everything stored about one user in the request tenant.
+type UserData struct {
+	UserID     string    `json:"user_id"`
+	Tenant     string    `json:"tenant,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+	// Membership is the quiz the user has joined and not yet submitted.
+	Membership string               `json:"membership,omitempty"`
+	Entries    []models.UserQuiz    `json:"entries"`
+	Ban        repositories.BanMode `json:"ban,omitempty"`
+	// Attempts maps the offline attempt IDs remembered for the user to when they were played.
+	Attempts map[string]time.Time `json:"attempts,omitempty"`
+	// Snapshots lists the snapshots holding the user's entries or membership.
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+// Empty reports whether nothing is stored about the user.
+func (d *UserData) Empty() bool {
+	return d.Membership == "" && len(d.Entries) == 0 && d.Ban == repositories.BanNone && len(d.Attempts) == 0 && len(d.Snapshots) == 0
+}
+
+// Report records what Erase changed. It is sealed with a digest so a copy handed to the
+// requester can later be checked against the one kept on file.
+type Report struct {
+	UserID      string    `json:"user_id"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Mode        Mode      `json:"mode"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	// ExportDigest is the SHA-256 of the user's data export taken before erasure.
+	ExportDigest string `json:"export_digest"`
+	// ReplacementID is the anonymous id now holding the entries in anonymize mode.
+	ReplacementID      string               `json:"replacement_id,omitempty"`
+	EntriesRemoved     int                  `json:"entries_removed"`
+	EntriesAnonymized  int                  `json:"entries_anonymized"`
+	MembershipRemoved  bool                 `json:"membership_removed"`
+	BanRemoved         repositories.BanMode `json:"ban_removed,omitempty"`
+	AttemptsRemoved    int                  `json:"attempts_removed"`
+	SnapshotsRewritten []string             `json:"snapshots_rewritten,omitempty"`
+	EventsRemoved      int                  `json:"events_removed"`
+	// Verified is set when a fresh export after erasure found nothing left; otherwise
+	// Remaining holds what was found.
+	Verified  bool      `json:"verified"`
+	Remaining *UserData `json:"remaining,omitempty"`
+	Digest    string    `json:"digest"`
+}
+
+// Seal sets the report digest: the SHA-256 of the report's JSON encoding with an empty
+// digest field.
+func (r *Report) Seal() error {
+	digest, err := r.digest()
+	if err != nil {
+		return err
+	}
+	r.Digest = digest
+	return nil
+}
+
+// CheckDigest reports whether the report is unchanged since it was sealed.
+func (r *Report) CheckDigest() (bool, error) {
+	digest, err := r.digest()
+	if err != nil {
+		return false, err
+	}
+	return digest == r.Digest, nil
+}
+
+func (r *Report) digest() (string, error) {
+	unsealed := *r
+	unsealed.Digest = ""
+	return digestJSON(unsealed)
+}
+
+type Service struct {
+	source    Source
+	snapshots *snapshots.Manager
+	history   events.Purger
+	now       func() time.Time
+}
+
+// NewService returns a service over source. snapshots and history may be nil when the
+// backend keeps no snapshots or no event history.
+func NewService(source Source, snapshots *snapshots.Manager, history events.Purger) *Service {
+	return &Service{source: source, snapshots: snapshots, history: history, now: time.Now}
+}
+
+// Export collects everything stored about the user in the request tenant.
+func (s *Service) Export(ctx context.Context, userID string) (*UserData, error) {
+	data := &UserData{
+		UserID:     userID,
+		Tenant:     pkg.GetTenant(ctx).ID,
+		ExportedAt: s.now().UTC(),
+	}
+
+	var err error
+	if data.Membership, err = s.source.GetQuizByUserID(ctx, userID); err != nil {
+		return nil, fmt.Errorf("read membership: %w", err)
+	}
+	if data.Entries, err = s.boardEntries(ctx, userID); err != nil {
+		return nil, fmt.Errorf("read entries: %w", err)
+	}
+	if data.Ban, err = s.source.GetBan(ctx, userID); err != nil {
+		return nil, fmt.Errorf("read ban: %w", err)
+	}
+	if batch, ok := s.source.(repositories.BatchSubmitter); ok {
+		if data.Attempts, err = batch.UserAttempts(ctx, userID); err != nil {
+			return nil, fmt.Errorf("read attempts: %w", err)
+		}
+	}
+	if s.snapshots != nil {
+		if data.Snapshots, err = s.snapshots.FindUser(ctx, userID); err != nil {
+			return nil, fmt.Errorf("read snapshots: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
+// Erase removes the user from the request tenant: their membership, their ban, their
+// attempt IDs, the retained events about them and their entries on every board and in
+// every snapshot, which mode either deletes or moves to an anonymous id. It then exports
+// the user again to verify nothing is left and returns a sealed report.
+func (s *Service) Erase(ctx context.Context, userID string, mode Mode) (*Report, error) {
+	if mode != ModeErase && mode != ModeAnonymize {
+		return nil, ErrInvalidMode
+	}
+
+	before, err := s.Export(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exportDigest, err := digestJSON(before)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		UserID:       userID,
+		Tenant:       before.Tenant,
+		Mode:         mode,
+		StartedAt:    before.ExportedAt,
+		ExportDigest: exportDigest,
+	}
+	if mode == ModeAnonymize {
+		if report.ReplacementID, err = anonymousID(); err != nil {
+			return nil, err
+		}
+	}
+
+	if before.Membership != "" {
+		if err := s.source.DeleteMembership(ctx, userID); err != nil {
+			return nil, fmt.Errorf("delete membership: %w", err)
+		}
+		report.MembershipRemoved = true
+	}
+	if before.Ban != repositories.BanNone {
+		if err := s.source.SetBan(ctx, userID, repositories.BanNone); err != nil {
+			return nil, fmt.Errorf("delete ban: %w", err)
+		}
+		report.BanRemoved = before.Ban
+	}
+
+	if len(before.Attempts) > 0 {
+		// Sources listing attempts also implement BatchSubmitter.
+		if err := s.source.(repositories.BatchSubmitter).DeleteAttempts(ctx, userID); err != nil {
+			return nil, fmt.Errorf("delete attempts: %w", err)
+		}
+		report.AttemptsRemoved = len(before.Attempts)
+	}
+
+	// Entries recorded before the user index existed are only deleted once indexed.
+	if _, err := s.source.ReindexUsers(ctx); err != nil {
+		return nil, fmt.Errorf("index entries: %w", err)
+	}
+	removed, err := s.source.DeleteUserScores(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("delete entries: %w", err)
+	}
+	if mode == ModeAnonymize && len(before.Entries) > 0 {
+		renamed := make([]models.UserQuiz, len(before.Entries))
+		for i, entry := range before.Entries {
+			entry.UserID = report.ReplacementID
+			renamed[i] = entry
+		}
+		if err := s.source.ImportScores(ctx, repositories.GlobalScope, renamed, false); err != nil {
+			return nil, fmt.Errorf("write anonymized entries: %w", err)
+		}
+		report.EntriesAnonymized = len(renamed)
+	} else {
+		report.EntriesRemoved = removed
+	}
+
+	if s.snapshots != nil && len(before.Snapshots) > 0 {
+		if report.SnapshotsRewritten, err = s.snapshots.RewriteUser(ctx, userID, report.ReplacementID); err != nil {
+			return nil, fmt.Errorf("rewrite snapshots: %w", err)
+		}
+	}
+
+	if s.history != nil {
+		if report.EventsRemoved, err = s.history.Purge(ctx, userID); err != nil {
+			return nil, fmt.Errorf("purge events: %w", err)
+		}
+	}
+
+	after, err := s.Export(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("verify erasure: %w", err)
+	}
+	report.Verified = after.Empty()
+	if !report.Verified {
+		report.Remaining = after
+	}
+	report.CompletedAt = s.now().UTC()
+
+	if err := report.Seal(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// boardEntries scans the global board, which ranks every entry, for the user's entries.
+// Unlike UserScores it does not rely on the user index, which misses entries recorded before
+// the index existed until ReindexUsers has run, so an export is complete and verifies an
+// erasure independently of the index the erasure used.
+func (s *Service) boardEntries(ctx context.Context, userID string) ([]models.UserQuiz, error) {
+	entries := []models.UserQuiz{}
+	for from := int64(0); ; from += scanPage {
+		page, err := s.source.ListScopeScores(ctx, repositories.GlobalScope, from, scanPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range page {
+			if entry.UserID == userID {
+				entries = append(entries, entry)
+			}
+		}
+		if len(page) < scanPage {
+			return entries, nil
+		}
+	}
+}
+
+func anonymousID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return anonymousPrefix + hex.EncodeToString(b), nil
+}
+
+func digestJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,204 @@
+package privacy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
+)
+
+func newService(t *testing.T) (*Service, *repositories.RedisRepository, *snapshots.Manager) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	repo, err := repositories.NewRedisRepository(client)
+	require.NoError(t, err)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	manager := snapshots.NewManager(repo, snapshots.NewDirStore(t.TempDir()), bus)
+	return NewService(repo, manager, bus), repo, manager
+}
+
+func seed(t *testing.T, repo *repositories.RedisRepository, manager *snapshots.Manager) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, entry := range []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 50},
+		{UserID: "user-1", QuizID: "quiz-2", Score: 70},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 60},
+	} {
+		require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, entry))
+	}
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-3"))
+	_, err := repo.SubmitAttempts(ctx, "user-1", []repositories.Attempt{{ID: "offline-1", QuizID: "quiz-4", Score: 5, PlayedAt: time.Now()}})
+	require.NoError(t, err)
+	_, err = manager.Create(ctx, "before request")
+	require.NoError(t, err)
+	require.NoError(t, repo.SetBan(ctx, "user-1", repositories.BanShadow))
+}
+
+func TestExport(t *testing.T) {
+	service, repo, manager := newService(t)
+	seed(t, repo, manager)
+	ctx := context.Background()
+
+	data, err := service.Export(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-2", Score: 70},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 50},
+		{UserID: "user-1", QuizID: "quiz-4", Score: 5},
+	}, data.Entries)
+	require.Contains(t, data.Attempts, "offline-1")
+	require.Empty(t, data.Membership, "banning dropped the membership")
+	require.Equal(t, repositories.BanShadow, data.Ban)
+	require.Len(t, data.Snapshots, 1)
+	require.False(t, data.Empty())
+
+	data, err = service.Export(ctx, "unknown")
+	require.NoError(t, err)
+	require.True(t, data.Empty())
+}
+
+func TestEraseRemovesEverything(t *testing.T) {
+	service, repo, manager := newService(t)
+	seed(t, repo, manager)
+	ctx := context.Background()
+
+	report, err := service.Erase(ctx, "user-1", ModeErase)
+	require.NoError(t, err)
+	require.True(t, report.Verified)
+	require.Nil(t, report.Remaining)
+	require.Equal(t, 3, report.EntriesRemoved)
+	require.Equal(t, 1, report.AttemptsRemoved)
+	require.Equal(t, repositories.BanShadow, report.BanRemoved)
+	require.Len(t, report.SnapshotsRewritten, 1)
+	require.NotEmpty(t, report.ExportDigest)
+
+	ok, err := report.CheckDigest()
+	require.NoError(t, err)
+	require.True(t, ok)
+	report.EntriesRemoved = 0
+	ok, err = report.CheckDigest()
+	require.NoError(t, err)
+	require.False(t, ok, "tampering breaks the digest")
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-2", QuizID: "quiz-1", Score: 60}}, scores)
+
+	snapshot, err := manager.Restore(ctx, report.SnapshotsRewritten[0])
+	require.NoError(t, err)
+	require.Equal(t, 1, snapshot.Entries)
+	require.Zero(t, snapshot.Memberships)
+}
+
+func TestEraseAnonymizeKeepsRanks(t *testing.T) {
+	service, repo, manager := newService(t)
+	seed(t, repo, manager)
+	ctx := context.Background()
+
+	report, err := service.Erase(ctx, "user-1", ModeAnonymize)
+	require.NoError(t, err)
+	require.True(t, report.Verified)
+	require.True(t, strings.HasPrefix(report.ReplacementID, anonymousPrefix))
+	require.Equal(t, 3, report.EntriesAnonymized)
+	require.Zero(t, report.EntriesRemoved)
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: report.ReplacementID, QuizID: "quiz-2", Score: 70},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 60},
+		{UserID: report.ReplacementID, QuizID: "quiz-1", Score: 50},
+		{UserID: report.ReplacementID, QuizID: "quiz-4", Score: 5},
+	}, scores)
+
+	found, err := manager.FindUser(ctx, report.ReplacementID)
+	require.NoError(t, err)
+	require.Equal(t, report.SnapshotsRewritten, found)
+}
+
+func TestEraseRejectsUnknownMode(t *testing.T) {
+	service, _, _ := newService(t)
+
+	_, err := service.Erase(context.Background(), "user-1", Mode("shred"))
+	require.ErrorIs(t, err, ErrInvalidMode)
+}
+
+func TestErasePurgesEvents(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, err := repositories.NewRedisRepository(client)
+	require.NoError(t, err)
+	bus := events.NewRedisBus(client, 0)
+	service := NewService(repo, nil, bus)
+	ctx := context.Background()
+
+	for _, user := range []string{"user-1", "user-2"} {
+		require.NoError(t, events.Publish(ctx, bus, events.SubmitQuiz, models.UserQuiz{UserID: user, QuizID: "quiz-1", Score: 1}))
+	}
+
+	report, err := service.Erase(ctx, "user-1", ModeErase)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.EventsRemoved)
+
+	msgs, _, err := bus.Replay(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.NotContains(t, string(msgs[0].Payload), "user-1")
+}
+
+func TestEraseFindsUnindexedEntries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo, err := repositories.NewRedisRepository(client)
+	require.NoError(t, err)
+	service := NewService(repo, nil, nil)
+	ctx := context.Background()
+
+	for _, entry := range []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 50},
+		{UserID: "user-1", QuizID: "quiz-2", Score: 70},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 60},
+	} {
+		require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, entry))
+	}
+	// Entries recorded before the user index existed have no index entry.
+	mr.Del(repositories.KeyspaceFor(client, "").UserIndex("user-1"))
+
+	data, err := service.Export(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, data.Entries, 2, "an export does not depend on the index")
+
+	report, err := service.Erase(ctx, "user-1", ModeErase)
+	require.NoError(t, err)
+	require.True(t, report.Verified)
+	require.Equal(t, 2, report.EntriesRemoved)
+
+	for _, scope := range []repositories.Scope{repositories.GlobalScope, repositories.QuizScope("quiz-1"), repositories.QuizScope("quiz-2")} {
+		scores, err := repo.ListScopeScores(ctx, scope, 0, 10)
+		require.NoError(t, err)
+		for _, entry := range scores {
+			require.NotEqual(t, "user-1", entry.UserID, scope)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+const (
+	// AttemptRetention bounds how long ago an uploaded attempt may have been played. Attempt
+	// IDs are remembered for as long, so a retried upload is always recognised.
+	AttemptRetention = 7 * 24 * time.Hour
+	// attemptClockSkew tolerates client clocks running slightly ahead of the server.
+	attemptClockSkew = 5 * time.Minute
+)
+
+var (
+	ErrAttemptID     = errors.New("attempt ID is required")
+	ErrAttemptQuizID = errors.New("quiz ID is required")
+	ErrAttemptScore  = errors.New("score must be a finite number")
+	ErrAttemptTime   = errors.New("played_at is outside the accepted window")
+)
+
+// Attempt is one quiz played offline and uploaded later in a batch.
+type Attempt struct {
+	ID       string    `json:"attempt_id"`
+	QuizID   string    `json:"quiz_id"`
+	Score    float64   `json:"score"`
+	PlayedAt time.Time `json:"played_at"`
+}
+
+// AttemptStatus is the outcome of one attempt of a batch.
+type AttemptStatus string
+
+const (
+	AttemptAccepted AttemptStatus = "accepted"
+	// AttemptDuplicate means the attempt ID was already submitted; nothing changed.
+	AttemptDuplicate AttemptStatus = "duplicate"
+)
+
+// BatchSubmitter is implemented by repositories that accept offline attempts in bulk.
+type BatchSubmitter interface {
+	// SubmitAttempts ranks every attempt that passed ValidateAttempt in one atomic step
+	// and returns a status per attempt, in order. An attempt whose ID was seen before,
+	// earlier in the same batch included, is a duplicate and changes nothing. Attempts
+	// need no membership, since they were played offline, and leave any membership alone.
+	//
+	// Banned users get ErrBanned and nothing is recorded. Shadow-banned users get the
+	// statuses and ErrShadowBanned: IDs are recorded so retries look normal, but nothing
+	// is ranked.
+	SubmitAttempts(ctx context.Context, userID string, attempts []Attempt) ([]AttemptStatus, error)
+	// UserAttempts maps the attempt IDs remembered for a user to when they were played.
+	UserAttempts(ctx context.Context, userID string) (map[string]time.Time, error)
+	// DeleteAttempts forgets every attempt ID of a user.
+	DeleteAttempts(ctx context.Context, userID string) error
+}
+
+// ValidateAttempt applies the quiz rules to an attempt played offline: it needs an ID and
+// a quiz, a finite score, and must have been played within AttemptRetention of now.
+func ValidateAttempt(attempt Attempt, now time.Time) error {
+	switch {
+	case attempt.ID == "":
+		return ErrAttemptID
+	case attempt.QuizID == "":
+		return ErrAttemptQuizID
+	case math.IsNaN(attempt.Score) || math.IsInf(attempt.Score, 0):
+		return ErrAttemptScore
+	case attempt.PlayedAt.Before(now.Add(-AttemptRetention)) || attempt.PlayedAt.After(now.Add(attemptClockSkew)):
+		return ErrAttemptTime
+	}
+	return nil
+}
+
+func (a Attempt) entry(userID string) models.UserQuiz {
+	return models.UserQuiz{UserID: userID, QuizID: a.QuizID, Score: a.Score}
+}
@@ -0,0 +1,40 @@
+package repositories
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAttempt(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	valid := Attempt{ID: "a1", QuizID: "quiz-1", Score: 10, PlayedAt: now.Add(-time.Hour)}
+	require.NoError(t, ValidateAttempt(valid, now))
+
+	cases := []struct {
+		name   string
+		modify func(*Attempt)
+		err    error
+	}{
+		{name: "missing id", modify: func(a *Attempt) { a.ID = "" }, err: ErrAttemptID},
+		{name: "missing quiz", modify: func(a *Attempt) { a.QuizID = "" }, err: ErrAttemptQuizID},
+		{name: "nan score", modify: func(a *Attempt) { a.Score = math.NaN() }, err: ErrAttemptScore},
+		{name: "infinite score", modify: func(a *Attempt) { a.Score = math.Inf(1) }, err: ErrAttemptScore},
+		{name: "too old", modify: func(a *Attempt) { a.PlayedAt = now.Add(-AttemptRetention - time.Minute) }, err: ErrAttemptTime},
+		{name: "in the future", modify: func(a *Attempt) { a.PlayedAt = now.Add(time.Hour) }, err: ErrAttemptTime},
+		{name: "missing timestamp", modify: func(a *Attempt) { a.PlayedAt = time.Time{} }, err: ErrAttemptTime},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempt := valid
+			tc.modify(&attempt)
+			require.ErrorIs(t, ValidateAttempt(attempt, now), tc.err)
+		})
+	}
+
+	skewed := valid
+	skewed.PlayedAt = now.Add(time.Minute)
+	require.NoError(t, ValidateAttempt(skewed, now), "small clock skew is tolerated")
+}
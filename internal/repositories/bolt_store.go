@@ -0,0 +1,980 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/pkg"
+)
+
+var (
+	membershipBucket = []byte("memberships")
+	scoresBucket     = []byte("scores")
+	// boardsBucket nests one bucket per quiz leaderboard; scoresBucket is the global board.
+	boardsBucket = []byte("boards")
+	// membersBucket nests one bucket per user whose keys are the user's board payloads.
+	membersBucket = []byte("members")
+	bansBucket    = []byte("bans")
+	// attemptsBucket nests one bucket per user mapping offline attempt IDs to when they
+	// were played.
+	attemptsBucket = []byte("attempts")
+	// metaBucket holds per-tenant counters such as the leaderboard version.
+	metaBucket = []byte("meta")
+	versionKey = []byte("leaderboard_version")
+)
+
+type boltMembership struct {
+	QuizID    string `json:"quiz_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// BoltRepository is a file-backed Repository for deployments that run without Redis.
+type BoltRepository struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{membershipBucket, scoresBucket, boardsBucket, membersBucket, bansBucket, attemptsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltRepository{db: db, now: time.Now}, nil
+}
+
+func (s *BoltRepository) JoinQuiz(ctx context.Context, userID, quizID string) error {
+	// Mirror the Redis TTL: membership expires so abandoned sessions eventually clear.
+	payload, err := json.Marshal(boltMembership{
+		QuizID:    quizID,
+		ExpiresAt: s.now().Add(membershipTTL(ctx)).UnixNano(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		mode, err := banMode(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if mode == BanBlocked {
+			return ErrBanned
+		}
+
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+
+		current, err := s.activeMembership(memberships, userID)
+		if err != nil {
+			return err
+		}
+		switch current {
+		case "":
+		case quizID:
+			return ErrAlreadyJoined
+		default:
+			return ErrJoinedElsewhere
+		}
+
+		return memberships.Put([]byte(userID), payload)
+	})
+}
+
+func (s *BoltRepository) GetQuizByUserID(ctx context.Context, userID string) (string, error) {
+	var quizID string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil || memberships == nil {
+			return err
+		}
+
+		quizID, err = s.activeMembership(memberships, userID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return quizID, nil
+}
+
+func (s *BoltRepository) SubmitQuiz(ctx context.Context, userQuiz models.UserQuiz) error {
+	var shadowed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		mode, err := banMode(ctx, tx, userQuiz.UserID)
+		if err != nil {
+			return err
+		}
+		if mode == BanBlocked {
+			return ErrBanned
+		}
+
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+
+		current, err := s.activeMembership(memberships, userQuiz.UserID)
+		if err != nil {
+			return err
+		}
+		if current != userQuiz.QuizID {
+			return ErrNotJoined
+		}
+
+		// Remove membership so the user must explicitly re-join before another submit.
+		if err := memberships.Delete([]byte(userQuiz.UserID)); err != nil {
+			return err
+		}
+		// A shadow ban consumes the membership like a real submit but never ranks the score.
+		if mode == BanShadow {
+			shadowed = true
+			return nil
+		}
+		return putScore(ctx, tx, userQuiz)
+	})
+	if err == nil && shadowed {
+		return ErrShadowBanned
+	}
+	return err
+}
+
+func (s *BoltRepository) LeaveQuiz(ctx context.Context, userID, quizID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+
+		current, err := s.activeMembership(memberships, userID)
+		if err != nil {
+			return err
+		}
+		if current != quizID {
+			return ErrNotJoined
+		}
+		return memberships.Delete([]byte(userID))
+	})
+}
+
+func (s *BoltRepository) SubmitAttempts(ctx context.Context, userID string, attempts []Attempt) ([]AttemptStatus, error) {
+	statuses := make([]AttemptStatus, len(attempts))
+	var shadowed bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		mode, err := banMode(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if mode == BanBlocked {
+			return ErrBanned
+		}
+		shadowed = mode == BanShadow
+
+		all, err := tenantBucket(ctx, tx, attemptsBucket)
+		if err != nil {
+			return err
+		}
+		seen, err := all.CreateBucketIfNotExists([]byte(userID))
+		if err != nil {
+			return err
+		}
+		if err := forgetAttempts(seen, s.now().Add(-AttemptRetention)); err != nil {
+			return err
+		}
+
+		for i, attempt := range attempts {
+			if seen.Get([]byte(attempt.ID)) != nil {
+				statuses[i] = AttemptDuplicate
+				continue
+			}
+			statuses[i] = AttemptAccepted
+			playedAt := binary.BigEndian.AppendUint64(nil, uint64(attempt.PlayedAt.UnixMilli()))
+			if err := seen.Put([]byte(attempt.ID), playedAt); err != nil {
+				return err
+			}
+			if shadowed {
+				continue
+			}
+			if err := putScore(ctx, tx, attempt.entry(userID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shadowed {
+		return statuses, ErrShadowBanned
+	}
+	return statuses, nil
+}
+
+func (s *BoltRepository) UserAttempts(ctx context.Context, userID string) (map[string]time.Time, error) {
+	attempts := make(map[string]time.Time)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		all, err := tenantBucket(ctx, tx, attemptsBucket)
+		if err != nil || all == nil {
+			return err
+		}
+		seen := all.Bucket([]byte(userID))
+		if seen == nil {
+			return nil
+		}
+
+		return seen.ForEach(func(k, v []byte) error {
+			if len(v) == 8 {
+				attempts[string(k)] = time.UnixMilli(int64(binary.BigEndian.Uint64(v))).UTC()
+			}
+			return nil
+		})
+	})
+	return attempts, err
+}
+
+func (s *BoltRepository) DeleteAttempts(ctx context.Context, userID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		all, err := tenantBucket(ctx, tx, attemptsBucket)
+		if err != nil {
+			return err
+		}
+		if all.Bucket([]byte(userID)) == nil {
+			return nil
+		}
+		return all.DeleteBucket([]byte(userID))
+	})
+}
+
+// forgetAttempts drops attempt IDs played before cutoff; such attempts are rejected anyway.
+func forgetAttempts(seen *bolt.Bucket, cutoff time.Time) error {
+	var stale [][]byte
+	err := seen.ForEach(func(k, v []byte) error {
+		if len(v) == 8 && int64(binary.BigEndian.Uint64(v)) < cutoff.UnixMilli() {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := seen.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltRepository) ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error) {
+	return s.ListScopeScores(ctx, GlobalScope, from, limit)
+}
+
+func (s *BoltRepository) ListScopeScores(ctx context.Context, scope Scope, from, limit int64) ([]models.UserQuiz, error) {
+	if from < 0 {
+		from = 0
+	}
+
+	if limit <= 0 {
+		limit = leaderboardLimit(ctx)
+	}
+
+	entries := make([]models.UserQuiz, 0, limit)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		scores, err := boardBucket(ctx, tx, scope)
+		if err != nil || scores == nil {
+			return err
+		}
+
+		c := scores.Cursor()
+
+		var idx int64
+		for k, v := c.First(); k != nil && int64(len(entries)) < limit; k, v = c.Next() {
+			if idx < from {
+				idx++
+				continue
+			}
+			idx++
+
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(v, &quiz); err != nil {
+				continue
+			}
+			entries = append(entries, quiz)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// UserRank walks the board from the top to the user's first entry, which is their best.
+func (s *BoltRepository) UserRank(ctx context.Context, scope Scope, userID string) (RankedEntry, error) {
+	var ranked RankedEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		scores, err := boardBucket(ctx, tx, scope)
+		if err != nil || scores == nil {
+			return err
+		}
+
+		var rank int64
+		c := scores.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			rank++
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(v, &quiz); err != nil || quiz.UserID != userID {
+				continue
+			}
+			ranked = RankedEntry{Rank: rank, UserQuiz: quiz}
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		return RankedEntry{}, err
+	}
+	if ranked.Rank == 0 {
+		return RankedEntry{}, ErrNotRanked
+	}
+	return ranked, nil
+}
+
+func (s *BoltRepository) ImportScores(ctx context.Context, scope Scope, entries []models.UserQuiz, replace bool) error {
+	if err := validateImport(scope, entries); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if replace {
+			board, err := boardBucket(ctx, tx, scope)
+			if err != nil {
+				return err
+			}
+
+			var existing []models.UserQuiz
+			err = board.ForEach(func(_, v []byte) error {
+				var quiz models.UserQuiz
+				if err := json.Unmarshal(v, &quiz); err == nil {
+					existing = append(existing, quiz)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, quiz := range existing {
+				if err := deleteScore(ctx, tx, quiz); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, entry := range entries {
+			if err := putScore(ctx, tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltRepository) ListMemberships(ctx context.Context) ([]Membership, error) {
+	var memberships []Membership
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil || bucket == nil {
+			return err
+		}
+
+		now := s.now().UnixNano()
+		return bucket.ForEach(func(k, v []byte) error {
+			var m boltMembership
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if m.ExpiresAt <= now {
+				return nil
+			}
+
+			memberships = append(memberships, Membership{
+				UserID: string(k),
+				QuizID: m.QuizID,
+				TTL:    time.Duration(m.ExpiresAt - now),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+func (s *BoltRepository) ReplaceMemberships(ctx context.Context, memberships []Membership) error {
+	now := s.now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+
+		var existing [][]byte
+		if err := bucket.ForEach(func(k, _ []byte) error {
+			existing = append(existing, append([]byte(nil), k...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range existing {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, m := range memberships {
+			if m.TTL <= 0 {
+				continue
+			}
+			payload, err := json.Marshal(boltMembership{QuizID: m.QuizID, ExpiresAt: now.Add(m.TTL).UnixNano()})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(m.UserID), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltRepository) UserScores(ctx context.Context, userID string) ([]models.UserQuiz, error) {
+	var entries []models.UserQuiz
+	err := s.db.View(func(tx *bolt.Tx) error {
+		members, err := tenantBucket(ctx, tx, membersBucket)
+		if err != nil || members == nil {
+			return err
+		}
+		index := members.Bucket([]byte(userID))
+		if index == nil {
+			return nil
+		}
+
+		return index.ForEach(func(k, _ []byte) error {
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(k, &quiz); err == nil {
+				entries = append(entries, quiz)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortByScore(entries)
+	return entries, nil
+}
+
+func (s *BoltRepository) DeleteMembership(ctx context.Context, userID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+		return memberships.Delete([]byte(userID))
+	})
+}
+
+func (s *BoltRepository) DeleteUserScores(ctx context.Context, userID string) (int, error) {
+	var removed int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		members, err := tenantBucket(ctx, tx, membersBucket)
+		if err != nil {
+			return err
+		}
+		index := members.Bucket([]byte(userID))
+		if index == nil {
+			return nil
+		}
+
+		var entries []models.UserQuiz
+		err = index.ForEach(func(k, _ []byte) error {
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(k, &quiz); err == nil {
+				entries = append(entries, quiz)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, quiz := range entries {
+			if err := deleteScore(ctx, tx, quiz); err != nil {
+				return err
+			}
+		}
+		removed = len(entries)
+		return members.DeleteBucket([]byte(userID))
+	})
+	return removed, err
+}
+
+func (s *BoltRepository) DeleteQuizScores(ctx context.Context, quizID string) (int, error) {
+	var removed int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		board, err := boardBucket(ctx, tx, QuizScope(quizID))
+		if err != nil {
+			return err
+		}
+
+		var entries []models.UserQuiz
+		err = board.ForEach(func(_, v []byte) error {
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(v, &quiz); err == nil {
+				entries = append(entries, quiz)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, quiz := range entries {
+			if err := deleteScore(ctx, tx, quiz); err != nil {
+				return err
+			}
+		}
+		removed = len(entries)
+
+		boards, err := tenantBucket(ctx, tx, boardsBucket)
+		if err != nil {
+			return err
+		}
+		return boards.DeleteBucket([]byte(quizID))
+	})
+	return removed, err
+}
+
+func (s *BoltRepository) SetBan(ctx context.Context, userID string, mode BanMode) error {
+	if mode != BanNone {
+		if _, err := ParseBanMode(string(mode)); err != nil {
+			return err
+		}
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bans, err := tenantBucket(ctx, tx, bansBucket)
+		if err != nil {
+			return err
+		}
+		if mode == BanNone {
+			return bans.Delete([]byte(userID))
+		}
+
+		memberships, err := tenantBucket(ctx, tx, membershipBucket)
+		if err != nil {
+			return err
+		}
+		if err := memberships.Delete([]byte(userID)); err != nil {
+			return err
+		}
+		return bans.Put([]byte(userID), []byte(mode))
+	})
+}
+
+func (s *BoltRepository) GetBan(ctx context.Context, userID string) (BanMode, error) {
+	var mode BanMode
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		mode, err = banMode(ctx, tx, userID)
+		return err
+	})
+	return mode, err
+}
+
+func (s *BoltRepository) ListBans(ctx context.Context) (map[string]BanMode, error) {
+	result := make(map[string]BanMode)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bans, err := tenantBucket(ctx, tx, bansBucket)
+		if err != nil || bans == nil {
+			return err
+		}
+		return bans.ForEach(func(k, v []byte) error {
+			result[string(k)] = BanMode(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltRepository) ReindexUsers(ctx context.Context) (int, error) {
+	var indexed int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		scores, err := tenantBucket(ctx, tx, scoresBucket)
+		if err != nil {
+			return err
+		}
+
+		return scores.ForEach(func(_, v []byte) error {
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(v, &quiz); err != nil {
+				return nil
+			}
+			indexed++
+			return indexMember(ctx, tx, quiz.UserID, v)
+		})
+	})
+	return indexed, err
+}
+
+// MigrateMembers rekeys entries of the request tenant's boards that are still keyed by their
+// JSON encoding, so ties order by member ID as they do in Redis. It returns how many global
+// board entries it converted.
+func (s *BoltRepository) MigrateMembers(ctx context.Context, dryRun bool) (int, error) {
+	var converted int
+	migrate := func(tx *bolt.Tx) error {
+		boards, err := tenantBucket(ctx, tx, boardsBucket)
+		if err != nil || boards == nil {
+			return err
+		}
+		scores, err := tenantBucket(ctx, tx, scoresBucket)
+		if err != nil || scores == nil {
+			return err
+		}
+
+		buckets := []*bolt.Bucket{scores}
+		err = boards.ForEachBucket(func(name []byte) error {
+			buckets = append(buckets, boards.Bucket(name))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, board := range buckets {
+			n, err := rekeyBoard(board, dryRun)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				converted = n
+			}
+		}
+		if converted == 0 || dryRun {
+			return nil
+		}
+		return bumpBoltVersion(ctx, tx)
+	}
+
+	if dryRun {
+		return converted, s.db.View(migrate)
+	}
+	return converted, s.db.Update(migrate)
+}
+
+// rekeyBoard moves every entry of a board not keyed by its EntryID to that key.
+func rekeyBoard(board *bolt.Bucket, dryRun bool) (int, error) {
+	type move struct{ from, to, payload []byte }
+	var moves []move
+	err := board.ForEach(func(k, v []byte) error {
+		var quiz models.UserQuiz
+		if err := json.Unmarshal(v, &quiz); err != nil {
+			return nil
+		}
+		if key := scoreKey(quiz.Score, []byte(EntryID(quiz))); !bytes.Equal(k, key) {
+			// Keys and values are only valid until the bucket changes; copy them first.
+			moves = append(moves, move{from: bytes.Clone(k), to: key, payload: bytes.Clone(v)})
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return len(moves), err
+	}
+
+	for _, m := range moves {
+		if err := board.Delete(m.from); err != nil {
+			return 0, err
+		}
+		if err := board.Put(m.to, m.payload); err != nil {
+			return 0, err
+		}
+	}
+	return len(moves), nil
+}
+
+func (s *BoltRepository) LeaderboardVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta, err := tenantBucket(ctx, tx, metaBucket)
+		if err != nil || meta == nil {
+			return err
+		}
+		if raw := meta.Get(versionKey); raw != nil {
+			version = int64(binary.BigEndian.Uint64(raw))
+		}
+		return nil
+	})
+	return version, err
+}
+
+// SaveScore durably records a submission without touching membership state.
+func (s *BoltRepository) SaveScore(ctx context.Context, userQuiz models.UserQuiz) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putScore(ctx, tx, userQuiz)
+	})
+}
+
+// ForEachScore walks every submission recorded for the request tenant from the highest
+// score down.
+func (s *BoltRepository) ForEachScore(ctx context.Context, fn func(models.UserQuiz) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		scores, err := tenantBucket(ctx, tx, scoresBucket)
+		if err != nil || scores == nil {
+			return err
+		}
+
+		return scores.ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var quiz models.UserQuiz
+			if err := json.Unmarshal(v, &quiz); err != nil {
+				return nil
+			}
+			return fn(quiz)
+		})
+	})
+}
+
+func (s *BoltRepository) Close() error {
+	return s.db.Close()
+}
+
+// activeMembership returns the quiz the user has joined, ignoring an expired membership.
+func (s *BoltRepository) activeMembership(memberships *bolt.Bucket, userID string) (string, error) {
+	raw := memberships.Get([]byte(userID))
+	if raw == nil {
+		return "", nil
+	}
+
+	var m boltMembership
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return "", err
+	}
+	if m.ExpiresAt <= s.now().UnixNano() {
+		return "", nil
+	}
+	return m.QuizID, nil
+}
+
+// putScore ranks a submission on the global board and on the board of its quiz.
+func putScore(ctx context.Context, tx *bolt.Tx, userQuiz models.UserQuiz) error {
+	payload, err := json.Marshal(userQuiz)
+	if err != nil {
+		return err
+	}
+
+	id := []byte(EntryID(userQuiz))
+	for _, scope := range []Scope{GlobalScope, QuizScope(userQuiz.QuizID)} {
+		board, err := boardBucket(ctx, tx, scope)
+		if err != nil {
+			return err
+		}
+		if err := board.Put(scoreKey(userQuiz.Score, id), payload); err != nil {
+			return err
+		}
+	}
+	if err := bumpBoltVersion(ctx, tx); err != nil {
+		return err
+	}
+	return indexMember(ctx, tx, userQuiz.UserID, payload)
+}
+
+// deleteScore removes a submission from every board it is ranked on, under any key
+// encoding.
+func deleteScore(ctx context.Context, tx *bolt.Tx, userQuiz models.UserQuiz) error {
+	payload, err := json.Marshal(userQuiz)
+	if err != nil {
+		return err
+	}
+
+	id := []byte(EntryID(userQuiz))
+	for _, scope := range []Scope{GlobalScope, QuizScope(userQuiz.QuizID)} {
+		board, err := boardBucket(ctx, tx, scope)
+		if err != nil {
+			return err
+		}
+		for _, key := range [][]byte{scoreKey(userQuiz.Score, id), legacyScoreKey(userQuiz.Score, id), legacyScoreKey(userQuiz.Score, payload)} {
+			if err := board.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bumpBoltVersion(ctx, tx); err != nil {
+		return err
+	}
+
+	members, err := tenantBucket(ctx, tx, membersBucket)
+	if err != nil {
+		return err
+	}
+	if index := members.Bucket([]byte(userQuiz.UserID)); index != nil {
+		return index.Delete(payload)
+	}
+	return nil
+}
+
+// bumpBoltVersion increments the request tenant's leaderboard version, seeding a missing counter.
+func bumpBoltVersion(ctx context.Context, tx *bolt.Tx) error {
+	meta, err := tenantBucket(ctx, tx, metaBucket)
+	if err != nil {
+		return err
+	}
+
+	version := versionSeed(time.Now())
+	if raw := meta.Get(versionKey); raw != nil {
+		version = int64(binary.BigEndian.Uint64(raw)) + 1
+	}
+	return meta.Put(versionKey, binary.BigEndian.AppendUint64(nil, uint64(version)))
+}
+
+// indexMember records a board payload under its user so moderation can find it directly.
+func indexMember(ctx context.Context, tx *bolt.Tx, userID string, payload []byte) error {
+	members, err := tenantBucket(ctx, tx, membersBucket)
+	if err != nil {
+		return err
+	}
+	index, err := members.CreateBucketIfNotExists([]byte(userID))
+	if err != nil {
+		return err
+	}
+	return index.Put(payload, []byte{})
+}
+
+// banMode reads the user's ban from the request tenant's bans bucket.
+func banMode(ctx context.Context, tx *bolt.Tx, userID string) (BanMode, error) {
+	bans, err := tenantBucket(ctx, tx, bansBucket)
+	if err != nil || bans == nil {
+		return BanNone, err
+	}
+	return BanMode(bans.Get([]byte(userID))), nil
+}
+
+// boardBucket resolves the bucket of a leaderboard scope, with the same read-only nil
+// semantics as tenantBucket.
+func boardBucket(ctx context.Context, tx *bolt.Tx, scope Scope) (*bolt.Bucket, error) {
+	if scope == GlobalScope {
+		return tenantBucket(ctx, tx, scoresBucket)
+	}
+
+	boards, err := tenantBucket(ctx, tx, boardsBucket)
+	if err != nil || boards == nil {
+		return nil, err
+	}
+
+	name := []byte(scope.QuizID())
+	if !tx.Writable() {
+		return boards.Bucket(name), nil
+	}
+	return boards.CreateBucketIfNotExists(name)
+}
+
+// tenantBucket resolves a bucket for the request tenant. The default tenant uses the
+// top-level buckets; other tenants get them nested under "tenant:<id>", created on first
+// write. Read-only transactions get a nil bucket for tenants that have stored nothing yet.
+func tenantBucket(ctx context.Context, tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	tenant := pkg.GetTenant(ctx).ID
+	if tenant == "" {
+		return tx.Bucket(name), nil
+	}
+
+	root := []byte(tenantKeyNS + ":" + tenant)
+	if !tx.Writable() {
+		parent := tx.Bucket(root)
+		if parent == nil {
+			return nil, nil
+		}
+		return parent.Bucket(name), nil
+	}
+
+	parent, err := tx.CreateBucketIfNotExists(root)
+	if err != nil {
+		return nil, err
+	}
+	return parent.CreateBucketIfNotExists(name)
+}
+
+// scoreKey orders entries by descending score so a forward cursor walks the leaderboard
+// from the top. The member ID is appended, bitwise inverted and terminated, to keep keys
+// unique like ZSET members and to break ties by descending member the same way ZREVRANGE
+// does: the terminator sorts above every inverted byte, so a member comes after the longer
+// members it is a prefix of. A zero byte, whose inversion would equal the terminator's first
+// byte, is escaped. Files written before EntryID used the entry's JSON as the member, and
+// older files lack the terminator; MigrateMembers rewrites both.
+func scoreKey(score float64, member []byte) []byte {
+	key := scorePrefix(score, 2*len(member)+2)
+	for _, b := range member {
+		if b == 0 {
+			key = append(key, 0xff, 0x00)
+			continue
+		}
+		key = append(key, ^b)
+	}
+	return append(key, 0xff, 0xff)
+}
+
+// legacyScoreKey is the unterminated encoding of scoreKey, which put a member ahead of the
+// members it is a prefix of. Only deletes still look it up.
+func legacyScoreKey(score float64, member []byte) []byte {
+	key := scorePrefix(score, len(member))
+	for _, b := range member {
+		key = append(key, ^b)
+	}
+	return key
+}
+
+// scorePrefix encodes score so that higher scores sort first, leaving room for the member.
+func scorePrefix(score float64, room int) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	key := make([]byte, 8, 8+room)
+	binary.BigEndian.PutUint64(key, ^bits)
+	return key
+}
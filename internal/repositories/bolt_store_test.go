@@ -0,0 +1,159 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+func newTestBoltRepo(t *testing.T, path string) *BoltRepository {
+	t.Helper()
+
+	repo, err := NewBoltRepository(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	return repo
+}
+
+func TestBoltRepositoryJoinAndGetQuiz(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	ctx := context.Background()
+
+	err := repo.JoinQuiz(ctx, "user-1", "quiz-1")
+	require.NoError(t, err)
+
+	quizID, err := repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID)
+
+	empty, err := repo.GetQuizByUserID(ctx, "unknown")
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func TestBoltRepositoryMembershipExpires(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	ctx := context.Background()
+
+	now := time.Now()
+	repo.now = func() time.Time { return now }
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+
+	repo.now = func() time.Time { return now.Add(MembershipTTL + time.Second) }
+	quizID, err := repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID)
+}
+
+func TestBoltRepositorySubmitAndListScores(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-3", "quiz-3"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-3", QuizID: "quiz-3", Score: -5}))
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-2", QuizID: "quiz-2", Score: 300},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 120},
+		{UserID: "user-3", QuizID: "quiz-3", Score: -5},
+	}, scores)
+
+	quizID, err := repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID)
+}
+
+func TestBoltRepositorySurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emu.db")
+	ctx := context.Background()
+
+	repo, err := NewBoltRepository(path)
+	require.NoError(t, err)
+	for i := 0; i < 15; i++ {
+		userID, quizID := fmt.Sprintf("user-%02d", i), fmt.Sprintf("quiz-%02d", i)
+		require.NoError(t, repo.JoinQuiz(ctx, userID, quizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{
+			UserID: userID,
+			QuizID: quizID,
+			Score:  float64(100 + i),
+		}))
+	}
+	require.NoError(t, repo.JoinQuiz(ctx, "user-99", "quiz-99"))
+	require.NoError(t, repo.Close())
+
+	repo = newTestBoltRepo(t, path)
+
+	list, err := repo.ListUserScores(ctx, 5, 5)
+	require.NoError(t, err)
+	require.Len(t, list, 5)
+	require.Equal(t, "user-09", list[0].UserID)
+	require.Equal(t, float64(109), list[0].Score)
+	require.Equal(t, "user-05", list[4].UserID)
+
+	quizID, err := repo.GetQuizByUserID(ctx, "user-99")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-99", quizID)
+}
+
+func TestBoltRepositoryMigrateMembersRekeysLegacyEntries(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	ctx := context.Background()
+
+	// Files written before EntryID keyed board entries by their JSON encoding.
+	legacy := models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}
+	payload, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, repo.db.Update(func(tx *bolt.Tx) error {
+		for _, scope := range []Scope{GlobalScope, QuizScope(legacy.QuizID)} {
+			board, err := boardBucket(ctx, tx, scope)
+			if err != nil {
+				return err
+			}
+			if err := board.Put(legacyScoreKey(legacy.Score, payload), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 120}))
+
+	pending, err := repo.MigrateMembers(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+
+	converted, err := repo.MigrateMembers(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, converted)
+
+	again, err := repo.MigrateMembers(ctx, false)
+	require.NoError(t, err)
+	require.Zero(t, again, "migration is idempotent")
+
+	require.NoError(t, repo.db.View(func(tx *bolt.Tx) error {
+		board, err := boardBucket(ctx, tx, QuizScope(legacy.QuizID))
+		require.NoError(t, err)
+		require.Nil(t, board.Get(legacyScoreKey(legacy.Score, payload)))
+		require.Equal(t, payload, board.Get(scoreKey(legacy.Score, []byte(EntryID(legacy)))))
+		return nil
+	}))
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2", "user-1"}, []string{scores[0].UserID, scores[1].UserID})
+}
@@ -0,0 +1,561 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+const (
+	rebuildBatchSize = 500
+	rebuildSuffix    = ":rebuild"
+	// rebuildMarkerTTL bounds how long a rebuild that died keeps submits logging for replay;
+	// a running rebuild refreshes it with every staged batch.
+	rebuildMarkerTTL = time.Minute
+)
+
+// ScoreStore is the durable record of every submission.
+type ScoreStore interface {
+	SaveScore(ctx context.Context, userQuiz models.UserQuiz) error
+	ForEachScore(ctx context.Context, fn func(models.UserQuiz) error) error
+	ImportScores(ctx context.Context, scope Scope, entries []models.UserQuiz, replace bool) error
+	// BatchSubmitter records attempt IDs durably so duplicates survive a cache loss.
+	BatchSubmitter
+	// Moderator keeps bans durable alongside the scores they protect.
+	Moderator
+}
+
+// CachedRepository treats a ScoreStore as the source of truth and Redis sorted sets as a
+// derived leaderboard cache that can be rebuilt at any time. Quiz membership is session
+// state and stays in Redis only.
+type CachedRepository struct {
+	store ScoreStore
+	cache *RedisRepository
+}
+
+func NewCachedRepository(store ScoreStore, cache *RedisRepository) *CachedRepository {
+	return &CachedRepository{store: store, cache: cache}
+}
+
+type RebuildReport struct {
+	Boards map[string]int `json:"boards"`
+}
+
+type BoardDrift struct {
+	Key        string   `json:"key"`
+	Durable    int      `json:"durable"`
+	Cached     int      `json:"cached"`
+	Missing    []string `json:"missing,omitempty"`
+	Extra      []string `json:"extra,omitempty"`
+	Mismatched []string `json:"mismatched,omitempty"`
+}
+
+type ConsistencyReport struct {
+	Consistent bool         `json:"consistent"`
+	Boards     []BoardDrift `json:"boards"`
+}
+
+func (s *CachedRepository) JoinQuiz(ctx context.Context, userID, quizID string) error {
+	// Shadow-banned users keep playing; only their submissions are dropped.
+	if err := s.checkBan(ctx, userID); err != nil && !errors.Is(err, ErrShadowBanned) {
+		return err
+	}
+	return s.cache.JoinQuiz(ctx, userID, quizID)
+}
+
+// LeaveQuiz drops the cached membership; the durable store never holds one.
+func (s *CachedRepository) LeaveQuiz(ctx context.Context, userID, quizID string) error {
+	return s.cache.LeaveQuiz(ctx, userID, quizID)
+}
+
+// SubmitAttempts records the batch in the durable store, which also deduplicates it, then
+// ranks the accepted attempts in the cache.
+func (s *CachedRepository) SubmitAttempts(ctx context.Context, userID string, attempts []Attempt) ([]AttemptStatus, error) {
+	statuses, err := s.store.SubmitAttempts(ctx, userID, attempts)
+	if err != nil {
+		return statuses, err
+	}
+
+	var accepted []models.UserQuiz
+	for i, status := range statuses {
+		if status == AttemptAccepted {
+			accepted = append(accepted, attempts[i].entry(userID))
+		}
+	}
+	if len(accepted) == 0 {
+		return statuses, nil
+	}
+	// The attempts are durable now; a retry would see duplicates, so a failed cache update
+	// is only repaired by Rebuild.
+	if err := s.cache.rankScores(ctx, accepted...); err != nil {
+		return nil, fmt.Errorf("update leaderboard cache: %w", err)
+	}
+	return statuses, nil
+}
+
+func (s *CachedRepository) UserAttempts(ctx context.Context, userID string) (map[string]time.Time, error) {
+	return s.store.UserAttempts(ctx, userID)
+}
+
+func (s *CachedRepository) DeleteAttempts(ctx context.Context, userID string) error {
+	return s.store.DeleteAttempts(ctx, userID)
+}
+
+func (s *CachedRepository) GetQuizByUserID(ctx context.Context, userID string) (string, error) {
+	return s.cache.GetQuizByUserID(ctx, userID)
+}
+
+func (s *CachedRepository) SubmitQuiz(ctx context.Context, userQuiz models.UserQuiz) error {
+	err := s.checkBan(ctx, userQuiz.UserID)
+	shadowed := errors.Is(err, ErrShadowBanned)
+	if err != nil && !shadowed {
+		return err
+	}
+
+	// Claim the membership first so only one concurrent submission reaches the durable store.
+	ttl, err := s.cache.claimMembership(ctx, userQuiz.UserID, userQuiz.QuizID)
+	if err != nil {
+		return err
+	}
+	// A shadow ban consumes the membership like a real submit but never records the score.
+	if shadowed {
+		return ErrShadowBanned
+	}
+
+	// Write-through: once the durable write succeeds the score can always be recovered,
+	// even if the cache update below fails.
+	if err := s.store.SaveScore(ctx, userQuiz); err != nil {
+		// Hand the membership back so the user can retry without re-joining.
+		if restoreErr := s.cache.restoreMembership(ctx, userQuiz.UserID, userQuiz.QuizID, ttl); restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+		return fmt.Errorf("save durable score: %w", err)
+	}
+
+	if err := s.cache.rankScores(ctx, userQuiz); err != nil {
+		return fmt.Errorf("update leaderboard cache: %w", err)
+	}
+
+	return nil
+}
+
+func (s *CachedRepository) ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error) {
+	return s.cache.ListUserScores(ctx, from, limit)
+}
+
+func (s *CachedRepository) ListScopeScores(ctx context.Context, scope Scope, from, limit int64) ([]models.UserQuiz, error) {
+	return s.cache.ListScopeScores(ctx, scope, from, limit)
+}
+
+func (s *CachedRepository) UserRank(ctx context.Context, scope Scope, userID string) (RankedEntry, error) {
+	return s.cache.UserRank(ctx, scope, userID)
+}
+
+func (s *CachedRepository) ImportScores(ctx context.Context, scope Scope, entries []models.UserQuiz, replace bool) error {
+	if err := s.store.ImportScores(ctx, scope, entries, replace); err != nil {
+		return fmt.Errorf("import durable scores: %w", err)
+	}
+
+	if err := s.cache.ImportScores(ctx, scope, entries, replace); err != nil {
+		return fmt.Errorf("update leaderboard cache: %w", err)
+	}
+
+	return nil
+}
+
+func (s *CachedRepository) ListMemberships(ctx context.Context) ([]Membership, error) {
+	return s.cache.ListMemberships(ctx)
+}
+
+func (s *CachedRepository) ReplaceMemberships(ctx context.Context, memberships []Membership) error {
+	return s.cache.ReplaceMemberships(ctx, memberships)
+}
+
+func (s *CachedRepository) UserScores(ctx context.Context, userID string) ([]models.UserQuiz, error) {
+	return s.store.UserScores(ctx, userID)
+}
+
+// DeleteMembership drops the cached membership; the durable store never holds one.
+func (s *CachedRepository) DeleteMembership(ctx context.Context, userID string) error {
+	return s.cache.DeleteMembership(ctx, userID)
+}
+
+func (s *CachedRepository) DeleteUserScores(ctx context.Context, userID string) (int, error) {
+	removed, err := s.store.DeleteUserScores(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("delete durable scores: %w", err)
+	}
+
+	if _, err := s.cache.DeleteUserScores(ctx, userID); err != nil {
+		return removed, fmt.Errorf("update leaderboard cache: %w", err)
+	}
+	return removed, nil
+}
+
+func (s *CachedRepository) DeleteQuizScores(ctx context.Context, quizID string) (int, error) {
+	removed, err := s.store.DeleteQuizScores(ctx, quizID)
+	if err != nil {
+		return 0, fmt.Errorf("delete durable scores: %w", err)
+	}
+
+	if _, err := s.cache.DeleteQuizScores(ctx, quizID); err != nil {
+		return removed, fmt.Errorf("update leaderboard cache: %w", err)
+	}
+	return removed, nil
+}
+
+// SetBan records the ban durably and drops the user's cached membership.
+func (s *CachedRepository) SetBan(ctx context.Context, userID string, mode BanMode) error {
+	if err := s.store.SetBan(ctx, userID, mode); err != nil {
+		return err
+	}
+	if mode == BanNone {
+		return nil
+	}
+	return s.cache.client.Del(ctx, s.cache.keys(ctx).User(userID)).Err()
+}
+
+func (s *CachedRepository) GetBan(ctx context.Context, userID string) (BanMode, error) {
+	return s.store.GetBan(ctx, userID)
+}
+
+func (s *CachedRepository) ListBans(ctx context.Context) (map[string]BanMode, error) {
+	return s.store.ListBans(ctx)
+}
+
+// LeaderboardVersion is the version of the cached boards, which serve every read.
+func (s *CachedRepository) LeaderboardVersion(ctx context.Context) (int64, error) {
+	return s.cache.LeaderboardVersion(ctx)
+}
+
+// MigrateMembers converts the durable store, when it has legacy members of its own, then the
+// cache, and returns how many cached entries were converted.
+func (s *CachedRepository) MigrateMembers(ctx context.Context, dryRun bool) (int, error) {
+	if migrator, ok := s.store.(MemberMigrator); ok {
+		if _, err := migrator.MigrateMembers(ctx, dryRun); err != nil {
+			return 0, fmt.Errorf("migrate durable scores: %w", err)
+		}
+	}
+
+	converted, err := s.cache.MigrateMembers(ctx, dryRun)
+	if err != nil {
+		return converted, fmt.Errorf("migrate leaderboard cache: %w", err)
+	}
+	return converted, nil
+}
+
+func (s *CachedRepository) ReindexUsers(ctx context.Context) (int, error) {
+	indexed, err := s.store.ReindexUsers(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reindex durable scores: %w", err)
+	}
+
+	if _, err := s.cache.ReindexUsers(ctx); err != nil {
+		return indexed, fmt.Errorf("reindex leaderboard cache: %w", err)
+	}
+	return indexed, nil
+}
+
+// checkBan maps the user's durable ban to ErrBanned or ErrShadowBanned.
+func (s *CachedRepository) checkBan(ctx context.Context, userID string) error {
+	mode, err := s.store.GetBan(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("read ban: %w", err)
+	}
+
+	switch mode {
+	case BanBlocked:
+		return ErrBanned
+	case BanShadow:
+		return ErrShadowBanned
+	}
+	return nil
+}
+
+// Rebuild repopulates every leaderboard sorted set of the request tenant, the entries they
+// reference and the user index used by moderation, from the durable records. Each key is
+// staged under a temporary key and swapped in with RENAME so readers never see a partial
+// board. Boards and indexes left without durable records are deleted. Scores ranked while
+// the rebuild runs are logged and replayed after the swap, so none are lost.
+func (s *CachedRepository) Rebuild(ctx context.Context) (*RebuildReport, error) {
+	client := s.cache.client
+	report := &RebuildReport{Boards: make(map[string]int)}
+
+	keys := s.cache.keys(ctx)
+	if err := client.Set(ctx, keys.Rebuilding(), 1, rebuildMarkerTTL).Err(); err != nil {
+		return nil, fmt.Errorf("mark rebuild: %w", err)
+	}
+	defer client.Del(context.WithoutCancel(ctx), keys.Rebuilding(), keys.RebuildLog())
+
+	staged := make(map[string]string)
+	pipe := client.Pipeline()
+	flush := func() error {
+		if pipe.Len() == 0 {
+			return nil
+		}
+		// Keep the marker alive for as long as staging takes.
+		pipe.Expire(ctx, keys.Rebuilding(), rebuildMarkerTTL)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+
+	stage := func(key string) string {
+		tmp, ok := staged[key]
+		if !ok {
+			tmp = rebuildKey(key)
+			staged[key] = tmp
+			pipe.Del(ctx, tmp)
+		}
+		return tmp
+	}
+
+	err := s.store.ForEachScore(ctx, func(userQuiz models.UserQuiz) error {
+		payload, err := json.Marshal(userQuiz)
+		if err != nil {
+			return err
+		}
+		id := EntryID(userQuiz)
+
+		for _, key := range keys.Boards(userQuiz) {
+			pipe.ZAdd(ctx, stage(key), redis.Z{Member: id, Score: userQuiz.Score})
+			report.Boards[key]++
+		}
+		pipe.HSet(ctx, stage(keys.Entries()), id, payload)
+		pipe.SAdd(ctx, stage(keys.UserIndex(userQuiz.UserID)), id)
+
+		if pipe.Len() >= rebuildBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stage leaderboards: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, fmt.Errorf("stage leaderboards: %w", err)
+	}
+
+	for key, tmp := range staged {
+		if err := client.Rename(ctx, tmp, key).Err(); err != nil {
+			return nil, fmt.Errorf("swap leaderboard %s: %w", key, err)
+		}
+	}
+
+	// The global board always exists; clear it and its entries when there is nothing durable
+	// to rebuild from.
+	global := keys.Scores()
+	if _, ok := staged[global]; !ok {
+		if err := client.Del(ctx, global, keys.Entries()).Err(); err != nil {
+			return nil, err
+		}
+		report.Boards[global] = 0
+	}
+	if err := s.deleteUnstaged(ctx, keys, staged); err != nil {
+		return nil, err
+	}
+	// Replay last: entries logged before this point may have been swapped over or swept, while
+	// those ranked from now on land on the live keys.
+	if err := s.replayRebuildLog(ctx, keys); err != nil {
+		return nil, err
+	}
+
+	if err := s.cache.incrVersion(ctx); err != nil {
+		return nil, fmt.Errorf("bump leaderboard version: %w", err)
+	}
+
+	return report, nil
+}
+
+// deleteUnstaged removes the quiz boards and user indexes of the tenant a rebuild did not
+// stage, as no durable record references them any more.
+func (s *CachedRepository) deleteUnstaged(ctx context.Context, keys Keyspace, staged map[string]string) error {
+	var stale []string
+	for _, pattern := range []string{keys.Board(QuizScope("*")), keys.UserIndex("*")} {
+		found, err := s.cache.scanKeys(ctx, pattern)
+		if err != nil {
+			return fmt.Errorf("scan leaderboards: %w", err)
+		}
+		for _, key := range found {
+			if _, ok := staged[key]; !ok && !strings.HasSuffix(key, rebuildSuffix) {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	for start := 0; start < len(stale); start += rebuildBatchSize {
+		end := min(start+rebuildBatchSize, len(stale))
+		if err := s.cache.client.Del(ctx, stale[start:end]...).Err(); err != nil {
+			return fmt.Errorf("delete stale leaderboards: %w", err)
+		}
+	}
+	return nil
+}
+
+// replayRebuildLog ranks again the entries submitted while a rebuild ran.
+func (s *CachedRepository) replayRebuildLog(ctx context.Context, keys Keyspace) error {
+	logged, err := s.cache.client.LRange(ctx, keys.RebuildLog(), 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("read rebuild log: %w", err)
+	}
+	if len(logged) == 0 {
+		return nil
+	}
+
+	pipe := s.cache.client.TxPipeline()
+	for _, payload := range logged {
+		var userQuiz models.UserQuiz
+		if err := json.Unmarshal([]byte(payload), &userQuiz); err != nil {
+			return fmt.Errorf("decode rebuild log: %w", err)
+		}
+		addEntry(ctx, pipe, keys, userQuiz, []byte(payload))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("replay rebuild log: %w", err)
+	}
+	return nil
+}
+
+// CheckConsistency compares the request tenant's durable records with the cached sorted
+// sets and reports member IDs that are missing from the cache, present only in the cache,
+// or cached with a different score. Quiz boards and user indexes cached without any durable
+// record are reported with all their members extra.
+func (s *CachedRepository) CheckConsistency(ctx context.Context) (*ConsistencyReport, error) {
+	keys := s.cache.keys(ctx)
+	durable := map[string]map[string]float64{keys.Scores(): {}}
+	indexed := make(map[string]bool)
+	err := s.store.ForEachScore(ctx, func(userQuiz models.UserQuiz) error {
+		for _, key := range keys.Boards(userQuiz) {
+			if durable[key] == nil {
+				durable[key] = make(map[string]float64)
+			}
+			durable[key][EntryID(userQuiz)] = userQuiz.Score
+		}
+		indexed[keys.UserIndex(userQuiz.UserID)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read durable scores: %w", err)
+	}
+
+	report := &ConsistencyReport{Consistent: true}
+	for key, members := range durable {
+		cached, err := s.boardMembers(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("read leaderboard %s: %w", key, err)
+		}
+
+		drift := BoardDrift{Key: key, Durable: len(members), Cached: len(cached)}
+		for member, score := range members {
+			cachedScore, ok := cached[member]
+			switch {
+			case !ok:
+				drift.Missing = append(drift.Missing, member)
+			case cachedScore != score:
+				drift.Mismatched = append(drift.Mismatched, member)
+			}
+		}
+		for member := range cached {
+			if _, ok := members[member]; !ok {
+				drift.Extra = append(drift.Extra, member)
+			}
+		}
+
+		if len(drift.Missing) > 0 || len(drift.Extra) > 0 || len(drift.Mismatched) > 0 {
+			report.Consistent = false
+		}
+		report.Boards = append(report.Boards, drift)
+	}
+
+	stray, err := s.strayKeys(ctx, keys, durable, indexed)
+	if err != nil {
+		return nil, err
+	}
+	report.Boards = append(report.Boards, stray...)
+	if len(stray) > 0 {
+		report.Consistent = false
+	}
+	sort.Slice(report.Boards, func(i, j int) bool { return report.Boards[i].Key < report.Boards[j].Key })
+
+	return report, nil
+}
+
+// strayKeys reports the cached quiz boards and user indexes that no durable record
+// references, the keys Rebuild would delete.
+func (s *CachedRepository) strayKeys(ctx context.Context, keys Keyspace, durable map[string]map[string]float64, indexed map[string]bool) ([]BoardDrift, error) {
+	var stray []BoardDrift
+	boards, err := s.cache.scanKeys(ctx, keys.Board(QuizScope("*")))
+	if err != nil {
+		return nil, fmt.Errorf("scan leaderboards: %w", err)
+	}
+	for _, key := range boards {
+		if _, ok := durable[key]; ok || strings.HasSuffix(key, rebuildSuffix) {
+			continue
+		}
+		cached, err := s.boardMembers(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("read leaderboard %s: %w", key, err)
+		}
+		drift := BoardDrift{Key: key, Cached: len(cached)}
+		for member := range cached {
+			drift.Extra = append(drift.Extra, member)
+		}
+		stray = append(stray, drift)
+	}
+
+	indexes, err := s.cache.scanKeys(ctx, keys.UserIndex("*"))
+	if err != nil {
+		return nil, fmt.Errorf("scan user indexes: %w", err)
+	}
+	for _, key := range indexes {
+		if indexed[key] || strings.HasSuffix(key, rebuildSuffix) {
+			continue
+		}
+		members, err := s.cache.client.SMembers(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("read user index %s: %w", key, err)
+		}
+		stray = append(stray, BoardDrift{Key: key, Cached: len(members), Extra: members})
+	}
+	return stray, nil
+}
+
+func (s *CachedRepository) boardMembers(ctx context.Context, key string) (map[string]float64, error) {
+	members := make(map[string]float64)
+
+	var cursor uint64
+	for {
+		vals, next, err := s.cache.client.ZScan(ctx, key, cursor, "", rebuildBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// ZSCAN replies alternate member and score.
+		for i := 0; i+1 < len(vals); i += 2 {
+			score, err := strconv.ParseFloat(vals[i+1], 64)
+			if err != nil {
+				return nil, err
+			}
+			members[vals[i]] = score
+		}
+
+		if next == 0 {
+			return members, nil
+		}
+		cursor = next
+	}
+}
+
+// rebuildKey stages a board next to its live key; sharing the key's hash tag keeps the
+// final RENAME within one cluster slot.
+func rebuildKey(key string) string {
+	return key + rebuildSuffix
+}
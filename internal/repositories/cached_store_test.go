@@ -0,0 +1,234 @@
+package repositories
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+var scoresKey = NewKeyspace(keyPrefix).Scores()
+
+func newTestCachedRepo(t *testing.T) (*CachedRepository, *miniredis.Miniredis) {
+	t.Helper()
+
+	cache, mr := newTestRepo(t)
+	store := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+
+	return NewCachedRepository(store, cache), mr
+}
+
+func TestCachedRepositorySubmitWritesThrough(t *testing.T) {
+	repo, mr := newTestCachedRepo(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+
+	quizID, err := repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID)
+
+	members, err := mr.ZMembers(scoresKey)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+
+	report, err := repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.True(t, report.Consistent)
+}
+
+func TestCachedRepositoryRebuildAfterCacheLoss(t *testing.T) {
+	repo, mr := newTestCachedRepo(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300}))
+
+	mr.FlushAll()
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, scores)
+
+	report, err := repo.Rebuild(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		scoresKey:                     2,
+		"emu-game:scores:quiz:quiz-1": 1,
+		"emu-game:scores:quiz:quiz-2": 1,
+	}, report.Boards)
+	require.False(t, mr.Exists(rebuildKey(scoresKey)))
+
+	scores, err = repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-2", QuizID: "quiz-2", Score: 300},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 120},
+	}, scores)
+}
+
+func TestCachedRepositoryRebuildEmptyStoreClearsBoard(t *testing.T) {
+	repo, mr := newTestCachedRepo(t)
+	ctx := context.Background()
+
+	_, err := mr.ZAdd(scoresKey, 10, `{"user_id":"ghost","quiz_id":"q","score":10}`)
+	require.NoError(t, err)
+
+	report, err := repo.Rebuild(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{scoresKey: 0}, report.Boards)
+	require.False(t, mr.Exists(scoresKey))
+}
+
+// hookedStore calls afterRead once a rebuild has read every durable record.
+type hookedStore struct {
+	ScoreStore
+	afterRead func()
+}
+
+func (s hookedStore) ForEachScore(ctx context.Context, fn func(models.UserQuiz) error) error {
+	err := s.ScoreStore.ForEachScore(ctx, fn)
+	s.afterRead()
+	return err
+}
+
+func TestCachedRepositoryRebuildKeepsConcurrentSubmits(t *testing.T) {
+	cache, mr := newTestRepo(t)
+	store := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	ctx := context.Background()
+
+	var repo *CachedRepository
+	late := models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300}
+	repo = NewCachedRepository(hookedStore{ScoreStore: store, afterRead: func() {
+		// Lands after the durable records were read, before the boards are swapped in.
+		require.NoError(t, repo.JoinQuiz(ctx, late.UserID, late.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, late))
+	}}, cache)
+
+	require.NoError(t, store.SaveScore(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+	_, err := repo.Rebuild(ctx)
+	require.NoError(t, err)
+
+	scores, err := repo.ListScopeScores(ctx, QuizScope("quiz-2"), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{late}, scores, "a submit made during the rebuild is replayed")
+	ranked, err := repo.UserScores(ctx, "user-2")
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{late}, ranked)
+
+	keys := NewKeyspace(keyPrefix)
+	require.False(t, mr.Exists(keys.Rebuilding()))
+	require.False(t, mr.Exists(keys.RebuildLog()))
+	report, err := repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.True(t, report.Consistent)
+}
+
+func TestCachedRepositoryRebuildDeletesStaleKeys(t *testing.T) {
+	cache, mr := newTestRepo(t)
+	store := newTestBoltRepo(t, filepath.Join(t.TempDir(), "emu.db"))
+	repo := NewCachedRepository(store, cache)
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300}))
+
+	// The durable records of user-1 go away without the cache hearing of it.
+	_, err := store.DeleteUserScores(ctx, "user-1")
+	require.NoError(t, err)
+
+	_, err = repo.Rebuild(ctx)
+	require.NoError(t, err)
+
+	keys := NewKeyspace(keyPrefix)
+	require.False(t, mr.Exists(keys.Board(QuizScope("quiz-1"))), "a quiz board without durable records is deleted")
+	require.False(t, mr.Exists(keys.UserIndex("user-1")), "a user index without durable records is deleted")
+	require.True(t, mr.Exists(keys.Board(QuizScope("quiz-2"))))
+	require.True(t, mr.Exists(keys.UserIndex("user-2")))
+}
+
+func TestCachedRepositoryCheckConsistencyReportsCacheOnlyKeys(t *testing.T) {
+	repo, mr := newTestCachedRepo(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+
+	// A quiz board and user index the durable store never recorded.
+	keys := NewKeyspace(keyPrefix)
+	ghost := EntryID(models.UserQuiz{UserID: "ghost", QuizID: "quiz-9", Score: 10})
+	_, err := mr.ZAdd(keys.Board(QuizScope("quiz-9")), 10, ghost)
+	require.NoError(t, err)
+	_, err = mr.SAdd(keys.UserIndex("ghost"), ghost)
+	require.NoError(t, err)
+
+	report, err := repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.False(t, report.Consistent)
+	require.Equal(t, []BoardDrift{
+		{Key: "emu-game:index:user:ghost", Cached: 1, Extra: []string{ghost}},
+		{Key: scoresKey, Durable: 1, Cached: 1},
+		{Key: "emu-game:scores:quiz:quiz-1", Durable: 1, Cached: 1},
+		{Key: "emu-game:scores:quiz:quiz-9", Cached: 1, Extra: []string{ghost}},
+	}, report.Boards)
+
+	_, err = repo.Rebuild(ctx)
+	require.NoError(t, err)
+
+	report, err = repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.True(t, report.Consistent)
+}
+
+func TestCachedRepositoryCheckConsistencyReportsDrift(t *testing.T) {
+	repo, mr := newTestCachedRepo(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300}))
+
+	missing := EntryID(models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120})
+	mismatched := EntryID(models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300})
+	extra := EntryID(models.UserQuiz{UserID: "ghost", QuizID: "q", Score: 10})
+
+	_, err := mr.ZRem(scoresKey, missing)
+	require.NoError(t, err)
+	_, err = mr.ZAdd(scoresKey, 999, mismatched)
+	require.NoError(t, err)
+	_, err = mr.ZAdd(scoresKey, 10, extra)
+	require.NoError(t, err)
+
+	report, err := repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.False(t, report.Consistent)
+	require.Equal(t, []BoardDrift{
+		{
+			Key:        scoresKey,
+			Durable:    2,
+			Cached:     2,
+			Missing:    []string{missing},
+			Extra:      []string{extra},
+			Mismatched: []string{mismatched},
+		},
+		{Key: "emu-game:scores:quiz:quiz-1", Durable: 1, Cached: 1},
+		{Key: "emu-game:scores:quiz:quiz-2", Durable: 1, Cached: 1},
+	}, report.Boards)
+
+	_, err = repo.Rebuild(ctx)
+	require.NoError(t, err)
+
+	report, err = repo.CheckConsistency(ctx)
+	require.NoError(t, err)
+	require.True(t, report.Consistent)
+}
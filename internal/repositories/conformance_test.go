@@ -0,0 +1,62 @@
+package repositories_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/repositories/repotest"
+)
+
+func newRedisHarness(t *testing.T) (*repositories.RedisRepository, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+
+	return repo, mr
+}
+
+func newBoltHarness(t *testing.T) (*repositories.BoltRepository, func(time.Duration)) {
+	t.Helper()
+
+	repo, err := repositories.NewBoltRepository(filepath.Join(t.TempDir(), "emu.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	now := time.Now()
+	repositories.SetBoltClock(repo, func() time.Time { return now })
+
+	return repo, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRedisRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Harness {
+		repo, mr := newRedisHarness(t)
+		return repotest.Harness{Repo: repo, Advance: mr.FastForward}
+	})
+}
+
+func TestBoltRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Harness {
+		repo, advance := newBoltHarness(t)
+		return repotest.Harness{Repo: repo, Advance: advance}
+	})
+}
+
+func TestCachedRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Harness {
+		cache, mr := newRedisHarness(t)
+		store, _ := newBoltHarness(t)
+		return repotest.Harness{
+			Repo:    repositories.NewCachedRepository(store, cache),
+			Advance: mr.FastForward,
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+// entryDigestSize is how many bytes of the entry digest an ID keeps.
+const entryDigestSize = 9
+
+// EntryID is the compact leaderboard member of an entry: the user ID, so equal scores keep
+// ordering by user, then a digest of the whole entry. Identical submissions share an ID and
+// collapse into one entry, as they did when the member was the entry's JSON encoding.
+func EntryID(userQuiz models.UserQuiz) string {
+	h := sha256.New()
+	h.Write([]byte(userQuiz.UserID))
+	h.Write([]byte{0})
+	h.Write([]byte(userQuiz.QuizID))
+	h.Write([]byte{0})
+	h.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(userQuiz.Score)))
+	return userQuiz.UserID + ":" + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:entryDigestSize])
+}
+
+// MemberMigrator is implemented by repositories that can convert leaderboard members
+// written before entries had compact IDs, when the member was the entry's JSON encoding.
+type MemberMigrator interface {
+	// MigrateMembers converts every legacy member of the request tenant and returns how
+	// many entries it converted, or would convert when dryRun is set. It is idempotent, and
+	// readers understand both encodings while it runs.
+	MigrateMembers(ctx context.Context, dryRun bool) (int, error)
+}
+
+// legacyEntry decodes a member written in the legacy JSON encoding.
+func legacyEntry(member string) (models.UserQuiz, bool) {
+	var entry models.UserQuiz
+	if !strings.HasPrefix(member, "{") || json.Unmarshal([]byte(member), &entry) != nil {
+		return entry, false
+	}
+	return entry, true
+}
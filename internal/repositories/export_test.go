@@ -0,0 +1,8 @@
+package repositories
+
+import "time"
+
+// SetBoltClock lets external tests drive membership expiry of a BoltRepository.
+func SetBoltClock(repo *BoltRepository, now func() time.Time) {
+	repo.now = now
+}
@@ -0,0 +1,172 @@
+package repositories
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+const (
+	keyPrefix   = "emu-game"
+	tenantKeyNS = "tenant"
+
+	snapshotKeyVersion = "v1"
+)
+
+// Keyspace builds every Redis key the repositories touch from a common prefix.
+//
+// On Redis Cluster the prefix is wrapped in a hash tag ("{emu-game}") so all keys land in
+// the same slot; multi-key operations such as the MULTI in SubmitQuiz and the RENAME in a
+// cache rebuild would otherwise fail with CROSSSLOT. The trade-off is that the game's data
+// lives on a single shard, which matches the single global leaderboard.
+type Keyspace struct {
+	prefix string
+}
+
+func NewKeyspace(prefix string) Keyspace {
+	return Keyspace{prefix: prefix}
+}
+
+// HashTaggedKeyspace returns a Keyspace whose keys all hash to one cluster slot.
+func HashTaggedKeyspace(prefix string) Keyspace {
+	return Keyspace{prefix: "{" + prefix + "}"}
+}
+
+// KeyspaceFor picks the layout for a tenant on a client: hash-tagged on Cluster, plain
+// otherwise, so standalone and Sentinel deployments keep their existing key names. The
+// default tenant ("") owns the legacy emu-game:* keys; other tenants live under
+// emu-game:tenant:<id>:*, which also gives each tenant its own cluster slot.
+func KeyspaceFor(client redis.UniversalClient, tenant string) Keyspace {
+	prefix := keyPrefix
+	if tenant != "" {
+		prefix = fmt.Sprintf("%s:%s:%s", keyPrefix, tenantKeyNS, tenant)
+	}
+
+	if _, ok := client.(*redis.ClusterClient); ok {
+		return HashTaggedKeyspace(prefix)
+	}
+	return NewKeyspace(prefix)
+}
+
+// Scores is the global leaderboard sorted set.
+func (k Keyspace) Scores() string {
+	return k.prefix + ":scores"
+}
+
+// User holds the quiz a user has currently joined.
+func (k Keyspace) User(userID string) string {
+	return fmt.Sprintf("%s:user:%s", k.prefix, userID)
+}
+
+// Board is the sorted set of a leaderboard scope; the global scope is Scores.
+func (k Keyspace) Board(scope Scope) string {
+	if scope == GlobalScope {
+		return k.Scores()
+	}
+	return fmt.Sprintf("%s:scores:quiz:%s", k.prefix, scope.QuizID())
+}
+
+// Boards lists every leaderboard sorted set a submission is ranked in.
+func (k Keyspace) Boards(userQuiz models.UserQuiz) []string {
+	return []string{k.Scores(), k.Board(QuizScope(userQuiz.QuizID))}
+}
+
+// Entries maps the member IDs of every board to the JSON encoding of their entry; see
+// EntryID.
+func (k Keyspace) Entries() string {
+	return k.prefix + ":scores:entries"
+}
+
+// Version counts changes to any leaderboard of the tenant; see Versioned.
+func (k Keyspace) Version() string {
+	return k.prefix + ":scores:version"
+}
+
+// Snapshots indexes the snapshot ids of a tenant by creation time.
+func (k Keyspace) Snapshots() string {
+	return k.prefix + ":snapshots:" + snapshotKeyVersion
+}
+
+// Snapshot holds one encoded snapshot. The version segment lets a future encoding live
+// next to existing snapshots instead of overwriting them.
+func (k Keyspace) Snapshot(id string) string {
+	return fmt.Sprintf("%s:snapshots:%s:%s", k.prefix, snapshotKeyVersion, id)
+}
+
+// UserIndex is the set of leaderboard members submitted by a user, so moderation can remove
+// them without scanning the boards. It lives outside the user: namespace so membership scans
+// never see it.
+func (k Keyspace) UserIndex(userID string) string {
+	return fmt.Sprintf("%s:index:user:%s", k.prefix, userID)
+}
+
+// Rebuilding is set while a cache rebuild of the tenant runs; see RebuildLog.
+func (k Keyspace) Rebuilding() string {
+	return k.prefix + ":rebuild:active"
+}
+
+// RebuildLog queues the entries ranked while a rebuild runs, so they are replayed once its
+// boards are swapped in.
+func (k Keyspace) RebuildLog() string {
+	return k.prefix + ":rebuild:log"
+}
+
+// Attempts scores the offline attempt IDs a user submitted by when they were played, so
+// retried uploads are recognised and old IDs can be trimmed.
+func (k Keyspace) Attempts(userID string) string {
+	return fmt.Sprintf("%s:attempts:%s", k.prefix, userID)
+}
+
+// SchemaVersion records the last schema migration applied to the tenant's keys.
+func (k Keyspace) SchemaVersion() string {
+	return k.prefix + ":schema:version"
+}
+
+// SchemaLock is held by the instance migrating the tenant's keys.
+func (k Keyspace) SchemaLock() string {
+	return k.prefix + ":schema:lock"
+}
+
+// EventSeq counts the events published for the tenant; each event is numbered with it.
+func (k Keyspace) EventSeq() string {
+	return k.prefix + ":events:seq"
+}
+
+// EventStream retains the tenant's recent events under IDs "<seq>-0" for replay.
+func (k Keyspace) EventStream() string {
+	return k.prefix + ":events:stream"
+}
+
+// EventFloor is the highest sequence retention dropped from EventStream.
+func (k Keyspace) EventFloor() string {
+	return k.prefix + ":events:floor"
+}
+
+// Inbox queues the notifications of a user until one of their connections receives them.
+func (k Keyspace) Inbox(userID string) string {
+	return fmt.Sprintf("%s:inbox:%s", k.prefix, userID)
+}
+
+// Presence counts, for each user with a websocket connection in scope, the instances holding one.
+func (k Keyspace) Presence(scope Scope) string {
+	return fmt.Sprintf("%s:presence:%s", k.prefix, scope)
+}
+
+// PresenceInstances scores the instances holding connections of the tenant by when their
+// claims expire.
+func (k Keyspace) PresenceInstances() string {
+	return k.prefix + ":presence:instances"
+}
+
+// PresenceInstance lists the scope and user pairs an instance holds connections of, so they
+// can be released when the instance stops.
+func (k Keyspace) PresenceInstance(id string) string {
+	return fmt.Sprintf("%s:presence:instance:%s", k.prefix, id)
+}
+
+// Bans maps banned user IDs to their ban mode.
+func (k Keyspace) Bans() string {
+	return k.prefix + ":bans"
+}
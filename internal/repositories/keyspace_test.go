@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+func TestKeyspaceForStandaloneKeepsLegacyKeys(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+
+	keys := KeyspaceFor(client, "")
+	require.Equal(t, "emu-game:scores", keys.Scores())
+	require.Equal(t, "emu-game:user:user-1", keys.User("user-1"))
+}
+
+func TestKeyspaceForTenant(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+
+	keys := KeyspaceFor(client, "acme")
+	require.Equal(t, "emu-game:tenant:acme:scores", keys.Scores())
+	require.Equal(t, "emu-game:tenant:acme:user:user-1", keys.User("user-1"))
+
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:0"}})
+	defer cluster.Close()
+
+	require.Equal(t, "{emu-game:tenant:acme}:scores", KeyspaceFor(cluster, "acme").Scores())
+}
+
+func TestKeyspaceForClusterSharesOneSlot(t *testing.T) {
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:0"}})
+	defer client.Close()
+
+	keys := KeyspaceFor(client, "")
+	entry := models.UserQuiz{UserID: "user-1", QuizID: "quiz-1"}
+
+	// Every key touched by SubmitQuiz, Rebuild and its replay log, event publishing and
+	// presence claims must carry the same hash tag.
+	touched := append([]string{keys.User(entry.UserID), keys.Entries(), rebuildKey(keys.Scores()), keys.EventSeq(), keys.EventStream(), keys.EventFloor(),
+		keys.Presence(QuizScope(entry.QuizID)), keys.PresenceInstances(), keys.PresenceInstance("a1b2"), keys.Rebuilding(), keys.RebuildLog()}, keys.Boards(entry)...)
+	for _, key := range touched {
+		require.Regexp(t, `^\{emu-game\}:`, key)
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	scope, err := ParseScope("")
+	require.NoError(t, err)
+	require.Equal(t, GlobalScope, scope)
+
+	scope, err = ParseScope("quiz:42")
+	require.NoError(t, err)
+	require.Equal(t, QuizScope("42"), scope)
+	require.Equal(t, "42", scope.QuizID())
+	require.Equal(t, "emu-game:scores:quiz:42", NewKeyspace(keyPrefix).Board(scope))
+
+	for _, raw := range []string{"quiz:", "weekly", "user:1"} {
+		_, err := ParseScope(raw)
+		require.ErrorIs(t, err, ErrInvalidScope)
+	}
+}
@@ -0,0 +1,22 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// Membership is the quiz a user has joined and how long the membership has left to live.
+type Membership struct {
+	UserID string        `json:"user_id"`
+	QuizID string        `json:"quiz_id"`
+	TTL    time.Duration `json:"ttl"`
+}
+
+// MembershipStore gives admin tooling bulk access to the active memberships of the request
+// tenant, so they can be captured and restored alongside the leaderboards.
+type MembershipStore interface {
+	ListMemberships(ctx context.Context) ([]Membership, error)
+	// ReplaceMemberships drops every active membership and stores the given ones, each
+	// expiring after its own TTL.
+	ReplaceMemberships(ctx context.Context, memberships []Membership) error
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+var (
+	// ErrBanned means the user is banned; joins and submits are rejected.
+	ErrBanned = errors.New("user is banned")
+	// ErrShadowBanned means a shadow-banned user's submission was accepted but not ranked.
+	// Callers should report success to the user and keep the score out of broadcasts.
+	ErrShadowBanned = errors.New("user is shadow-banned")
+
+	ErrInvalidBanMode = errors.New("ban mode must be \"ban\" or \"shadow\"")
+)
+
+// BanMode is the moderation state of a user.
+type BanMode string
+
+const (
+	BanNone BanMode = ""
+	// BanBlocked rejects the user's joins and submits.
+	BanBlocked BanMode = "ban"
+	// BanShadow lets the user play, but their submissions are silently never ranked.
+	BanShadow BanMode = "shadow"
+)
+
+func ParseBanMode(raw string) (BanMode, error) {
+	switch mode := BanMode(raw); mode {
+	case BanBlocked, BanShadow:
+		return mode, nil
+	default:
+		return BanNone, fmt.Errorf("%w: %q", ErrInvalidBanMode, raw)
+	}
+}
+
+// Moderator removes leaderboard entries and bans users for the request tenant. Removals by
+// user go through a user-to-members index instead of scanning the boards.
+type Moderator interface {
+	// UserScores lists every entry of the user across all boards, highest score first.
+	UserScores(ctx context.Context, userID string) ([]models.UserQuiz, error)
+	// DeleteMembership drops the user's active membership, if any.
+	DeleteMembership(ctx context.Context, userID string) error
+	// DeleteUserScores removes every entry of the user from all boards and returns how many
+	// submissions were removed.
+	DeleteUserScores(ctx context.Context, userID string) (int, error)
+	// DeleteQuizScores removes every entry of the quiz from all boards and returns how many
+	// submissions were removed.
+	DeleteQuizScores(ctx context.Context, quizID string) (int, error)
+	// SetBan applies a ban mode, or lifts the ban with BanNone. Banning also drops the
+	// user's active membership.
+	SetBan(ctx context.Context, userID string, mode BanMode) error
+	GetBan(ctx context.Context, userID string) (BanMode, error)
+	ListBans(ctx context.Context) (map[string]BanMode, error)
+	// ReindexUsers rebuilds the user-to-members index from the global board, for entries
+	// recorded before the index existed. It returns the number of indexed entries.
+	ReindexUsers(ctx context.Context) (int, error)
+}
+
+// sortByScore orders entries like a leaderboard: highest score first.
+func sortByScore(entries []models.UserQuiz) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Score > entries[j].Score })
+}
@@ -0,0 +1,32 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+// ErrNotRanked means the user has no entry on the leaderboard asked about.
+var ErrNotRanked = errors.New("user has no entry on the leaderboard")
+
+// Leaver lets users give up the quiz they joined without submitting a score.
+type Leaver interface {
+	// LeaveQuiz drops the user's membership of quizID, returning ErrNotJoined when there is
+	// no such membership. Check and delete are atomic, so a membership of another quiz is
+	// never dropped.
+	LeaveQuiz(ctx context.Context, userID, quizID string) error
+}
+
+// RankedEntry is an entry together with its 1-based rank on a board.
+type RankedEntry struct {
+	Rank int64 `json:"rank"`
+	models.UserQuiz
+}
+
+// Ranker looks up where a user stands on a leaderboard without listing it.
+type Ranker interface {
+	// UserRank returns the user's best entry on the scope's board and its rank, or
+	// ErrNotRanked. Equal scores rank like ListScopeScores orders them.
+	UserRank(ctx context.Context, scope Scope, userID string) (RankedEntry, error)
+}
@@ -4,34 +4,200 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/pkg"
 )
 
 const (
-	sortedSetKey  = "emu-game:scores"
-	userQuizKeyNS = "emu-game:user"
-	expireTime    = 1 * time.Hour
+	joinCreated = iota
+	joinSameQuiz
+	joinOtherQuiz
+	joinBanned
 )
 
+const (
+	submitNotJoined = iota
+	submitRanked
+	submitBanned
+	submitShadowBanned
+)
+
+// joinScript sets the membership only when the user has none and is not banned.
+// KEYS[1] membership key, KEYS[2] bans; ARGV[1] quiz ID, ARGV[2] TTL in milliseconds,
+// ARGV[3] user ID.
+var joinScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[3]) == 'ban' then
+	return 3
+end
+local current = redis.call('GET', KEYS[1])
+if current then
+	if current == ARGV[1] then
+		return 1
+	end
+	return 2
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 0
+`)
+
+// bumpVersionSource increments the leaderboard version, seeding a missing counter.
+// KEYS[1] version; ARGV[1] seed.
+const bumpVersionSource = `
+if redis.call('SETNX', KEYS[1], ARGV[1]) == 1 then
+	return tonumber(ARGV[1])
+end
+return redis.call('INCR', KEYS[1])
+`
+
+// logRebuildSource queues entries for replay while a cache rebuild runs, so a rebuild cannot
+// swap its boards over them. The log expires with the rebuild if it never finishes.
+// KEYS[1] rebuild marker, KEYS[2] rebuild log; ARGV entry JSONs.
+const logRebuildSource = `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('RPUSH', KEYS[2], unpack(ARGV))
+redis.call('PEXPIRE', KEYS[2], redis.call('PTTL', KEYS[1]))
+return 1
+`
+
+// submitScript consumes the membership and ranks the score on every board, unless the user
+// is banned. A shadow-banned user's membership is consumed without ranking.
+// KEYS[1] membership key, KEYS[2] bans, KEYS[3] user index, KEYS[4] version, KEYS[5]
+// entries, KEYS[6..] boards; ARGV[1] quiz ID, ARGV[2] score, ARGV[3] member ID, ARGV[4]
+// user ID, ARGV[5] version seed, ARGV[6] entry JSON.
+var submitScript = redis.NewScript(`
+local ban = redis.call('HGET', KEYS[2], ARGV[4])
+if ban == 'ban' then
+	return 2
+end
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+if ban == 'shadow' then
+	return 3
+end
+redis.call('HSET', KEYS[5], ARGV[3], ARGV[6])
+redis.call('SADD', KEYS[3], ARGV[3])
+for i = 6, #KEYS do
+	redis.call('ZADD', KEYS[i], ARGV[2], ARGV[3])
+end
+if redis.call('SETNX', KEYS[4], ARGV[5]) == 0 then
+	redis.call('INCR', KEYS[4])
+end
+return 1
+`)
+
+// attemptsScript records and ranks a batch of offline attempts, skipping attempt IDs it has
+// seen before. The reply starts with 2 for a banned user (nothing else follows), 3 for a
+// shadow-banned user (IDs recorded, nothing ranked) or 1, then holds 1 per accepted and 0
+// per duplicate attempt.
+// KEYS[1] bans, KEYS[2] attempt IDs, KEYS[3] user index, KEYS[4] version, KEYS[5] entries,
+// KEYS[6] global board, KEYS[6+i] quiz board of attempt i; ARGV[1] user ID, ARGV[2] cutoff
+// below which attempt IDs are forgotten, ARGV[3] retention in milliseconds, ARGV[4] version
+// seed, then per attempt: ID, played at, score, member ID, entry JSON.
+var attemptsScript = redis.NewScript(`
+local ban = redis.call('HGET', KEYS[1], ARGV[1])
+if ban == 'ban' then
+	return {2}
+end
+local shadow = ban == 'shadow'
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. ARGV[2])
+local result = {shadow and 3 or 1}
+local ranked = false
+for i = 1, #KEYS - 6 do
+	local arg = 4 + (i - 1) * 5
+	if redis.call('ZADD', KEYS[2], 'NX', ARGV[arg + 2], ARGV[arg + 1]) == 1 then
+		result[i + 1] = 1
+		if not shadow then
+			redis.call('HSET', KEYS[5], ARGV[arg + 4], ARGV[arg + 5])
+			redis.call('SADD', KEYS[3], ARGV[arg + 4])
+			redis.call('ZADD', KEYS[6], ARGV[arg + 3], ARGV[arg + 4])
+			redis.call('ZADD', KEYS[6 + i], ARGV[arg + 3], ARGV[arg + 4])
+			ranked = true
+		end
+	else
+		result[i + 1] = 0
+	end
+end
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+if ranked and redis.call('SETNX', KEYS[4], ARGV[4]) == 0 then
+	redis.call('INCR', KEYS[4])
+end
+return result
+`)
+
+// migrateMemberScript moves a legacy JSON member to its compact ID on every board holding
+// it, keeping its score there, and records the entry. It returns 1 when the member was found.
+// KEYS[1] entries, KEYS[2] user index, KEYS[3..] boards; ARGV[1] legacy member, ARGV[2]
+// member ID, ARGV[3] entry JSON.
+var migrateMemberScript = redis.NewScript(`
+local moved = 0
+for i = 3, #KEYS do
+	local score = redis.call('ZSCORE', KEYS[i], ARGV[1])
+	if score then
+		redis.call('ZREM', KEYS[i], ARGV[1])
+		redis.call('ZADD', KEYS[i], score, ARGV[2])
+		moved = 1
+	end
+end
+if moved == 1 then
+	redis.call('HSET', KEYS[1], ARGV[2], ARGV[3])
+	redis.call('SREM', KEYS[2], ARGV[1])
+	redis.call('SADD', KEYS[2], ARGV[2])
+end
+return moved
+`)
+
+// claimScript consumes the membership and returns its remaining TTL in milliseconds, or -1
+// when the user has not joined the quiz.
+// KEYS[1] membership key; ARGV[1] quiz ID.
+var claimScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return -1
+end
+local ttl = redis.call('PTTL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return ttl
+`)
+
 type RedisRepository struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-func NewRedisRepository(redis *redis.Client) (*RedisRepository, error) {
+// NewRedisRepository accepts any universal client: standalone, Sentinel failover or Cluster.
+func NewRedisRepository(redis redis.UniversalClient) (*RedisRepository, error) {
 	return &RedisRepository{client: redis}, nil
 }
 
 func (s *RedisRepository) JoinQuiz(ctx context.Context, userID, quizID string) error {
 	// Store quiz membership with an expiration so abandoned sessions eventually clear.
-	return s.client.Set(ctx, userQuizKey(userID), quizID, expireTime).Err()
+	keys := s.keys(ctx)
+	ttl := membershipTTL(ctx).Milliseconds()
+	res, err := joinScript.Run(ctx, s.client, []string{keys.User(userID), keys.Bans()}, quizID, ttl, userID).Int()
+	if err != nil {
+		return err
+	}
+
+	switch res {
+	case joinSameQuiz:
+		return ErrAlreadyJoined
+	case joinOtherQuiz:
+		return ErrJoinedElsewhere
+	case joinBanned:
+		return ErrBanned
+	}
+	return nil
 }
 
 func (s *RedisRepository) GetQuizByUserID(ctx context.Context, userID string) (string, error) {
-	val, err := s.client.Get(ctx, userQuizKey(userID)).Result()
+	val, err := s.client.Get(ctx, s.keys(ctx).User(userID)).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return "", nil
@@ -48,56 +214,667 @@ func (s *RedisRepository) SubmitQuiz(ctx context.Context, userQuiz models.UserQu
 		return err
 	}
 
-	pipe := s.client.TxPipeline()
 	// Remove membership so the user must explicitly re-join before another submit.
-	pipe.Del(ctx, userQuizKey(userQuiz.UserID))
-	pipe.ZAdd(ctx, sortedSetKey, redis.Z{
-		Member: payload,
-		Score:  userQuiz.Score,
-	})
+	keys := s.keys(ctx)
+	scriptKeys := append([]string{keys.User(userQuiz.UserID), keys.Bans(), keys.UserIndex(userQuiz.UserID), keys.Version(), keys.Entries()}, keys.Boards(userQuiz)...)
+	res, err := submitScript.Run(ctx, s.client, scriptKeys, userQuiz.QuizID, userQuiz.Score, EntryID(userQuiz), userQuiz.UserID, versionSeed(time.Now()), payload).Int()
+	if err != nil {
+		return err
+	}
+
+	switch res {
+	case submitNotJoined:
+		return ErrNotJoined
+	case submitBanned:
+		return ErrBanned
+	case submitShadowBanned:
+		return ErrShadowBanned
+	}
+	return nil
+}
+
+func (s *RedisRepository) SubmitAttempts(ctx context.Context, userID string, attempts []Attempt) ([]AttemptStatus, error) {
+	if len(attempts) == 0 {
+		return []AttemptStatus{}, nil
+	}
+
+	keys := s.keys(ctx)
+	now := time.Now()
+	scriptKeys := []string{keys.Bans(), keys.Attempts(userID), keys.UserIndex(userID), keys.Version(), keys.Entries(), keys.Scores()}
+	args := []any{userID, now.Add(-AttemptRetention).UnixMilli(), AttemptRetention.Milliseconds(), versionSeed(now)}
+	for _, attempt := range attempts {
+		entry := attempt.entry(userID)
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		scriptKeys = append(scriptKeys, keys.Board(QuizScope(attempt.QuizID)))
+		args = append(args, attempt.ID, attempt.PlayedAt.UnixMilli(), attempt.Score, EntryID(entry), payload)
+	}
+
+	res, err := attemptsScript.Run(ctx, s.client, scriptKeys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if res[0] == submitBanned {
+		return nil, ErrBanned
+	}
 
-	_, err = pipe.Exec(ctx)
+	statuses := make([]AttemptStatus, len(attempts))
+	for i := range attempts {
+		statuses[i] = AttemptDuplicate
+		if res[i+1] == 1 {
+			statuses[i] = AttemptAccepted
+		}
+	}
+	if res[0] == submitShadowBanned {
+		return statuses, ErrShadowBanned
+	}
+	return statuses, nil
+}
+
+func (s *RedisRepository) UserAttempts(ctx context.Context, userID string) (map[string]time.Time, error) {
+	vals, err := s.client.ZRangeWithScores(ctx, s.keys(ctx).Attempts(userID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make(map[string]time.Time, len(vals))
+	for _, v := range vals {
+		if id, ok := v.Member.(string); ok {
+			attempts[id] = time.UnixMilli(int64(v.Score)).UTC()
+		}
+	}
+	return attempts, nil
+}
+
+func (s *RedisRepository) DeleteAttempts(ctx context.Context, userID string) error {
+	return s.client.Del(ctx, s.keys(ctx).Attempts(userID)).Err()
+}
+
+func (s *RedisRepository) LeaveQuiz(ctx context.Context, userID, quizID string) error {
+	_, err := s.claimMembership(ctx, userID, quizID)
 	return err
 }
 
+// claimMembership atomically consumes the user's membership of quizID and returns the TTL
+// it had left, so a failed submission can hand it back with restoreMembership.
+func (s *RedisRepository) claimMembership(ctx context.Context, userID, quizID string) (time.Duration, error) {
+	ttl, err := claimScript.Run(ctx, s.client, []string{s.keys(ctx).User(userID)}, quizID).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if ttl == -1 {
+		return 0, ErrNotJoined
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// restoreMembership hands back a claimed membership unless the user has joined again since.
+func (s *RedisRepository) restoreMembership(ctx context.Context, userID, quizID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.SetNX(ctx, s.keys(ctx).User(userID), quizID, ttl).Err()
+}
+
+// rankScores adds submissions to every board they belong to without touching membership.
+func (s *RedisRepository) rankScores(ctx context.Context, userQuizzes ...models.UserQuiz) error {
+	if len(userQuizzes) == 0 {
+		return nil
+	}
+
+	keys := s.keys(ctx)
+	payloads := make([]any, len(userQuizzes))
+	for i, userQuiz := range userQuizzes {
+		payload, err := json.Marshal(userQuiz)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		// Logged in the same transaction, so a rebuild either reads these from the durable
+		// store or replays them.
+		pipe.Eval(ctx, logRebuildSource, []string{keys.Rebuilding(), keys.RebuildLog()}, payloads...)
+		for i, userQuiz := range userQuizzes {
+			addEntry(ctx, pipe, keys, userQuiz, payloads[i].([]byte))
+		}
+		bumpVersion(ctx, pipe, keys)
+		return nil
+	})
+	return err
+}
+
+// bumpVersion queues a leaderboard version increment, for pipelines that change a board.
+func bumpVersion(ctx context.Context, pipe redis.Pipeliner, keys Keyspace) {
+	pipe.Eval(ctx, bumpVersionSource, []string{keys.Version()}, versionSeed(time.Now()))
+}
+
+// incrVersion bumps the leaderboard version outside a pipeline.
+func (s *RedisRepository) incrVersion(ctx context.Context) error {
+	return s.client.Eval(ctx, bumpVersionSource, []string{s.keys(ctx).Version()}, versionSeed(time.Now())).Err()
+}
+
+func (s *RedisRepository) LeaderboardVersion(ctx context.Context) (int64, error) {
+	version, err := s.client.Get(ctx, s.keys(ctx).Version()).Int64()
+	if errors.Is(err, redis.Nil) {
+		// Nothing has changed since the counter was lost; the next change seeds it.
+		return 0, nil
+	}
+	return version, err
+}
+
+// addEntry records an entry under its ID, ranks the ID on all its boards and indexes it
+// under its user.
+func addEntry(ctx context.Context, pipe redis.Pipeliner, keys Keyspace, userQuiz models.UserQuiz, payload []byte) {
+	id := EntryID(userQuiz)
+	pipe.HSet(ctx, keys.Entries(), id, payload)
+	pipe.SAdd(ctx, keys.UserIndex(userQuiz.UserID), id)
+	for _, key := range keys.Boards(userQuiz) {
+		pipe.ZAdd(ctx, key, redis.Z{Member: id, Score: userQuiz.Score})
+	}
+}
+
+// removeEntry is the inverse of addEntry, for a member resolved by loadEntries. Members
+// without an entry can only be removed from the board they were found on.
+func removeEntry(ctx context.Context, pipe redis.Pipeliner, keys Keyspace, board, member string, entry *models.UserQuiz) {
+	pipe.HDel(ctx, keys.Entries(), member)
+	if entry == nil {
+		pipe.ZRem(ctx, board, member)
+		return
+	}
+
+	pipe.SRem(ctx, keys.UserIndex(entry.UserID), member)
+	for _, key := range keys.Boards(*entry) {
+		pipe.ZRem(ctx, key, member)
+	}
+}
+
+// loadEntries resolves members to their entries in pipelined batches: compact IDs through
+// the entries hash, legacy members by decoding them. Members with neither resolve to nil.
+func loadEntries(ctx context.Context, c redis.Cmdable, keys Keyspace, members []string) ([]*models.UserQuiz, error) {
+	entries := make([]*models.UserQuiz, len(members))
+	if len(members) == 0 {
+		return entries, nil
+	}
+
+	pipe := c.Pipeline()
+	batches := make([]*redis.SliceCmd, 0, len(members)/rebuildBatchSize+1)
+	for start := 0; start < len(members); start += rebuildBatchSize {
+		end := min(start+rebuildBatchSize, len(members))
+		batches = append(batches, pipe.HMGet(ctx, keys.Entries(), members[start:end]...))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	for b, batch := range batches {
+		for i, raw := range batch.Val() {
+			member := members[b*rebuildBatchSize+i]
+			if payload, ok := raw.(string); ok {
+				var entry models.UserQuiz
+				if err := json.Unmarshal([]byte(payload), &entry); err == nil {
+					entries[b*rebuildBatchSize+i] = &entry
+				}
+				continue
+			}
+			if entry, ok := legacyEntry(member); ok {
+				entries[b*rebuildBatchSize+i] = &entry
+			}
+		}
+	}
+	return entries, nil
+}
+
 func (s *RedisRepository) ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error) {
+	return s.ListScopeScores(ctx, GlobalScope, from, limit)
+}
+
+func (s *RedisRepository) ListScopeScores(ctx context.Context, scope Scope, from, limit int64) ([]models.UserQuiz, error) {
 	if from < 0 {
 		from = 0
 	}
 
 	if limit <= 0 {
-		limit = 10
+		limit = leaderboardLimit(ctx)
+	}
+
+	keys := s.keys(ctx)
+	vals, err := s.client.ZRevRangeWithScores(ctx, keys.Board(scope), from, from+limit-1).Result()
+	if err != nil {
+		return nil, err
 	}
 
-	vals, err := s.client.ZRevRangeWithScores(ctx, sortedSetKey, from, from+limit-1).Result()
+	members := make([]string, len(vals))
+	for i, v := range vals {
+		members[i], _ = v.Member.(string)
+	}
+	resolved, err := loadEntries(ctx, s.client, keys, members)
 	if err != nil {
 		return nil, err
 	}
 
 	entries := make([]models.UserQuiz, 0, len(vals))
-	for _, v := range vals {
-		var quiz models.UserQuiz
+	for i, entry := range resolved {
+		if entry == nil {
+			continue
+		}
+		quiz := *entry
+		quiz.Score = vals[i].Score
+		entries = append(entries, quiz)
+	}
+
+	return entries, nil
+}
+
+// UserRank ranks the user's entries on the board through the user index, so the board is
+// never scanned. Entries recorded before the index existed are found once ReindexUsers ran.
+func (s *RedisRepository) UserRank(ctx context.Context, scope Scope, userID string) (RankedEntry, error) {
+	keys := s.keys(ctx)
+	members, err := s.client.SMembers(ctx, keys.UserIndex(userID)).Result()
+	if err != nil {
+		return RankedEntry{}, err
+	}
+	if len(members) == 0 {
+		return RankedEntry{}, ErrNotRanked
+	}
+
+	board := keys.Board(scope)
+	pipe := s.client.Pipeline()
+	ranks := make([]*redis.IntCmd, len(members))
+	scores := make([]*redis.FloatCmd, len(members))
+	for i, member := range members {
+		ranks[i] = pipe.ZRevRank(ctx, board, member)
+		scores[i] = pipe.ZScore(ctx, board, member)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return RankedEntry{}, err
+	}
+
+	// Members of other quizzes are not on a quiz board and answer nil.
+	best := -1
+	for i, rank := range ranks {
+		if err := rank.Err(); errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return RankedEntry{}, err
+		}
+		if best == -1 || rank.Val() < ranks[best].Val() {
+			best = i
+		}
+	}
+	if best == -1 {
+		return RankedEntry{}, ErrNotRanked
+	}
+
+	resolved, err := loadEntries(ctx, s.client, keys, members[best:best+1])
+	if err != nil {
+		return RankedEntry{}, err
+	}
+	if resolved[0] == nil {
+		return RankedEntry{}, ErrNotRanked
+	}
+	entry := *resolved[0]
+	entry.Score = scores[best].Val()
+	return RankedEntry{Rank: ranks[best].Val() + 1, UserQuiz: entry}, nil
+}
+
+func (s *RedisRepository) ImportScores(ctx context.Context, scope Scope, entries []models.UserQuiz, replace bool) error {
+	if err := validateImport(scope, entries); err != nil {
+		return err
+	}
+
+	keys := s.keys(ctx)
+	board := keys.Board(scope)
+
+	// WATCH the board so a replace never drops a submission that lands mid-import.
+	return s.client.Watch(ctx, func(tx *redis.Tx) error {
+		var existing []string
+		if replace {
+			var err error
+			if existing, err = tx.ZRange(ctx, board, 0, -1).Result(); err != nil {
+				return err
+			}
+		}
+		resolved, err := loadEntries(ctx, tx, keys, existing)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, member := range existing {
+				removeEntry(ctx, pipe, keys, board, member, resolved[i])
+			}
+
+			for _, entry := range entries {
+				payload, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				addEntry(ctx, pipe, keys, entry, payload)
+			}
+			bumpVersion(ctx, pipe, keys)
+			return nil
+		})
+		return err
+	}, board)
+}
+
+func (s *RedisRepository) ListMemberships(ctx context.Context) ([]Membership, error) {
+	keys, err := s.membershipKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		switch raw := v.Member.(type) {
-		case string:
-			if err := json.Unmarshal([]byte(raw), &quiz); err != nil {
+	prefix := strings.TrimSuffix(s.keys(ctx).User("*"), "*")
+	memberships := make([]Membership, 0, len(keys))
+	for _, key := range keys {
+		pipe := s.client.Pipeline()
+		get := pipe.Get(ctx, key)
+		ttl := pipe.PTTL(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			// The membership expired or was submitted between SCAN and GET.
+			if errors.Is(err, redis.Nil) {
 				continue
 			}
-		case []byte:
-			if err := json.Unmarshal(raw, &quiz); err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, Membership{
+			UserID: strings.TrimPrefix(key, prefix),
+			QuizID: get.Val(),
+			TTL:    ttl.Val(),
+		})
+	}
+
+	return memberships, nil
+}
+
+func (s *RedisRepository) ReplaceMemberships(ctx context.Context, memberships []Membership) error {
+	existing, err := s.membershipKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := s.keys(ctx)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range existing {
+			pipe.Del(ctx, key)
+		}
+		for _, m := range memberships {
+			if m.TTL <= 0 {
 				continue
 			}
-		default:
-			continue
+			pipe.Set(ctx, keys.User(m.UserID), m.QuizID, m.TTL)
 		}
+		return nil
+	})
+	return err
+}
 
-		quiz.Score = v.Score
-		entries = append(entries, quiz)
+// membershipKeys scans for the membership keys of the request tenant.
+func (s *RedisRepository) membershipKeys(ctx context.Context) ([]string, error) {
+	return s.scanKeys(ctx, s.keys(ctx).User("*"))
+}
+
+// scanKeys lists the keys matching pattern. On Cluster every master is scanned, although the
+// hash-tagged prefix keeps a tenant's keys on one of them.
+func (s *RedisRepository) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, pattern, rebuildBatchSize).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
 	}
 
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+		return keys, err
+	}
+	return keys, scan(ctx, s.client)
+}
+
+func (s *RedisRepository) UserScores(ctx context.Context, userID string) ([]models.UserQuiz, error) {
+	keys := s.keys(ctx)
+	members, err := s.client.SMembers(ctx, keys.UserIndex(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := loadEntries(ctx, s.client, keys, members)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.UserQuiz, 0, len(members))
+	for _, entry := range resolved {
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	sortByScore(entries)
 	return entries, nil
 }
 
-func userQuizKey(userID string) string {
-	return fmt.Sprintf("%s:%s", userQuizKeyNS, userID)
+func (s *RedisRepository) DeleteMembership(ctx context.Context, userID string) error {
+	return s.client.Del(ctx, s.keys(ctx).User(userID)).Err()
+}
+
+func (s *RedisRepository) DeleteUserScores(ctx context.Context, userID string) (int, error) {
+	keys := s.keys(ctx)
+	index := keys.UserIndex(userID)
+
+	var removed int
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		members, err := tx.SMembers(ctx, index).Result()
+		if err != nil {
+			return err
+		}
+		removed = len(members)
+		resolved, err := loadEntries(ctx, tx, keys, members)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, member := range members {
+				removeEntry(ctx, pipe, keys, keys.Scores(), member, resolved[i])
+			}
+			pipe.Del(ctx, index)
+			bumpVersion(ctx, pipe, keys)
+			return nil
+		})
+		return err
+	}, index)
+	return removed, err
+}
+
+func (s *RedisRepository) DeleteQuizScores(ctx context.Context, quizID string) (int, error) {
+	keys := s.keys(ctx)
+	board := keys.Board(QuizScope(quizID))
+
+	var removed int
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		members, err := tx.ZRange(ctx, board, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		removed = len(members)
+		resolved, err := loadEntries(ctx, tx, keys, members)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, member := range members {
+				removeEntry(ctx, pipe, keys, board, member, resolved[i])
+			}
+			pipe.Del(ctx, board)
+			bumpVersion(ctx, pipe, keys)
+			return nil
+		})
+		return err
+	}, board)
+	return removed, err
+}
+
+func (s *RedisRepository) SetBan(ctx context.Context, userID string, mode BanMode) error {
+	keys := s.keys(ctx)
+	if mode == BanNone {
+		return s.client.HDel(ctx, keys.Bans(), userID).Err()
+	}
+	if _, err := ParseBanMode(string(mode)); err != nil {
+		return err
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keys.Bans(), userID, string(mode))
+		pipe.Del(ctx, keys.User(userID))
+		return nil
+	})
+	return err
+}
+
+func (s *RedisRepository) GetBan(ctx context.Context, userID string) (BanMode, error) {
+	mode, err := s.client.HGet(ctx, s.keys(ctx).Bans(), userID).Result()
+	if errors.Is(err, redis.Nil) {
+		return BanNone, nil
+	}
+	return BanMode(mode), err
+}
+
+func (s *RedisRepository) ListBans(ctx context.Context) (map[string]BanMode, error) {
+	raw, err := s.client.HGetAll(ctx, s.keys(ctx).Bans()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make(map[string]BanMode, len(raw))
+	for userID, mode := range raw {
+		bans[userID] = BanMode(mode)
+	}
+	return bans, nil
+}
+
+func (s *RedisRepository) ReindexUsers(ctx context.Context) (int, error) {
+	keys := s.keys(ctx)
+
+	var (
+		indexed int
+		cursor  uint64
+	)
+	for {
+		vals, next, err := s.client.ZScan(ctx, keys.Scores(), cursor, "", rebuildBatchSize).Result()
+		if err != nil {
+			return indexed, err
+		}
+
+		// ZSCAN replies alternate member and score.
+		members := make([]string, 0, len(vals)/2)
+		for i := 0; i < len(vals); i += 2 {
+			members = append(members, vals[i])
+		}
+		resolved, err := loadEntries(ctx, s.client, keys, members)
+		if err != nil {
+			return indexed, err
+		}
+
+		pipe := s.client.Pipeline()
+		for i, entry := range resolved {
+			if entry == nil {
+				continue
+			}
+			pipe.SAdd(ctx, keys.UserIndex(entry.UserID), members[i])
+			indexed++
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return indexed, err
+		}
+
+		if next == 0 {
+			return indexed, nil
+		}
+		cursor = next
+	}
+}
+
+// MigrateMembers converts the legacy JSON members of the request tenant's global board, and
+// of the quiz boards holding the same entries, to compact IDs.
+func (s *RedisRepository) MigrateMembers(ctx context.Context, dryRun bool) (int, error) {
+	keys := s.keys(ctx)
+	if !dryRun {
+		// Load once so the pipelined EVALSHA below never meets NOSCRIPT.
+		if err := migrateMemberScript.Load(ctx, s.client).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		converted int
+		cursor    uint64
+	)
+	for {
+		vals, next, err := s.client.ZScan(ctx, keys.Scores(), cursor, "", rebuildBatchSize).Result()
+		if err != nil {
+			return converted, err
+		}
+
+		pipe := s.client.Pipeline()
+		var moved []*redis.Cmd
+		// ZSCAN replies alternate member and score.
+		for i := 0; i < len(vals); i += 2 {
+			entry, ok := legacyEntry(vals[i])
+			if !ok {
+				continue
+			}
+			if dryRun {
+				converted++
+				continue
+			}
+			payload, err := json.Marshal(entry)
+			if err != nil {
+				return converted, err
+			}
+			scriptKeys := append([]string{keys.Entries(), keys.UserIndex(entry.UserID)}, keys.Boards(entry)...)
+			moved = append(moved, migrateMemberScript.EvalSha(ctx, pipe, scriptKeys, vals[i], EntryID(entry), payload))
+		}
+		if len(moved) > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return converted, err
+			}
+			for _, cmd := range moved {
+				if n, _ := cmd.Int(); n == 1 {
+					converted++
+				}
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	// Ties between converted entries may now order differently.
+	if converted > 0 && !dryRun {
+		if err := s.incrVersion(ctx); err != nil {
+			return converted, err
+		}
+	}
+	return converted, nil
+}
+
+// keys scopes every key to the tenant resolved for the request.
+func (s *RedisRepository) keys(ctx context.Context) Keyspace {
+	return KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
 }
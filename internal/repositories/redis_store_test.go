@@ -59,6 +59,9 @@ func TestRedisRepositorySubmitAndListScores(t *testing.T) {
 	err = repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120})
 	require.NoError(t, err)
 
+	err = repo.JoinQuiz(ctx, "user-2", "quiz-2")
+	require.NoError(t, err)
+
 	err = repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 300})
 	require.NoError(t, err)
 
@@ -112,3 +115,102 @@ func TestRedisRepositoryLeaderboardPagination(t *testing.T) {
 	require.Equal(t, "user-05", list[4].UserID)
 	require.Equal(t, float64(105), list[4].Score)
 }
+
+func TestRedisRepositoryReindexUsersCoversLegacyEntries(t *testing.T) {
+	repo, mr := newTestRepo(t)
+	ctx := context.Background()
+
+	// Entries written before the user index existed live only on the boards.
+	legacy := `{"user_id":"cheater","quiz_id":"quiz-1","score":999}`
+	_, err := mr.ZAdd(scoresKey, 999, legacy)
+	require.NoError(t, err)
+	_, err = mr.ZAdd("emu-game:scores:quiz:quiz-1", 999, legacy)
+	require.NoError(t, err)
+
+	removed, err := repo.DeleteUserScores(ctx, "cheater")
+	require.NoError(t, err)
+	require.Zero(t, removed, "unindexed entries are invisible to moderation")
+
+	indexed, err := repo.ReindexUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, indexed)
+
+	removed, err = repo.DeleteUserScores(ctx, "cheater")
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.False(t, mr.Exists(scoresKey))
+	require.False(t, mr.Exists("emu-game:scores:quiz:quiz-1"))
+}
+
+func TestRedisRepositoryStoresCompactMembers(t *testing.T) {
+	repo, mr := newTestRepo(t)
+	ctx := context.Background()
+
+	entry := models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120}
+	require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+	require.NoError(t, repo.SubmitQuiz(ctx, entry))
+
+	members, err := mr.ZMembers(scoresKey)
+	require.NoError(t, err)
+	require.Equal(t, []string{EntryID(entry)}, members)
+	require.JSONEq(t, `{"user_id":"user-1","quiz_id":"quiz-1","score":120}`, mr.HGet("emu-game:scores:entries", EntryID(entry)))
+
+	removed, err := repo.DeleteUserScores(ctx, entry.UserID)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.False(t, mr.Exists("emu-game:scores:entries"))
+}
+
+func TestRedisRepositoryMigrateMembers(t *testing.T) {
+	repo, mr := newTestRepo(t)
+	ctx := context.Background()
+
+	legacy := `{"user_id":"user-1","quiz_id":"quiz-1","score":120}`
+	for _, key := range []string{scoresKey, "emu-game:scores:quiz:quiz-1"} {
+		_, err := mr.ZAdd(key, 120, legacy)
+		require.NoError(t, err)
+	}
+	_, err := mr.SetAdd("emu-game:index:user:user-1", legacy)
+	require.NoError(t, err)
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 80}))
+
+	// Legacy members stay readable until they are migrated.
+	want := []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 120},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 80},
+	}
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, want, scores)
+
+	pending, err := repo.MigrateMembers(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.True(t, mr.Exists(scoresKey))
+	isMember, err := mr.SIsMember("emu-game:index:user:user-1", legacy)
+	require.NoError(t, err)
+	require.True(t, isMember, "a dry run changes nothing")
+
+	converted, err := repo.MigrateMembers(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, converted)
+
+	id := EntryID(want[0])
+	for _, key := range []string{scoresKey, "emu-game:scores:quiz:quiz-1"} {
+		score, err := mr.ZScore(key, id)
+		require.NoError(t, err)
+		require.Equal(t, float64(120), score)
+	}
+	indexed, err := mr.SMembers("emu-game:index:user:user-1")
+	require.NoError(t, err)
+	require.Equal(t, []string{id}, indexed)
+
+	scores, err = repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, want, scores)
+
+	again, err := repo.MigrateMembers(ctx, false)
+	require.NoError(t, err)
+	require.Zero(t, again, "migration is idempotent")
+}
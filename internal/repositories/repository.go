@@ -2,13 +2,50 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// MembershipTTL bounds how long a joined quiz stays active without a submission.
+const MembershipTTL = 1 * time.Hour
+
+const defaultLeaderboardLimit = 10
+
+var (
+	// ErrAlreadyJoined means the user already holds a membership for the requested quiz.
+	ErrAlreadyJoined = errors.New("user already joined quiz")
+	// ErrJoinedElsewhere means the user holds a membership for a different quiz.
+	ErrJoinedElsewhere = errors.New("user already joined another quiz")
+	// ErrNotJoined means the user holds no membership for the quiz being submitted.
+	ErrNotJoined = errors.New("user did not join quiz")
 )
 
 type Repository interface {
+	// JoinQuiz records the membership only if the user holds none, returning
+	// ErrAlreadyJoined or ErrJoinedElsewhere otherwise. Check and write are atomic.
 	JoinQuiz(ctx context.Context, userID string, quizID string) error
 	GetQuizByUserID(ctx context.Context, userID string) (string, error)
+	// SubmitQuiz consumes the user's membership of userQuiz.QuizID and ranks the score in
+	// one atomic step, returning ErrNotJoined when there is no such membership.
 	SubmitQuiz(ctx context.Context, userQuiz models.UserQuiz) error
 	ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error)
 }
+
+// membershipTTL applies the request tenant's override, if any.
+func membershipTTL(ctx context.Context) time.Duration {
+	if ttl := pkg.GetTenant(ctx).MembershipTTL; ttl > 0 {
+		return ttl
+	}
+	return MembershipTTL
+}
+
+// leaderboardLimit applies the request tenant's default page size, if any.
+func leaderboardLimit(ctx context.Context) int64 {
+	if limit := pkg.GetTenant(ctx).LeaderboardLimit; limit > 0 {
+		return limit
+	}
+	return defaultLeaderboardLimit
+}
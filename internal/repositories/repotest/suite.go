@@ -0,0 +1,959 @@
+// Package repotest holds the conformance suite every repositories.Repository
+// implementation must pass, so backends cannot drift from the Redis semantics the
+// handlers rely on.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// Harness is a freshly initialised, empty repository under test.
+type Harness struct {
+	Repo repositories.Repository
+	// Advance moves the repository clock forward so membership expiry can be observed.
+	Advance func(d time.Duration)
+}
+
+// Factory returns a new Harness for each subtest; cleanup should be registered on t.
+type Factory func(t *testing.T) Harness
+
+// Run executes the full conformance suite against the repository built by newHarness.
+func Run(t *testing.T, newHarness Factory) {
+	t.Run("JoinAndGet", func(t *testing.T) { testJoinAndGet(t, newHarness(t)) })
+	t.Run("RejoinIsRejected", func(t *testing.T) { testRejoin(t, newHarness(t)) })
+	t.Run("SubmitRequiresMembership", func(t *testing.T) { testSubmitRequiresMembership(t, newHarness(t)) })
+	t.Run("ConcurrentJoins", func(t *testing.T) { testConcurrentJoins(t, newHarness(t)) })
+	t.Run("ConcurrentSubmitsOfOneMembership", func(t *testing.T) { testConcurrentSubmitsOfOneMembership(t, newHarness(t)) })
+	t.Run("SubmitClearsMembership", func(t *testing.T) { testSubmitClearsMembership(t, newHarness(t)) })
+	t.Run("Ordering", func(t *testing.T) { testOrdering(t, newHarness(t)) })
+	t.Run("TieOrdering", func(t *testing.T) { testTieOrdering(t, newHarness(t)) })
+	t.Run("TieOrderingOfPrefixMembers", func(t *testing.T) { testTieOrderingOfPrefixMembers(t, newHarness(t)) })
+	t.Run("IdenticalSubmissionsCollapse", func(t *testing.T) { testIdenticalSubmissions(t, newHarness(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newHarness(t)) })
+	t.Run("MembershipExpiry", func(t *testing.T) { testMembershipExpiry(t, newHarness(t)) })
+	t.Run("ConcurrentSubmits", func(t *testing.T) { testConcurrentSubmits(t, newHarness(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, newHarness(t)) })
+	t.Run("TenantOverrides", func(t *testing.T) { testTenantOverrides(t, newHarness(t)) })
+
+	t.Run("ScopeBoards", func(t *testing.T) { testScopeBoards(t, scopeStore(t, newHarness(t))) })
+	t.Run("ImportMerge", func(t *testing.T) { testImportMerge(t, scopeStore(t, newHarness(t))) })
+	t.Run("ImportReplace", func(t *testing.T) { testImportReplace(t, scopeStore(t, newHarness(t))) })
+	t.Run("ImportRejectsOutOfScope", func(t *testing.T) { testImportOutOfScope(t, scopeStore(t, newHarness(t))) })
+
+	t.Run("ListMemberships", func(t *testing.T) { testListMemberships(t, membershipStore(t, newHarness(t))) })
+	t.Run("ReplaceMemberships", func(t *testing.T) { testReplaceMemberships(t, membershipStore(t, newHarness(t))) })
+
+	t.Run("UserScores", func(t *testing.T) { testUserScores(t, moderator(t, newHarness(t))) })
+	t.Run("DeleteMembership", func(t *testing.T) { testDeleteMembership(t, moderator(t, newHarness(t))) })
+	t.Run("DeleteUserScores", func(t *testing.T) { testDeleteUserScores(t, moderator(t, newHarness(t))) })
+	t.Run("DeleteQuizScores", func(t *testing.T) { testDeleteQuizScores(t, moderator(t, newHarness(t))) })
+	t.Run("Ban", func(t *testing.T) { testBan(t, moderator(t, newHarness(t))) })
+	t.Run("ShadowBan", func(t *testing.T) { testShadowBan(t, moderator(t, newHarness(t))) })
+
+	t.Run("SubmitAttempts", func(t *testing.T) { testSubmitAttempts(t, batchSubmitter(t, newHarness(t))) })
+	t.Run("SubmitAttemptsBanned", func(t *testing.T) { testSubmitAttemptsBanned(t, batchSubmitter(t, newHarness(t))) })
+
+	t.Run("LeaderboardVersion", func(t *testing.T) { testLeaderboardVersion(t, versioned(t, newHarness(t))) })
+
+	t.Run("LeaveQuiz", func(t *testing.T) { testLeaveQuiz(t, leaver(t, newHarness(t))) })
+	t.Run("UserRank", func(t *testing.T) { testUserRank(t, ranker(t, newHarness(t))) })
+}
+
+// scopedHarness is a Harness whose repository also implements repositories.ScopeStore.
+type scopedHarness struct {
+	Harness
+	Store repositories.ScopeStore
+}
+
+func scopeStore(t *testing.T, h Harness) scopedHarness {
+	store, ok := h.Repo.(repositories.ScopeStore)
+	if !ok {
+		t.Skip("repository does not implement repositories.ScopeStore")
+	}
+	return scopedHarness{Harness: h, Store: store}
+}
+
+// membershipHarness is a Harness whose repository also implements
+// repositories.MembershipStore.
+type membershipHarness struct {
+	Harness
+	Store repositories.MembershipStore
+}
+
+func membershipStore(t *testing.T, h Harness) membershipHarness {
+	store, ok := h.Repo.(repositories.MembershipStore)
+	if !ok {
+		t.Skip("repository does not implement repositories.MembershipStore")
+	}
+	return membershipHarness{Harness: h, Store: store}
+}
+
+// moderatedHarness is a Harness whose repository also implements repositories.Moderator.
+type moderatedHarness struct {
+	Harness
+	Moderator repositories.Moderator
+}
+
+func moderator(t *testing.T, h Harness) moderatedHarness {
+	m, ok := h.Repo.(repositories.Moderator)
+	if !ok {
+		t.Skip("repository does not implement repositories.Moderator")
+	}
+	return moderatedHarness{Harness: h, Moderator: m}
+}
+
+// versionedHarness is a Harness whose repository also implements repositories.Versioned.
+type versionedHarness struct {
+	Harness
+	Versioned repositories.Versioned
+}
+
+func versioned(t *testing.T, h Harness) versionedHarness {
+	v, ok := h.Repo.(repositories.Versioned)
+	if !ok {
+		t.Skip("repository does not implement repositories.Versioned")
+	}
+	return versionedHarness{Harness: h, Versioned: v}
+}
+
+// batchHarness is a Harness whose repository also implements repositories.BatchSubmitter.
+type batchHarness struct {
+	Harness
+	Batch repositories.BatchSubmitter
+}
+
+func batchSubmitter(t *testing.T, h Harness) batchHarness {
+	b, ok := h.Repo.(repositories.BatchSubmitter)
+	if !ok {
+		t.Skip("repository does not implement repositories.BatchSubmitter")
+	}
+	return batchHarness{Harness: h, Batch: b}
+}
+
+// leaverHarness is a Harness whose repository also implements repositories.Leaver.
+type leaverHarness struct {
+	Harness
+	Leaver repositories.Leaver
+}
+
+func leaver(t *testing.T, h Harness) leaverHarness {
+	l, ok := h.Repo.(repositories.Leaver)
+	if !ok {
+		t.Skip("repository does not implement repositories.Leaver")
+	}
+	return leaverHarness{Harness: h, Leaver: l}
+}
+
+// rankerHarness is a Harness whose repository also implements repositories.Ranker.
+type rankerHarness struct {
+	Harness
+	Ranker repositories.Ranker
+}
+
+func ranker(t *testing.T, h Harness) rankerHarness {
+	r, ok := h.Repo.(repositories.Ranker)
+	if !ok {
+		t.Skip("repository does not implement repositories.Ranker")
+	}
+	return rankerHarness{Harness: h, Ranker: r}
+}
+
+func testJoinAndGet(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "unknown users have no membership")
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID)
+
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-2")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "membership is per user")
+}
+
+func testRejoin(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.ErrorIs(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"), repositories.ErrAlreadyJoined)
+	require.ErrorIs(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-2"), repositories.ErrJoinedElsewhere)
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID, "a rejected join must keep the existing membership")
+}
+
+func testSubmitRequiresMembership(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}), repositories.ErrNotJoined)
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-2", Score: 10}), repositories.ErrNotJoined)
+
+	h.Advance(repositories.MembershipTTL + time.Minute)
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}), repositories.ErrNotJoined,
+		"an expired membership cannot be submitted")
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, scores, "rejected submissions must not be ranked")
+}
+
+func testConcurrentJoins(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	const joiners = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, joiners)
+	for i := 0; i < joiners; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- h.Repo.JoinQuiz(ctx, "user-1", fmt.Sprintf("quiz-%d", i%2))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	var joined int
+	for err := range errs {
+		if err == nil {
+			joined++
+			continue
+		}
+		require.True(t, errors.Is(err, repositories.ErrAlreadyJoined) || errors.Is(err, repositories.ErrJoinedElsewhere), err)
+	}
+	require.Equal(t, 1, joined, "exactly one concurrent join may succeed")
+}
+
+func testConcurrentSubmitsOfOneMembership(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+
+	const submitters = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, submitters)
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: float64(i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	var submitted int
+	for err := range errs {
+		if err == nil {
+			submitted++
+			continue
+		}
+		require.ErrorIs(t, err, repositories.ErrNotJoined)
+	}
+	require.Equal(t, 1, submitted, "a membership may be submitted once")
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+}
+
+func testSubmitClearsMembership(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-2", "quiz-1"))
+	require.NoError(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}))
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "submitting must clear the submitter's membership")
+
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-2")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID, "submitting must not touch other users")
+}
+
+func testOrdering(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	submit(t, h, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 120})
+	submit(t, h, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 300})
+	submit(t, h, models.UserQuiz{UserID: "user-3", QuizID: "quiz-2", Score: -5})
+	submit(t, h, models.UserQuiz{UserID: "user-4", QuizID: "quiz-2", Score: 0})
+	submit(t, h, models.UserQuiz{UserID: "user-5", QuizID: "quiz-3", Score: 120.5})
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-2", QuizID: "quiz-1", Score: 300},
+		{UserID: "user-5", QuizID: "quiz-3", Score: 120.5},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 120},
+		{UserID: "user-4", QuizID: "quiz-2", Score: 0},
+		{UserID: "user-3", QuizID: "quiz-2", Score: -5},
+	}, scores)
+}
+
+func testTieOrdering(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	// Equal scores are ordered by descending member, matching ZREVRANGE.
+	submit(t, h, models.UserQuiz{UserID: "user-a", QuizID: "quiz-1", Score: 50})
+	submit(t, h, models.UserQuiz{UserID: "user-c", QuizID: "quiz-1", Score: 50})
+	submit(t, h, models.UserQuiz{UserID: "user-b", QuizID: "quiz-1", Score: 50})
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-c", "user-b", "user-a"}, userIDs(scores))
+}
+
+func testTieOrderingOfPrefixMembers(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	// The second user's member ID extends the first's, so ZREVRANGE ranks it first.
+	short := models.UserQuiz{UserID: "user-a", QuizID: "quiz-1", Score: 50}
+	long := models.UserQuiz{UserID: repositories.EntryID(short), QuizID: "quiz-1", Score: 50}
+	submit(t, h, short)
+	submit(t, h, long)
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{long, short}, scores)
+}
+
+func testIdenticalSubmissions(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	entry := models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 42}
+	submit(t, h, entry)
+	submit(t, h, entry)
+	submit(t, h, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 43})
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 43},
+		entry,
+	}, scores)
+}
+
+func testPagination(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	for i := 0; i < 15; i++ {
+		submit(t, h, models.UserQuiz{
+			UserID: fmt.Sprintf("user-%02d", i),
+			QuizID: fmt.Sprintf("quiz-%02d", i),
+			Score:  float64(100 + i),
+		})
+	}
+
+	cases := []struct {
+		name  string
+		from  int64
+		limit int64
+		want  []string
+	}{
+		{name: "first page", from: 0, limit: 5, want: []string{"user-14", "user-13", "user-12", "user-11", "user-10"}},
+		{name: "middle page", from: 5, limit: 5, want: []string{"user-09", "user-08", "user-07", "user-06", "user-05"}},
+		{name: "partial last page", from: 12, limit: 5, want: []string{"user-02", "user-01", "user-00"}},
+		{name: "past the end", from: 15, limit: 5, want: []string{}},
+		{name: "negative from starts at top", from: -3, limit: 2, want: []string{"user-14", "user-13"}},
+		{name: "zero limit defaults to ten", from: 0, limit: 0, want: idsFrom(14, 10)},
+		{name: "negative limit defaults to ten", from: 10, limit: -1, want: idsFrom(4, 5)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			scores, err := h.Repo.ListUserScores(ctx, tc.from, tc.limit)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, userIDs(scores))
+		})
+	}
+}
+
+func testMembershipExpiry(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+
+	h.Advance(repositories.MembershipTTL - time.Minute)
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID, "membership must survive until its TTL")
+
+	h.Advance(2 * time.Minute)
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "membership must expire after its TTL")
+
+	// A fresh join restarts the TTL.
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-2"))
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-2", quizID)
+}
+
+func testConcurrentSubmits(t *testing.T, h Harness) {
+	ctx := context.Background()
+
+	const users = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, users*2)
+	for i := 0; i < users; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+
+			userID := fmt.Sprintf("user-%02d", i)
+			if err := h.Repo.JoinQuiz(ctx, userID, "quiz-1"); err != nil {
+				errs <- err
+				return
+			}
+			if err := h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: userID, QuizID: "quiz-1", Score: float64(i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+
+			// Readers running alongside writers must never fail.
+			if _, err := h.Repo.ListUserScores(ctx, 0, 10); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, users)
+	require.NoError(t, err)
+	require.Len(t, scores, users)
+	require.Equal(t, idsFrom(users-1, users), userIDs(scores))
+
+	for i := 0; i < users; i++ {
+		quizID, err := h.Repo.GetQuizByUserID(ctx, fmt.Sprintf("user-%02d", i))
+		require.NoError(t, err)
+		require.Empty(t, quizID)
+	}
+}
+
+func testTenantIsolation(t *testing.T, h Harness) {
+	acme := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "acme"})
+	globex := pkg.WithTenant(context.Background(), pkg.Tenant{ID: "globex"})
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(acme, "user-1", "quiz-1"))
+	require.NoError(t, h.Repo.JoinQuiz(acme, "user-2", "quiz-1"))
+	require.NoError(t, h.Repo.SubmitQuiz(acme, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 10}))
+
+	for _, other := range []context.Context{globex, ctx} {
+		quizID, err := h.Repo.GetQuizByUserID(other, "user-1")
+		require.NoError(t, err)
+		require.Empty(t, quizID, "membership must not leak across tenants")
+
+		scores, err := h.Repo.ListUserScores(other, 0, 10)
+		require.NoError(t, err)
+		require.Empty(t, scores, "leaderboards must not leak across tenants")
+	}
+
+	require.NoError(t, h.Repo.JoinQuiz(globex, "user-1", "quiz-9"))
+	require.NoError(t, h.Repo.SubmitQuiz(globex, models.UserQuiz{UserID: "user-1", QuizID: "quiz-9", Score: 99}))
+
+	quizID, err := h.Repo.GetQuizByUserID(acme, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID, "a submit in one tenant must not clear another tenant's membership")
+
+	scores, err := h.Repo.ListUserScores(acme, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-2", QuizID: "quiz-1", Score: 10}}, scores)
+}
+
+func testTenantOverrides(t *testing.T, h Harness) {
+	ctx := pkg.WithTenant(context.Background(), pkg.Tenant{
+		ID:               "acme",
+		MembershipTTL:    10 * time.Minute,
+		LeaderboardLimit: 3,
+	})
+
+	for i := 0; i < 5; i++ {
+		userID := fmt.Sprintf("user-%02d", i)
+		require.NoError(t, h.Repo.JoinQuiz(ctx, userID, "quiz-1"))
+		require.NoError(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: userID, QuizID: "quiz-1", Score: float64(i)}))
+	}
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, idsFrom(4, 3), userIDs(scores), "the tenant's default page size applies")
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-2"))
+	h.Advance(11 * time.Minute)
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "the tenant's membership TTL applies")
+}
+
+func testScopeBoards(t *testing.T, h scopedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 30})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-3", QuizID: "quiz-1", Score: 20})
+
+	global, err := h.Store.ListScopeScores(ctx, repositories.GlobalScope, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2", "user-3", "user-1"}, userIDs(global))
+
+	quiz, err := h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-1"), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-3", "user-1"}, userIDs(quiz))
+
+	quiz, err = h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-1"), 1, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-1"}, userIDs(quiz))
+
+	empty, err := h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-missing"), 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, empty)
+}
+
+func testImportMerge(t *testing.T, h scopedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	require.NoError(t, h.Store.ImportScores(ctx, repositories.QuizScope("quiz-1"), []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 50},
+	}, false))
+
+	quiz, err := h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-1"), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2", "user-1"}, userIDs(quiz), "identical entries merge")
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2", "user-1"}, userIDs(global), "imported entries rank on every board")
+}
+
+func testImportReplace(t *testing.T, h scopedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 20})
+	require.NoError(t, h.Store.ImportScores(ctx, repositories.QuizScope("quiz-1"), []models.UserQuiz{
+		{UserID: "user-3", QuizID: "quiz-1", Score: 5},
+	}, true))
+
+	quiz, err := h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-1"), 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-3"}, userIDs(quiz))
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-2", "user-3"}, userIDs(global), "replaced entries leave every board")
+
+	require.NoError(t, h.Store.ImportScores(ctx, repositories.GlobalScope, nil, true))
+	global, err = h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, global)
+
+	quiz, err = h.Store.ListScopeScores(ctx, repositories.QuizScope("quiz-2"), 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, quiz, "replacing the global scope clears quiz boards too")
+}
+
+func testImportOutOfScope(t *testing.T, h scopedHarness) {
+	ctx := context.Background()
+
+	err := h.Store.ImportScores(ctx, repositories.QuizScope("quiz-1"), []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-2", Score: 5},
+	}, false)
+	require.Error(t, err)
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, global)
+}
+
+func testListMemberships(t *testing.T, h membershipHarness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+	require.NoError(t, h.Repo.JoinQuiz(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}), "user-3", "quiz-1"))
+	require.NoError(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 1}))
+
+	h.Advance(10 * time.Minute)
+	memberships, err := h.Store.ListMemberships(ctx)
+	require.NoError(t, err)
+	require.Len(t, memberships, 1, "submitted and other tenants' memberships are not listed")
+	require.Equal(t, "user-1", memberships[0].UserID)
+	require.Equal(t, "quiz-1", memberships[0].QuizID)
+	require.InDelta(t, repositories.MembershipTTL-10*time.Minute, memberships[0].TTL, float64(time.Second))
+
+	h.Advance(repositories.MembershipTTL)
+	memberships, err = h.Store.ListMemberships(ctx)
+	require.NoError(t, err)
+	require.Empty(t, memberships, "expired memberships are not listed")
+}
+
+func testReplaceMemberships(t *testing.T, h membershipHarness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, h.Store.ReplaceMemberships(ctx, []repositories.Membership{
+		{UserID: "user-2", QuizID: "quiz-2", TTL: time.Minute},
+		{UserID: "user-3", QuizID: "quiz-3", TTL: 0},
+	}))
+
+	for userID, want := range map[string]string{"user-1": "", "user-2": "quiz-2", "user-3": ""} {
+		quizID, err := h.Repo.GetQuizByUserID(ctx, userID)
+		require.NoError(t, err)
+		require.Equal(t, want, quizID, userID)
+	}
+
+	h.Advance(2 * time.Minute)
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-2")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "restored memberships keep their TTL")
+}
+
+func testUserScores(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-2", Score: 30})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 20})
+
+	scores, err := h.Moderator.UserScores(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-2", Score: 30},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+	}, scores)
+
+	scores, err = h.Moderator.UserScores(ctx, "unknown")
+	require.NoError(t, err)
+	require.Empty(t, scores)
+}
+
+func testDeleteMembership(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, h.Moderator.DeleteMembership(ctx, "user-1"))
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID)
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 1}), repositories.ErrNotJoined)
+
+	require.NoError(t, h.Moderator.DeleteMembership(ctx, "unknown"), "deleting a missing membership is a no-op")
+}
+
+func testDeleteUserScores(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "cheater", QuizID: "quiz-1", Score: 999})
+	submit(t, h.Harness, models.UserQuiz{UserID: "cheater", QuizID: "quiz-2", Score: 998})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+
+	removed, err := h.Moderator.DeleteUserScores(ctx, "cheater")
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-1"}, userIDs(global))
+
+	if store, ok := h.Repo.(repositories.ScopeStore); ok {
+		quiz, err := store.ListScopeScores(ctx, repositories.QuizScope("quiz-2"), 0, 10)
+		require.NoError(t, err)
+		require.Empty(t, quiz, "removals apply to quiz boards too")
+	}
+
+	removed, err = h.Moderator.DeleteUserScores(ctx, "cheater")
+	require.NoError(t, err)
+	require.Zero(t, removed)
+}
+
+func testDeleteQuizScores(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 20})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-2", Score: 30})
+
+	removed, err := h.Moderator.DeleteQuizScores(ctx, "quiz-1")
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-2", Score: 30}}, global)
+
+	// The user index must no longer reference the removed entries.
+	removed, err = h.Moderator.DeleteUserScores(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+}
+
+func testBan(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "cheater", "quiz-1"))
+	require.NoError(t, h.Moderator.SetBan(ctx, "cheater", repositories.BanBlocked))
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "cheater")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "banning drops the active membership")
+
+	require.ErrorIs(t, h.Repo.JoinQuiz(ctx, "cheater", "quiz-1"), repositories.ErrBanned)
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "cheater", QuizID: "quiz-1", Score: 1}), repositories.ErrBanned)
+
+	mode, err := h.Moderator.GetBan(ctx, "cheater")
+	require.NoError(t, err)
+	require.Equal(t, repositories.BanBlocked, mode)
+
+	bans, err := h.Moderator.ListBans(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]repositories.BanMode{"cheater": repositories.BanBlocked}, bans)
+
+	other, err := h.Moderator.ListBans(pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"}))
+	require.NoError(t, err)
+	require.Empty(t, other, "bans are per tenant")
+
+	require.ErrorIs(t, h.Moderator.SetBan(ctx, "cheater", "forever"), repositories.ErrInvalidBanMode)
+
+	require.NoError(t, h.Moderator.SetBan(ctx, "cheater", repositories.BanNone))
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "cheater", "quiz-1"), "lifting the ban allows joins again")
+}
+
+func testShadowBan(t *testing.T, h moderatedHarness) {
+	ctx := context.Background()
+
+	require.NoError(t, h.Moderator.SetBan(ctx, "cheater", repositories.BanShadow))
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "cheater", "quiz-1"), "shadow-banned users can still join")
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "cheater", QuizID: "quiz-1", Score: 999}), repositories.ErrShadowBanned)
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "cheater")
+	require.NoError(t, err)
+	require.Empty(t, quizID, "a shadow-banned submit consumes the membership")
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, scores, "shadow-banned submissions are never ranked")
+}
+
+func submit(t *testing.T, h Harness, entry models.UserQuiz) {
+	t.Helper()
+
+	ctx := context.Background()
+	require.NoError(t, h.Repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+	require.NoError(t, h.Repo.SubmitQuiz(ctx, entry))
+}
+
+func userIDs(scores []models.UserQuiz) []string {
+	ids := make([]string, 0, len(scores))
+	for _, s := range scores {
+		ids = append(ids, s.UserID)
+	}
+	return ids
+}
+
+// idsFrom lists n zero-padded user IDs counting down from top.
+func idsFrom(top, n int) []string {
+	ids := make([]string, 0, n)
+	for i := top; i > top-n; i-- {
+		ids = append(ids, fmt.Sprintf("user-%02d", i))
+	}
+	return ids
+}
+
+func testLeaderboardVersion(t *testing.T, h versionedHarness) {
+	ctx := context.Background()
+	acme := pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"})
+
+	version := func(ctx context.Context) int64 {
+		t.Helper()
+		v, err := h.Versioned.LeaderboardVersion(ctx)
+		require.NoError(t, err)
+		return v
+	}
+
+	initial := version(ctx)
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.Equal(t, initial, version(ctx), "joining changes no board")
+
+	require.NoError(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}))
+	submitted := version(ctx)
+	require.Greater(t, submitted, initial)
+
+	acmeInitial := version(acme)
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-1", Score: 20})
+	require.Greater(t, version(ctx), submitted)
+	require.Equal(t, acmeInitial, version(acme), "versions are per tenant")
+
+	if m, ok := h.Repo.(repositories.Moderator); ok {
+		before := version(ctx)
+		_, err := m.DeleteUserScores(ctx, "user-2")
+		require.NoError(t, err)
+		require.Greater(t, version(ctx), before, "removals change the board")
+	}
+}
+
+func testSubmitAttempts(t *testing.T, h batchHarness) {
+	ctx := context.Background()
+	playedAt := time.Now().Add(-time.Hour)
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-9"))
+
+	statuses, err := h.Batch.SubmitAttempts(ctx, "user-1", []repositories.Attempt{
+		{ID: "a1", QuizID: "quiz-1", Score: 10, PlayedAt: playedAt},
+		{ID: "a2", QuizID: "quiz-2", Score: 30, PlayedAt: playedAt},
+		{ID: "a1", QuizID: "quiz-1", Score: 99, PlayedAt: playedAt},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []repositories.AttemptStatus{
+		repositories.AttemptAccepted,
+		repositories.AttemptAccepted,
+		repositories.AttemptDuplicate,
+	}, statuses, "an ID repeated within a batch is a duplicate")
+
+	global, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-2", Score: 30},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+	}, global)
+
+	if store, ok := h.Repo.(repositories.ScopeStore); ok {
+		quiz, err := store.ListScopeScores(ctx, repositories.QuizScope("quiz-2"), 0, 10)
+		require.NoError(t, err)
+		require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-2", Score: 30}}, quiz)
+	}
+
+	statuses, err = h.Batch.SubmitAttempts(ctx, "user-1", []repositories.Attempt{
+		{ID: "a2", QuizID: "quiz-2", Score: 30, PlayedAt: playedAt},
+		{ID: "a3", QuizID: "quiz-1", Score: 20, PlayedAt: playedAt},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []repositories.AttemptStatus{repositories.AttemptDuplicate, repositories.AttemptAccepted}, statuses,
+		"IDs are remembered across batches")
+
+	statuses, err = h.Batch.SubmitAttempts(ctx, "user-2", []repositories.Attempt{{ID: "a1", QuizID: "quiz-1", Score: 5, PlayedAt: playedAt}})
+	require.NoError(t, err)
+	require.Equal(t, []repositories.AttemptStatus{repositories.AttemptAccepted}, statuses, "IDs are per user")
+
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-9", quizID, "batches leave the membership alone")
+
+	seen, err := h.Batch.UserAttempts(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, seen, 3)
+	require.WithinDuration(t, playedAt, seen["a1"], time.Millisecond)
+
+	require.NoError(t, h.Batch.DeleteAttempts(ctx, "user-1"))
+	seen, err = h.Batch.UserAttempts(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, seen)
+	require.NoError(t, h.Batch.DeleteAttempts(ctx, "unknown"), "deleting unknown attempts is a no-op")
+}
+
+func testSubmitAttemptsBanned(t *testing.T, h batchHarness) {
+	ctx := context.Background()
+	m, ok := h.Repo.(repositories.Moderator)
+	if !ok {
+		t.Skip("repository does not implement repositories.Moderator")
+	}
+	attempts := []repositories.Attempt{{ID: "a1", QuizID: "quiz-1", Score: 10, PlayedAt: time.Now()}}
+
+	require.NoError(t, m.SetBan(ctx, "cheater", repositories.BanBlocked))
+	_, err := h.Batch.SubmitAttempts(ctx, "cheater", attempts)
+	require.ErrorIs(t, err, repositories.ErrBanned)
+
+	require.NoError(t, m.SetBan(ctx, "cheater", repositories.BanShadow))
+	statuses, err := h.Batch.SubmitAttempts(ctx, "cheater", attempts)
+	require.ErrorIs(t, err, repositories.ErrShadowBanned)
+	require.Equal(t, []repositories.AttemptStatus{repositories.AttemptAccepted}, statuses)
+
+	statuses, err = h.Batch.SubmitAttempts(ctx, "cheater", attempts)
+	require.ErrorIs(t, err, repositories.ErrShadowBanned)
+	require.Equal(t, []repositories.AttemptStatus{repositories.AttemptDuplicate}, statuses)
+
+	scores, err := h.Repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, scores, "banned attempts are never ranked")
+}
+
+func testLeaveQuiz(t *testing.T, h leaverHarness) {
+	ctx := context.Background()
+
+	require.ErrorIs(t, h.Leaver.LeaveQuiz(ctx, "user-1", "quiz-1"), repositories.ErrNotJoined)
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.ErrorIs(t, h.Leaver.LeaveQuiz(ctx, "user-1", "quiz-2"), repositories.ErrNotJoined)
+	quizID, err := h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-1", quizID, "leaving another quiz keeps the membership")
+
+	require.NoError(t, h.Leaver.LeaveQuiz(ctx, "user-1", "quiz-1"))
+	quizID, err = h.Repo.GetQuizByUserID(ctx, "user-1")
+	require.NoError(t, err)
+	require.Empty(t, quizID)
+	require.ErrorIs(t, h.Repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 1}), repositories.ErrNotJoined)
+
+	require.NoError(t, h.Repo.JoinQuiz(ctx, "user-1", "quiz-2"), "a user who left may join any quiz")
+}
+
+func testUserRank(t *testing.T, h rankerHarness) {
+	ctx := context.Background()
+
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-2", QuizID: "quiz-2", Score: 30})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-1", QuizID: "quiz-2", Score: 20})
+	submit(t, h.Harness, models.UserQuiz{UserID: "user-3", QuizID: "quiz-1", Score: 15})
+
+	ranked, err := h.Ranker.UserRank(ctx, repositories.GlobalScope, "user-1")
+	require.NoError(t, err)
+	require.Equal(t, repositories.RankedEntry{
+		Rank:     2,
+		UserQuiz: models.UserQuiz{UserID: "user-1", QuizID: "quiz-2", Score: 20},
+	}, ranked, "the best entry of the user is ranked")
+
+	ranked, err = h.Ranker.UserRank(ctx, repositories.QuizScope("quiz-1"), "user-1")
+	require.NoError(t, err)
+	require.Equal(t, repositories.RankedEntry{
+		Rank:     2,
+		UserQuiz: models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+	}, ranked)
+
+	_, err = h.Ranker.UserRank(ctx, repositories.QuizScope("quiz-1"), "user-2")
+	require.ErrorIs(t, err, repositories.ErrNotRanked)
+	_, err = h.Ranker.UserRank(ctx, repositories.GlobalScope, "unknown")
+	require.ErrorIs(t, err, repositories.ErrNotRanked)
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sunary/emu-game/internal/models"
+)
+
+const quizScopePrefix = "quiz:"
+
+var ErrInvalidScope = errors.New("leaderboard scope must be \"global\" or \"quiz:<id>\"")
+
+// Scope names a leaderboard. Every submission is ranked on the global board and on the
+// board of its quiz.
+type Scope string
+
+const GlobalScope Scope = "global"
+
+func QuizScope(quizID string) Scope {
+	return Scope(quizScopePrefix + quizID)
+}
+
+func ParseScope(raw string) (Scope, error) {
+	switch {
+	case raw == "" || raw == string(GlobalScope):
+		return GlobalScope, nil
+	case strings.HasPrefix(raw, quizScopePrefix) && len(raw) > len(quizScopePrefix):
+		return Scope(raw), nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidScope, raw)
+	}
+}
+
+// QuizID returns the quiz of a quiz scope, or "" for the global scope.
+func (s Scope) QuizID() string {
+	return strings.TrimPrefix(string(s), quizScopePrefix)
+}
+
+// Contains reports whether a submission is ranked on this scope's board.
+func (s Scope) Contains(userQuiz models.UserQuiz) bool {
+	return s == GlobalScope || s.QuizID() == userQuiz.QuizID
+}
+
+// ScopeStore gives admin tooling whole-board access to any leaderboard scope.
+type ScopeStore interface {
+	ListScopeScores(ctx context.Context, scope Scope, from, limit int64) ([]models.UserQuiz, error)
+	// ImportScores ranks entries as submissions on all their boards. With replace, every
+	// existing entry of the scope is removed from all its boards first. Entries outside the
+	// scope are rejected.
+	ImportScores(ctx context.Context, scope Scope, entries []models.UserQuiz, replace bool) error
+}
+
+func validateImport(scope Scope, entries []models.UserQuiz) error {
+	for _, entry := range entries {
+		if !scope.Contains(entry) {
+			return fmt.Errorf("entry for user %q and quiz %q is outside scope %s", entry.UserID, entry.QuizID, scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// Versioned is implemented by repositories that count leaderboard changes. A tenant's
+// version grows with every change to any of its boards, so a slice read at some version is
+// still current for as long as the version is unchanged.
+//
+// A missing counter is seeded from the clock rather than zero, so losing it (for example
+// when a Redis cache is flushed) never hands out a version that was already used.
+type Versioned interface {
+	LeaderboardVersion(ctx context.Context) (int64, error)
+}
+
+// versionSeed is the first version of a tenant with no counter yet.
+func versionSeed(now time.Time) int64 {
+	return now.UnixMicro()
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
+)
+
+// leaderboardRebuilder is implemented by repositories that keep the leaderboard as a
+// cache derived from a durable store.
+type leaderboardRebuilder interface {
+	Rebuild(ctx context.Context) (*repositories.RebuildReport, error)
+	CheckConsistency(ctx context.Context) (*repositories.ConsistencyReport, error)
+}
+
+func (a *apiHandlers) rebuildLeaderboard(w http.ResponseWriter, r *http.Request) {
+	rebuilder, ok := a.repo.(leaderboardRebuilder)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "leaderboard is not backed by a durable store")
+		return
+	}
+
+	report, err := rebuilder.Rebuild(r.Context())
+	if err != nil {
+		log.Printf("failed to rebuild leaderboard: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to rebuild leaderboard")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("failed to encode rebuild response: %v", err)
+	}
+}
+
+func (a *apiHandlers) checkLeaderboard(w http.ResponseWriter, r *http.Request) {
+	rebuilder, ok := a.repo.(leaderboardRebuilder)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "leaderboard is not backed by a durable store")
+		return
+	}
+
+	report, err := rebuilder.CheckConsistency(r.Context())
+	if err != nil {
+		log.Printf("failed to check leaderboard consistency: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to check leaderboard consistency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("failed to encode consistency response: %v", err)
+	}
+}
+
+type createSnapshotRequest struct {
+	Label string `json:"label"`
+}
+
+func (a *apiHandlers) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	if a.snapshots == nil {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support snapshots")
+		return
+	}
+
+	var req createSnapshotRequest
+	// The body is optional; a snapshot without a label is still useful.
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeInvalidPayload(w, r, err)
+			return
+		}
+	}
+
+	meta, err := a.snapshots.Create(r.Context(), req.Label)
+	if err != nil {
+		log.Printf("failed to create snapshot: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to create snapshot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Printf("failed to encode snapshot response: %v", err)
+	}
+}
+
+func (a *apiHandlers) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	if a.snapshots == nil {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support snapshots")
+		return
+	}
+
+	metas, err := a.snapshots.List(r.Context())
+	if err != nil {
+		log.Printf("failed to list snapshots: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to list snapshots")
+		return
+	}
+	if metas == nil {
+		metas = []snapshots.Meta{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metas); err != nil {
+		log.Printf("failed to encode snapshots response: %v", err)
+	}
+}
+
+func (a *apiHandlers) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if a.snapshots == nil {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support snapshots")
+		return
+	}
+
+	meta, err := a.snapshots.Restore(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, snapshots.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, errCodeSnapshotNotFound, err.Error())
+			return
+		}
+		log.Printf("failed to restore snapshot: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to restore snapshot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Printf("failed to encode restore response: %v", err)
+	}
+}
@@ -4,21 +4,36 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	miniredis "github.com/alicebob/miniredis/v2"
 	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
 	"github.com/sunary/emu-game/internal/models"
 	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/repositories/repotest"
 	"github.com/sunary/emu-game/pkg"
 )
 
+// mockRepository is an in-memory Repository that passes the repotest suite. It records
+// the arguments it is called with, and set errors or results override its behaviour.
 type mockRepository struct {
+	mu sync.Mutex
+	// elapsed moves the membership clock forward.
+	elapsed time.Duration
+	tenants map[string]*mockTenant
+
 	joinArgs struct {
 		ctx    context.Context
 		userID string
@@ -41,42 +56,153 @@ type mockRepository struct {
 	listErr    error
 }
 
+type mockTenant struct {
+	memberships map[string]mockMembership
+	entries     map[string]models.UserQuiz
+}
+
+type mockMembership struct {
+	quizID  string
+	expires time.Time
+}
+
+// tenant returns the request tenant's data; callers hold m.mu.
+func (m *mockRepository) tenant(ctx context.Context) *mockTenant {
+	id := pkg.GetTenant(ctx).ID
+	if m.tenants == nil {
+		m.tenants = make(map[string]*mockTenant)
+	}
+	if m.tenants[id] == nil {
+		m.tenants[id] = &mockTenant{memberships: make(map[string]mockMembership), entries: make(map[string]models.UserQuiz)}
+	}
+	return m.tenants[id]
+}
+
+// membership returns the user's unexpired quiz; callers hold m.mu.
+func (m *mockRepository) membership(ctx context.Context, userID string) string {
+	joined, ok := m.tenant(ctx).memberships[userID]
+	if !ok || !time.Now().Add(m.elapsed).Before(joined.expires) {
+		return ""
+	}
+	return joined.quizID
+}
+
 func (m *mockRepository) JoinQuiz(ctx context.Context, userID string, quizID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.joinArgs.ctx = ctx
 	m.joinArgs.userID = userID
 	m.joinArgs.quizID = quizID
-	return m.joinErr
+	if m.joinErr != nil {
+		return m.joinErr
+	}
+
+	switch m.membership(ctx, userID) {
+	case "":
+	case quizID:
+		return repositories.ErrAlreadyJoined
+	default:
+		return repositories.ErrJoinedElsewhere
+	}
+	ttl := repositories.MembershipTTL
+	if override := pkg.GetTenant(ctx).MembershipTTL; override > 0 {
+		ttl = override
+	}
+	m.tenant(ctx).memberships[userID] = mockMembership{quizID: quizID, expires: time.Now().Add(m.elapsed + ttl)}
+	return nil
 }
 
 func (m *mockRepository) GetQuizByUserID(ctx context.Context, userID string) (string, error) {
-	return m.getQuizResult, m.getQuizErr
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.getQuizResult != "" || m.getQuizErr != nil {
+		return m.getQuizResult, m.getQuizErr
+	}
+	return m.membership(ctx, userID), nil
 }
 
 func (m *mockRepository) SubmitQuiz(ctx context.Context, quiz models.UserQuiz) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.submitArgs = append(m.submitArgs, quiz)
-	return m.submitErr
+	if m.submitErr != nil {
+		return m.submitErr
+	}
+
+	if m.membership(ctx, quiz.UserID) != quiz.QuizID {
+		return repositories.ErrNotJoined
+	}
+	tenant := m.tenant(ctx)
+	delete(tenant.memberships, quiz.UserID)
+	tenant.entries[repositories.EntryID(quiz)] = quiz
+	return nil
 }
 
 func (m *mockRepository) ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.listArgs.ctx = ctx
 	m.listArgs.from = from
 	m.listArgs.lim = limit
-	if m.listResult == nil {
-		return nil, m.listErr
+	if m.listResult != nil || m.listErr != nil {
+		return m.listResult, m.listErr
+	}
+
+	// Rank like ZREVRANGE: by score, then by member, both descending.
+	ids := make([]string, 0, len(m.tenant(ctx).entries))
+	for id := range m.tenant(ctx).entries {
+		ids = append(ids, id)
+	}
+	entries := m.tenant(ctx).entries
+	sort.Slice(ids, func(i, j int) bool {
+		if a, b := entries[ids[i]].Score, entries[ids[j]].Score; a != b {
+			return a > b
+		}
+		return ids[i] > ids[j]
+	})
+
+	if from < 0 {
+		from = 0
 	}
-	return m.listResult, m.listErr
+	if limit <= 0 {
+		limit = 10
+		if override := pkg.GetTenant(ctx).LeaderboardLimit; override > 0 {
+			limit = override
+		}
+	}
+	scores := []models.UserQuiz{}
+	for _, id := range ids[min(from, int64(len(ids))):min(from+limit, int64(len(ids)))] {
+		scores = append(scores, entries[id])
+	}
+	return scores, nil
 }
 
 func (m *mockRepository) Close() error { return nil }
 
+func TestMockRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Harness {
+		repo := &mockRepository{}
+		return repotest.Harness{Repo: repo, Advance: func(d time.Duration) {
+			repo.mu.Lock()
+			defer repo.mu.Unlock()
+			repo.elapsed += d
+		}}
+	})
+}
+
 func newAPIHandlers(t *testing.T, repo repositories.Repository) *apiHandlers {
 	mr := miniredis.RunT(t)
 	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 	defer mr.Close()
+	bus := events.NewRedisBus(redisClient, 0)
 	return &apiHandlers{
-		repo:  repo,
-		hub:   newHub(redisClient),
-		redis: redisClient,
+		repo: repo,
+		hub:  newHub(bus, configs.WebsocketConfig{}),
+		bus:  bus,
 	}
 }
 
@@ -102,25 +228,38 @@ func TestJoinQuiz_Success(t *testing.T) {
 	require.Equal(t, "quiz-42", repo.joinArgs.quizID)
 }
 
-func TestJoinQuiz_AlreadyJoined(t *testing.T) {
-	repo := &mockRepository{getQuizResult: "quiz-42"}
-	api := newAPIHandlers(t, repo)
+func TestJoinQuiz_MapsMembershipErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   errorCode
+	}{
+		{name: "already joined", err: repositories.ErrAlreadyJoined, status: http.StatusConflict, code: errCodeAlreadyJoined},
+		{name: "joined elsewhere", err: repositories.ErrJoinedElsewhere, status: http.StatusConflict, code: errCodeJoinedElsewhere},
+		{name: "store failure", err: errors.New("boom"), status: http.StatusInternalServerError, code: errCodeInternal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &mockRepository{joinErr: tc.err}
+			api := newAPIHandlers(t, repo)
 
-	req := httptest.NewRequest(http.MethodPost, "/user/quiz-42/join", bytes.NewBufferString(`{}`))
-	req = mux.SetURLVars(req, map[string]string{"id": "quiz-42"})
-	req = withUserContext(req, "user-123")
+			req := httptest.NewRequest(http.MethodPost, "/user/quiz-42/join", bytes.NewBufferString(`{}`))
+			req = mux.SetURLVars(req, map[string]string{"id": "quiz-42"})
+			req = withUserContext(req, "user-123")
 
-	rec := httptest.NewRecorder()
-	api.joinQuiz(rec, req)
+			rec := httptest.NewRecorder()
+			api.joinQuiz(rec, req)
 
-	require.Equal(t, http.StatusBadRequest, rec.Code)
-	require.Empty(t, repo.joinArgs.userID)
+			require.Equal(t, tc.status, rec.Code)
+			require.Equal(t, tc.code, decodeError(t, rec).Code)
+		})
+	}
 }
 
 func TestSubmitQuiz_Success(t *testing.T) {
-	repo := &mockRepository{
-		getQuizResult: "quiz-99",
-	}
+	repo := &mockRepository{}
+	require.NoError(t, repo.JoinQuiz(context.Background(), "user-abc", "quiz-99"))
 	api := newAPIHandlers(t, repo)
 
 	req := httptest.NewRequest(http.MethodPost, "/user/quiz-99/submit", bytes.NewBufferString(`{"score":75}`))
@@ -135,6 +274,20 @@ func TestSubmitQuiz_Success(t *testing.T) {
 	require.Equal(t, models.UserQuiz{UserID: "user-abc", QuizID: "quiz-99", Score: 75}, repo.submitArgs[0])
 }
 
+func TestSubmitQuiz_NotJoined(t *testing.T) {
+	repo := &mockRepository{submitErr: repositories.ErrNotJoined}
+	api := newAPIHandlers(t, repo)
+
+	req := httptest.NewRequest(http.MethodPost, "/user/quiz-99/submit", bytes.NewBufferString(`{"score":75}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "quiz-99"})
+	req = withUserContext(req, "user-abc")
+
+	rec := httptest.NewRecorder()
+	api.submitQuiz(rec, req)
+
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
 func TestLeaderboard_ReturnsScores(t *testing.T) {
 	repo := &mockRepository{
 		listResult: []models.UserQuiz{
@@ -153,3 +306,132 @@ func TestLeaderboard_ReturnsScores(t *testing.T) {
 	require.Equal(t, int64(0), repo.listArgs.from)
 	require.Equal(t, int64(5), repo.listArgs.lim)
 }
+
+func TestAdminRoutesRequireAdminGroup(t *testing.T) {
+	tenants, err := newTenantResolver(configs.TenancyConfig{})
+	require.NoError(t, err)
+	handler := userAuthMiddleware(tenants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	userToken, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	adminToken, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "admin-1"}, adminGroup)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "missing token", status: http.StatusUnauthorized},
+		{name: "user token", token: userToken, status: http.StatusForbidden},
+		{name: "admin token", token: adminToken, status: http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/admin/leaderboard/rebuild", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
+
+func TestRebuildLeaderboard_NotImplementedWithoutDurableStore(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/leaderboard/rebuild", nil)
+	rec := httptest.NewRecorder()
+	api.rebuildLeaderboard(rec, req)
+
+	require.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestSnapshots_NotImplementedWithoutSnapshotSupport(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{})
+
+	for _, handler := range []http.HandlerFunc{api.createSnapshot, api.listSnapshots, api.restoreSnapshot} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/admin/snapshots", nil))
+		require.Equal(t, http.StatusNotImplemented, rec.Code)
+	}
+}
+
+func TestPrivacy_NotImplementedWithoutModeration(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{})
+
+	for _, handler := range []http.HandlerFunc{api.exportUserData, api.eraseUserData} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/admin/privacy/users/user-1", nil))
+		require.Equal(t, http.StatusNotImplemented, rec.Code)
+	}
+}
+
+func TestNewRejectsInvalidTenantID(t *testing.T) {
+	// Tenant IDs become key prefixes and PSUBSCRIBE patterns, so a glob or separator must not load.
+	for _, id := range []string{"acme*", "acme:eu", "Acme"} {
+		cfg := &configs.Config{Tenancy: configs.TenancyConfig{
+			Enabled: true,
+			Tenants: map[string]configs.TenantConfig{id: {Hosts: []string{"acme.quiz.test"}}},
+		}}
+		_, err := New(t.Context(), cfg, nil)
+		require.ErrorContains(t, err, "invalid tenant ID", id)
+
+		_, err = bootstrap.Tenants(cfg.Tenancy)
+		require.ErrorContains(t, err, "invalid tenant ID", id)
+	}
+}
+
+func TestUserAuthMiddleware_TenantClaim(t *testing.T) {
+	tenants, err := newTenantResolver(configs.TenancyConfig{
+		Enabled: true,
+		Tenants: map[string]configs.TenantConfig{
+			"acme": {Hosts: []string{"acme.quiz.test"}, LeaderboardLimit: 25},
+		},
+	})
+	require.NoError(t, err)
+
+	var got pkg.Tenant
+	handler := tenantMiddleware(tenants)(userAuthMiddleware(tenants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = pkg.GetTenant(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	cases := []struct {
+		name   string
+		host   string
+		tenant string
+		status int
+		want   pkg.Tenant
+	}{
+		{name: "claim applies overrides", host: "api.quiz.test", tenant: "acme", status: http.StatusNoContent, want: pkg.Tenant{ID: "acme", LeaderboardLimit: 25}},
+		{name: "host only", host: "acme.quiz.test:8080", status: http.StatusNoContent, want: pkg.Tenant{ID: "acme", LeaderboardLimit: 25}},
+		{name: "claim matches host", host: "acme.quiz.test", tenant: "acme", status: http.StatusNoContent, want: pkg.Tenant{ID: "acme", LeaderboardLimit: 25}},
+		{name: "claim conflicts with host", host: "acme.quiz.test", tenant: "globex", status: http.StatusForbidden},
+		{name: "malformed claim", host: "api.quiz.test", tenant: "Acme:*", status: http.StatusForbidden},
+		{name: "default tenant", host: "api.quiz.test", status: http.StatusNoContent, want: pkg.Tenant{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got = pkg.Tenant{}
+			token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1", Tenant: tc.tenant})
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/user/quiz/q1/join", nil)
+			req.Host = tc.host
+			req.Header.Set("Authorization", "Bearer "+token)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// maxBatchAttempts caps one upload so a single request cannot hold the store for long.
+const maxBatchAttempts = 500
+
+// attemptRejected is the status of an attempt that failed validation and was not submitted.
+const attemptRejected = "rejected"
+
+type attemptResult struct {
+	AttemptID string    `json:"attempt_id"`
+	Status    string    `json:"status"`
+	Error     *apiError `json:"error,omitempty"`
+}
+
+type submitAttemptsResponse struct {
+	Results []attemptResult `json:"results"`
+}
+
+// submitAttempts uploads quizzes played offline. Each attempt is validated on its own;
+// valid ones are submitted together and the response holds one result per attempt, in
+// request order.
+func (a *apiHandlers) submitAttempts(w http.ResponseWriter, r *http.Request) {
+	batch, ok := a.repo.(repositories.BatchSubmitter)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support batch submits")
+		return
+	}
+
+	var req submitAttemptsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidPayload(w, r, err)
+		return
+	}
+	switch {
+	case len(req.Attempts) == 0:
+		writeError(w, r, http.StatusBadRequest, errCodeEmptyBatch, "attempts are required")
+		return
+	case len(req.Attempts) > maxBatchAttempts:
+		writeError(w, r, http.StatusBadRequest, errCodeBatchTooLarge, fmt.Sprintf("at most %d attempts per batch", maxBatchAttempts))
+		return
+	}
+
+	userID := pkg.GetUserID(r.Context())
+	now := time.Now()
+	results := make([]attemptResult, len(req.Attempts))
+	var (
+		valid   []repositories.Attempt
+		indexes []int
+	)
+	for i, attempt := range req.Attempts {
+		results[i].AttemptID = attempt.ID
+		if err := repositories.ValidateAttempt(attempt, now); err != nil {
+			results[i].Status = attemptRejected
+			results[i].Error = &apiError{Code: attemptErrorCode(err), Message: err.Error()}
+			continue
+		}
+		valid = append(valid, attempt)
+		indexes = append(indexes, i)
+	}
+
+	var statuses []repositories.AttemptStatus
+	if len(valid) > 0 {
+		var err error
+		statuses, err = batch.SubmitAttempts(r.Context(), userID, valid)
+		switch {
+		case err == nil:
+			a.publishAttempts(r, userID, valid, statuses)
+		case errors.Is(err, repositories.ErrShadowBanned):
+			// Answer exactly like a ranked batch so the user cannot tell, but broadcast nothing.
+		case errors.Is(err, repositories.ErrBanned):
+			writeError(w, r, http.StatusForbidden, errCodeUserBanned, err.Error())
+			return
+		default:
+			log.Printf("failed to submit attempts: %v", err)
+			writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to submit attempts")
+			return
+		}
+	}
+	for i, status := range statuses {
+		results[indexes[i]].Status = string(status)
+	}
+
+	writeJSON(w, submitAttemptsResponse{Results: results})
+}
+
+// publishAttempts announces every accepted attempt like a single submit, so existing clients
+// need no changes.
+func (a *apiHandlers) publishAttempts(r *http.Request, userID string, attempts []repositories.Attempt, statuses []repositories.AttemptStatus) {
+	version := a.leaderboardVersion(r.Context())
+	for i, attempt := range attempts {
+		if statuses[i] != repositories.AttemptAccepted {
+			continue
+		}
+
+		event := submitEvent{
+			UserQuiz: models.UserQuiz{UserID: userID, QuizID: attempt.QuizID, Score: attempt.Score},
+			Version:  version,
+		}
+		if err := events.Publish(r.Context(), a.bus, events.SubmitQuiz, event); err != nil {
+			log.Printf("failed to publish event: %v", err)
+		}
+	}
+}
+
+func attemptErrorCode(err error) errorCode {
+	switch {
+	case errors.Is(err, repositories.ErrAttemptID):
+		return errCodeMissingAttemptID
+	case errors.Is(err, repositories.ErrAttemptQuizID):
+		return errCodeMissingQuizID
+	case errors.Is(err, repositories.ErrAttemptScore):
+		return errCodeInvalidScore
+	default:
+		return errCodeAttemptExpired
+	}
+}
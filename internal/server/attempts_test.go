@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+func TestSubmitAttempts(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+
+	api := newAPIHandlers(t, repo)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+	api.bus = bus
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	playedAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/user/attempts", bytes.NewBufferString(body))
+		req = withUserContext(req, "user-1")
+		rec := httptest.NewRecorder()
+		api.submitAttempts(rec, req)
+		return rec
+	}
+
+	rec := post(fmt.Sprintf(`{"attempts":[
+		{"attempt_id":"a1","quiz_id":"quiz-1","score":10,"played_at":%[1]q},
+		{"attempt_id":"","quiz_id":"quiz-1","score":20,"played_at":%[1]q},
+		{"attempt_id":"a2","quiz_id":"quiz-2","score":30,"played_at":"2000-01-01T00:00:00Z"},
+		{"attempt_id":"a1","quiz_id":"quiz-1","score":10,"played_at":%[1]q}
+	]}`, playedAt))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp submitAttemptsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Results, 4)
+	require.Equal(t, attemptResult{AttemptID: "a1", Status: "accepted"}, resp.Results[0])
+	require.Equal(t, attemptRejected, resp.Results[1].Status)
+	require.Equal(t, errCodeMissingAttemptID, resp.Results[1].Error.Code)
+	require.Equal(t, errCodeAttemptExpired, resp.Results[2].Error.Code)
+	require.Equal(t, attemptResult{AttemptID: "a1", Status: "duplicate"}, resp.Results[3])
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: 10}}, scores)
+
+	select {
+	case msg := <-ch:
+		require.Contains(t, string(msg.Payload), `"event":"submit_quiz_event"`)
+	case <-time.After(time.Second):
+		t.Fatal("accepted attempt not published")
+	}
+
+	rec = post(`{"attempts":[]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, errCodeEmptyBatch, decodeError(t, rec).Code)
+
+	attempts := strings.Repeat(`{"attempt_id":"x","quiz_id":"q","score":1,"played_at":"`+playedAt+`"},`, maxBatchAttempts+1)
+	rec = post(`{"attempts":[` + strings.TrimSuffix(attempts, ",") + `]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, errCodeBatchTooLarge, decodeError(t, rec).Code)
+
+	require.NoError(t, repo.SetBan(ctx, "user-1", repositories.BanBlocked))
+	rec = post(fmt.Sprintf(`{"attempts":[{"attempt_id":"a3","quiz_id":"quiz-1","score":1,"played_at":%q}]}`, playedAt))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, errCodeUserBanned, decodeError(t, rec).Code)
+}
+
+func TestSubmitAttempts_NotImplementedWithoutBatchSupport(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{})
+
+	rec := httptest.NewRecorder()
+	api.submitAttempts(rec, httptest.NewRequest(http.MethodPost, "/user/attempts", bytes.NewBufferString(`{"attempts":[]}`)))
+	require.Equal(t, http.StatusNotImplemented, rec.Code)
+}
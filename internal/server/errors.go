@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/sunary/emu-game/pkg"
+)
+
+// errorCode is a stable, machine-readable error identifier. Codes are part of the API
+// contract: clients branch on them, so existing codes must never be renamed. The catalog is
+// documented in the README.
+type errorCode string
+
+const (
+	errCodeInvalidPayload   errorCode = "invalid_payload"
+	errCodeMissingQuizID    errorCode = "missing_quiz_id"
+	errCodeMissingKind      errorCode = "missing_kind"
+	errCodeInvalidScope     errorCode = "invalid_scope"
+	errCodeInvalidCursor    errorCode = "invalid_cursor"
+	errCodeAlreadyJoined    errorCode = "already_joined"
+	errCodeJoinedElsewhere  errorCode = "joined_elsewhere"
+	errCodeNotJoined        errorCode = "not_joined"
+	errCodeNotRanked        errorCode = "not_ranked"
+	errCodeUserBanned       errorCode = "user_banned"
+	errCodeInvalidBanMode   errorCode = "invalid_ban_mode"
+	errCodeInvalidEraseMode errorCode = "invalid_erase_mode"
+	errCodeEmptyBatch       errorCode = "empty_batch"
+	errCodeBatchTooLarge    errorCode = "batch_too_large"
+	// Per-attempt codes of a batch submit; the batch itself still succeeds.
+	errCodeMissingAttemptID errorCode = "missing_attempt_id"
+	errCodeInvalidScore     errorCode = "invalid_score"
+	errCodeAttemptExpired   errorCode = "attempt_outside_window"
+	errCodeMissingToken     errorCode = "missing_token"
+	errCodeMalformedToken   errorCode = "malformed_authorization"
+	errCodeInvalidToken     errorCode = "invalid_token"
+	errCodeAdminRequired    errorCode = "admin_required"
+	errCodeTenantMismatch   errorCode = "tenant_mismatch"
+	// Websocket authentication codes, sent in {"type":"error"} messages.
+	errCodeAuthTimeout          errorCode = "auth_timeout"
+	errCodeAlreadyAuthenticated errorCode = "already_authenticated"
+	// Websocket subscription codes.
+	errCodeUnknownOp      errorCode = "unknown_op"
+	errCodeInvalidTopic   errorCode = "invalid_topic"
+	errCodeForbiddenTopic errorCode = "forbidden_topic"
+	errCodeTooManyTopics  errorCode = "too_many_topics"
+	// Websocket resume codes; invalid_resume is also an HTTP 400 on the upgrade.
+	errCodeInvalidResume    errorCode = "invalid_resume"
+	errCodeResumeGap        errorCode = "resume_gap"
+	errCodeNotImplemented   errorCode = "not_implemented"
+	errCodeSnapshotNotFound errorCode = "snapshot_not_found"
+	errCodeNotFound         errorCode = "not_found"
+	errCodeMethodNotAllowed errorCode = "method_not_allowed"
+	errCodeUpgradeFailed    errorCode = "websocket_upgrade_failed"
+	errCodeInternal         errorCode = "internal_error"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Client-supplied request IDs are echoed into logs and responses, so only a safe alphabet
+// is accepted; anything else is replaced with a generated ID.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type apiError struct {
+	Code      errorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+	Details   any       `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code errorCode, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+// writeErrorDetails writes the JSON error envelope; details carries optional structured
+// context such as the offending field.
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code errorCode, message string, details any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	resp := errorResponse{Error: apiError{
+		Code:      code,
+		Message:   message,
+		RequestID: pkg.GetRequestID(r.Context()),
+		Details:   details,
+	}}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed to encode error response: %v", err)
+	}
+}
+
+// writeInvalidPayload reports a request body that could not be decoded.
+func writeInvalidPayload(w http.ResponseWriter, r *http.Request, err error) {
+	writeErrorDetails(w, r, http.StatusBadRequest, errCodeInvalidPayload, "invalid payload",
+		map[string]string{"reason": err.Error()})
+}
+
+// requestIDMiddleware tags every request with an ID, reusing a well-formed X-Request-ID from
+// the client or proxy, and echoes it back so errors can be correlated with server logs.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(pkg.WithRequestID(r.Context(), requestID)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("failed to generate request id: %v", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, errCodeNotFound, "route not found")
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+}
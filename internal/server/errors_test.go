@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) apiError {
+	t.Helper()
+
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var resp errorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return resp.Error
+}
+
+func TestWriteErrorEnvelope(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(pkg.WithRequestID(req.Context(), "req-1"))
+	rec := httptest.NewRecorder()
+
+	writeErrorDetails(rec, req, http.StatusConflict, errCodeAlreadyJoined, "user already joined quiz", map[string]string{"quiz_id": "quiz-1"})
+
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.JSONEq(t, `{"error":{"code":"already_joined","message":"user already joined quiz","request_id":"req-1","details":{"quiz_id":"quiz-1"}}}`, rec.Body.String())
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var seen string
+	handler := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = pkg.GetRequestID(r.Context())
+	}))
+
+	cases := []struct {
+		name     string
+		incoming string
+		reuse    bool
+	}{
+		{name: "generated", incoming: ""},
+		{name: "reused", incoming: "trace-42.a_b", reuse: true},
+		{name: "unsafe replaced", incoming: "bad id\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				req.Header.Set(requestIDHeader, tc.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.NotEmpty(t, seen)
+			require.Equal(t, seen, rec.Header().Get(requestIDHeader))
+			if tc.reuse {
+				require.Equal(t, tc.incoming, seen)
+			} else {
+				require.NotEqual(t, tc.incoming, seen)
+			}
+		})
+	}
+}
+
+func TestUserAuthMiddleware_ErrorCodes(t *testing.T) {
+	tenants, err := newTenantResolver(configs.TenancyConfig{})
+	require.NoError(t, err)
+	handler := requestIDMiddleware(userAuthMiddleware(tenants)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	userToken, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		path   string
+		header string
+		status int
+		code   errorCode
+	}{
+		{name: "missing token", path: "/user/quiz/q/join", status: http.StatusUnauthorized, code: errCodeMissingToken},
+		{name: "not bearer", path: "/user/quiz/q/join", header: "Basic abc", status: http.StatusUnauthorized, code: errCodeMalformedToken},
+		{name: "bad signature", path: "/user/quiz/q/join", header: "Bearer not.a.jwt", status: http.StatusUnauthorized, code: errCodeInvalidToken},
+		{name: "not admin", path: "/admin/snapshots", header: "Bearer " + userToken, status: http.StatusForbidden, code: errCodeAdminRequired},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.status, rec.Code)
+			apiErr := decodeError(t, rec)
+			require.Equal(t, tc.code, apiErr.Code)
+			require.Equal(t, rec.Header().Get(requestIDHeader), apiErr.RequestID)
+		})
+	}
+}
+
+func TestHandlersReturnErrorEnvelope(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{submitErr: repositories.ErrNotJoined})
+
+	req := httptest.NewRequest(http.MethodPost, "/user/quiz/quiz-1/join", bytes.NewBufferString(`{`))
+	req = mux.SetURLVars(req, map[string]string{"id": "quiz-1"})
+	rec := httptest.NewRecorder()
+	api.joinQuiz(rec, withUserContext(req, "user-1"))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	apiErr := decodeError(t, rec)
+	require.Equal(t, errCodeInvalidPayload, apiErr.Code)
+	require.NotEmpty(t, apiErr.Details)
+
+	req = httptest.NewRequest(http.MethodPost, "/user/quiz/quiz-1/submit", bytes.NewBufferString(`{"score":1}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "quiz-1"})
+	rec = httptest.NewRecorder()
+	api.submitQuiz(rec, withUserContext(req, "user-1"))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, errCodeNotJoined, decodeError(t, rec).Code)
+}
+
+func TestUnknownRouteReturnsErrorEnvelope(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	resp, err := http.Get(ts.URL + "/nope")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, errCodeNotFound, body.Error.Code)
+	require.Equal(t, resp.Header.Get(requestIDHeader), body.Error.RequestID)
+}
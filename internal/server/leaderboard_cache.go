@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+// leaderboardVersionHeader carries the version a leaderboard response was read at, so
+// clients can compare it with the version in websocket events and skip needless fetches.
+const leaderboardVersionHeader = "X-Leaderboard-Version"
+
+// leaderboardCache is a read-through cache of encoded leaderboard slices. Each slice is
+// tagged with the leaderboard version it was read at, so a version bump on any instance
+// invalidates it without coordination: a slice is only served while its version is current.
+type leaderboardCache struct {
+	mu      sync.Mutex
+	slices  map[sliceKey]cachedSlice
+	entries int
+	maxRank int64
+}
+
+type sliceKey struct {
+	tenant string
+	from   int64
+	limit  int64
+}
+
+type cachedSlice struct {
+	version int64
+	body    []byte
+}
+
+func newLeaderboardCache(cfg configs.LeaderboardCacheConfig) *leaderboardCache {
+	return &leaderboardCache{
+		slices:  make(map[sliceKey]cachedSlice),
+		entries: cfg.Entries,
+		maxRank: cfg.MaxRank,
+	}
+}
+
+// cacheable reports whether a slice is one of the popular top-N slices worth caching.
+func (c *leaderboardCache) cacheable(key sliceKey) bool {
+	return c != nil && c.entries > 0 && key.from >= 0 && key.limit > 0 && key.from+key.limit <= c.maxRank
+}
+
+func (c *leaderboardCache) get(key sliceKey, version int64) ([]byte, bool) {
+	if !c.cacheable(key) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slice, ok := c.slices[key]
+	if !ok || slice.version != version {
+		return nil, false
+	}
+	return slice.body, true
+}
+
+func (c *leaderboardCache) put(key sliceKey, version int64, body []byte) {
+	if !c.cacheable(key) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.slices[key]; !ok && len(c.slices) >= c.entries {
+		c.evict(key.tenant, version)
+	}
+	c.slices[key] = cachedSlice{version: version, body: body}
+}
+
+// evict makes room for one slice: it drops the tenant's slices older than version, and
+// failing that an arbitrary slice.
+func (c *leaderboardCache) evict(tenant string, version int64) {
+	for key, slice := range c.slices {
+		if key.tenant == tenant && slice.version < version {
+			delete(c.slices, key)
+		}
+	}
+	if len(c.slices) < c.entries {
+		return
+	}
+	for key := range c.slices {
+		delete(c.slices, key)
+		return
+	}
+}
+
+// leaderboardVersion is the request tenant's leaderboard version, or 0 when the store
+// driver does not track one.
+func (a *apiHandlers) leaderboardVersion(ctx context.Context) int64 {
+	versioned, ok := a.repo.(repositories.Versioned)
+	if !ok {
+		return 0
+	}
+
+	version, err := versioned.LeaderboardVersion(ctx)
+	if err != nil {
+		log.Printf("failed to read leaderboard version: %v", err)
+		return 0
+	}
+	return version
+}
+
+func leaderboardETag(version int64, req leaderboardRequest) string {
+	return fmt.Sprintf(`"%d-%d-%d"`, version, req.From, req.Limit)
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
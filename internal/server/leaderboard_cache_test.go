@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+// countingRepository counts the board reads that reach the store.
+type countingRepository struct {
+	*repositories.RedisRepository
+	reads int
+}
+
+func (c *countingRepository) ListUserScores(ctx context.Context, from, limit int64) ([]models.UserQuiz, error) {
+	c.reads++
+	return c.RedisRepository.ListUserScores(ctx, from, limit)
+}
+
+func TestLeaderboard_CachesAndRevalidates(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	redisRepo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+	repo := &countingRepository{RedisRepository: redisRepo}
+
+	api := newAPIHandlers(t, repo)
+	api.cache = newLeaderboardCache(configs.LeaderboardCacheConfig{Entries: 4, MaxRank: 100})
+
+	submit := func(entry models.UserQuiz) {
+		require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, entry))
+	}
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/leaderboard", bytes.NewBufferString(`{"from":0,"limit":10}`))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		api.leaderboard(rec, req)
+		return rec
+	}
+
+	submit(models.UserQuiz{UserID: "u1", QuizID: "q1", Score: 100})
+
+	first := get("")
+	require.Equal(t, http.StatusOK, first.Code)
+	require.JSONEq(t, `[{"user_id":"u1","quiz_id":"q1","score":100}]`, first.Body.String())
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	require.NotEmpty(t, first.Header().Get(leaderboardVersionHeader))
+
+	require.Equal(t, first.Body.String(), get("").Body.String())
+	require.Equal(t, 1, repo.reads, "the second read is served from the cache")
+
+	notModified := get(etag)
+	require.Equal(t, http.StatusNotModified, notModified.Code)
+	require.Empty(t, notModified.Body.String())
+
+	submit(models.UserQuiz{UserID: "u2", QuizID: "q1", Score: 200})
+
+	changed := get(etag)
+	require.Equal(t, http.StatusOK, changed.Code, "a submit invalidates the cached slice")
+	require.NotEqual(t, etag, changed.Header().Get("ETag"))
+	require.JSONEq(t, `[{"user_id":"u2","quiz_id":"q1","score":200},{"user_id":"u1","quiz_id":"q1","score":100}]`, changed.Body.String())
+	require.Equal(t, 2, repo.reads)
+}
+
+func TestLeaderboardCache_OnlyKeepsTopSlices(t *testing.T) {
+	cache := newLeaderboardCache(configs.LeaderboardCacheConfig{Entries: 2, MaxRank: 50})
+
+	deep := sliceKey{from: 100, limit: 10}
+	cache.put(deep, 1, []byte("deep"))
+	_, ok := cache.get(deep, 1)
+	require.False(t, ok, "slices past max_rank are not cached")
+
+	top := sliceKey{from: 0, limit: 10}
+	cache.put(top, 1, []byte("v1"))
+	_, ok = cache.get(top, 2)
+	require.False(t, ok, "a newer version misses")
+
+	cache.put(sliceKey{from: 10, limit: 10}, 2, []byte("b"))
+	cache.put(sliceKey{from: 20, limit: 10}, 2, []byte("c"))
+	require.Len(t, cache.slices, 2)
+	_, ok = cache.get(top, 1)
+	require.False(t, ok, "stale slices are evicted first")
+}
+
+func TestEtagMatches(t *testing.T) {
+	require.True(t, etagMatches(`"1-0-10"`, `"1-0-10"`))
+	require.True(t, etagMatches(`W/"1-0-10"`, `"1-0-10"`))
+	require.True(t, etagMatches(`"0-0-10", "1-0-10"`, `"1-0-10"`))
+	require.True(t, etagMatches(`*`, `"1-0-10"`))
+	require.False(t, etagMatches(``, `"1-0-10"`))
+	require.False(t, etagMatches(`"2-0-10"`, `"1-0-10"`))
+}
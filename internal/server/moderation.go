@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+const (
+	moderationDeleteUserScores = "delete_user_scores"
+	moderationDeleteQuizScores = "delete_quiz_scores"
+	moderationBan              = "ban"
+	moderationUnban            = "unban"
+	moderationEraseUser        = "erase_user"
+)
+
+// moderationEvent tells clients to refresh after entries were removed or a user's ban
+// changed.
+type moderationEvent struct {
+	Action  string               `json:"action"`
+	UserID  string               `json:"user_id,omitempty"`
+	QuizID  string               `json:"quiz_id,omitempty"`
+	Mode    repositories.BanMode `json:"mode,omitempty"`
+	Removed int                  `json:"removed,omitempty"`
+	Version int64                `json:"version,omitempty"`
+}
+
+type banRequest struct {
+	Mode string `json:"mode"`
+}
+
+type removedResponse struct {
+	Removed int `json:"removed"`
+}
+
+type banResponse struct {
+	UserID string               `json:"user_id"`
+	Mode   repositories.BanMode `json:"mode"`
+}
+
+func (a *apiHandlers) moderator(w http.ResponseWriter, r *http.Request) (repositories.Moderator, bool) {
+	moderator, ok := a.repo.(repositories.Moderator)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support moderation")
+	}
+	return moderator, ok
+}
+
+func (a *apiHandlers) deleteUserScores(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := a.moderator(w, r)
+	if !ok {
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	removed, err := moderator.DeleteUserScores(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to delete scores of user %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to delete user scores")
+		return
+	}
+
+	a.publishModeration(r, moderationEvent{Action: moderationDeleteUserScores, UserID: userID, Removed: removed})
+	writeJSON(w, removedResponse{Removed: removed})
+}
+
+func (a *apiHandlers) deleteQuizScores(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := a.moderator(w, r)
+	if !ok {
+		return
+	}
+
+	quizID := mux.Vars(r)["id"]
+	removed, err := moderator.DeleteQuizScores(r.Context(), quizID)
+	if err != nil {
+		log.Printf("failed to delete scores of quiz %s: %v", quizID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to delete quiz scores")
+		return
+	}
+
+	a.publishModeration(r, moderationEvent{Action: moderationDeleteQuizScores, QuizID: quizID, Removed: removed})
+	writeJSON(w, removedResponse{Removed: removed})
+}
+
+func (a *apiHandlers) banUser(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := a.moderator(w, r)
+	if !ok {
+		return
+	}
+
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidPayload(w, r, err)
+		return
+	}
+	mode, err := repositories.ParseBanMode(req.Mode)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBanMode, err.Error())
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	if err := moderator.SetBan(r.Context(), userID, mode); err != nil {
+		log.Printf("failed to ban user %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to ban user")
+		return
+	}
+
+	// Shadow bans must stay invisible to the banned user, so only hard bans are announced.
+	if mode == repositories.BanBlocked {
+		a.publishModeration(r, moderationEvent{Action: moderationBan, UserID: userID, Mode: mode})
+	}
+	writeJSON(w, banResponse{UserID: userID, Mode: mode})
+}
+
+func (a *apiHandlers) unbanUser(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := a.moderator(w, r)
+	if !ok {
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	previous, err := moderator.GetBan(r.Context(), userID)
+	if err == nil {
+		err = moderator.SetBan(r.Context(), userID, repositories.BanNone)
+	}
+	if err != nil {
+		log.Printf("failed to unban user %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to unban user")
+		return
+	}
+
+	if previous == repositories.BanBlocked {
+		a.publishModeration(r, moderationEvent{Action: moderationUnban, UserID: userID})
+	}
+	writeJSON(w, banResponse{UserID: userID, Mode: repositories.BanNone})
+}
+
+func (a *apiHandlers) listBans(w http.ResponseWriter, r *http.Request) {
+	moderator, ok := a.moderator(w, r)
+	if !ok {
+		return
+	}
+
+	bans, err := moderator.ListBans(r.Context())
+	if err != nil {
+		log.Printf("failed to list bans: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to list bans")
+		return
+	}
+
+	resp := make([]banResponse, 0, len(bans))
+	for userID, mode := range bans {
+		resp = append(resp, banResponse{UserID: userID, Mode: mode})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].UserID < resp[j].UserID })
+	writeJSON(w, resp)
+}
+
+func (a *apiHandlers) publishModeration(r *http.Request, event moderationEvent) {
+	event.Version = a.leaderboardVersion(r.Context())
+	if err := events.Publish(r.Context(), a.bus, events.Moderation, event); err != nil {
+		// The change is already applied; clients catch up on their next read.
+		log.Printf("failed to publish moderation event: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
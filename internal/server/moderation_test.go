@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func TestModerationRemovesScoresAndNotifiesClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{Repo: repo, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	for _, entry := range []models.UserQuiz{
+		{UserID: "cheater", QuizID: "quiz-1", Score: 999},
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+	} {
+		require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, entry))
+	}
+
+	conn := dialWS(t, ts, "")
+	subscribe(t, conn, wsTopicGlobal)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "admin-1"}, adminGroup)
+	require.NoError(t, err)
+	adminRequest := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := adminRequest(http.MethodDelete, "/admin/moderation/users/cheater/scores", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var removed removedResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&removed))
+	require.Equal(t, 1, removed.Removed)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	version, err := repo.LeaderboardVersion(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`{"seq":1,"event":"moderation_event","data":{"action":"delete_user_scores","user_id":"cheater","removed":1,"version":%d}}`, version), string(message))
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: 10}}, scores)
+
+	resp = adminRequest(http.MethodPut, "/admin/moderation/users/cheater/ban", `{"mode":"forever"}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = adminRequest(http.MethodPut, "/admin/moderation/users/cheater/ban", `{"mode":"ban"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.ErrorIs(t, repo.JoinQuiz(ctx, "cheater", "quiz-1"), repositories.ErrBanned)
+
+	resp = adminRequest(http.MethodGet, "/admin/moderation/bans", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var bans []banResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&bans))
+	require.Equal(t, []banResponse{{UserID: "cheater", Mode: repositories.BanBlocked}}, bans)
+
+	resp = adminRequest(http.MethodDelete, "/admin/moderation/users/cheater/ban", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, repo.JoinQuiz(ctx, "cheater", "quiz-1"))
+}
+
+func TestModeration_NotImplementedWithoutModerator(t *testing.T) {
+	api := newAPIHandlers(t, &mockRepository{})
+
+	rec := httptest.NewRecorder()
+	api.deleteUserScores(rec, httptest.NewRequest(http.MethodDelete, "/admin/moderation/users/u/scores", nil))
+
+	require.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+func TestSubmitQuiz_Bans(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "banned", err: repositories.ErrBanned, status: http.StatusForbidden},
+		{name: "shadow-banned looks successful", err: repositories.ErrShadowBanned, status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := newAPIHandlers(t, &mockRepository{submitErr: tc.err})
+			bus := events.NewLocalBus(0)
+			t.Cleanup(func() { bus.Close() })
+			api.bus = bus
+			ch, err := bus.Subscribe(context.Background())
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/user/quiz/quiz-1/submit", bytes.NewBufferString(`{"score":1}`))
+			req = mux.SetURLVars(req, map[string]string{"id": "quiz-1"})
+			rec := httptest.NewRecorder()
+			api.submitQuiz(rec, withUserContext(req, "cheater"))
+
+			require.Equal(t, tc.status, rec.Code)
+			select {
+			case msg := <-ch:
+				t.Fatalf("unexpected broadcast: %s", msg.Payload)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
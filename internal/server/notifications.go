@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/internal/events"
+)
+
+type notifyRequest struct {
+	Kind    string          `json:"kind"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+	// Inbox keeps the notification for a user with no connection until they connect.
+	Inbox bool `json:"inbox"`
+}
+
+// notifyUser sends a notification to every connection of a user, on whichever instance holds
+// them.
+func (a *apiHandlers) notifyUser(w http.ResponseWriter, r *http.Request) {
+	var req notifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidPayload(w, r, err)
+		return
+	}
+	if req.Kind == "" {
+		writeError(w, r, http.StatusBadRequest, errCodeMissingKind, "kind is required")
+		return
+	}
+
+	n, err := events.Notify(r.Context(), a.bus, events.Notification{
+		UserID:  mux.Vars(r)["id"],
+		Kind:    req.Kind,
+		Message: req.Message,
+		Data:    req.Data,
+		Inbox:   req.Inbox,
+	})
+	switch {
+	case errors.Is(err, events.ErrNoInbox):
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "event bus keeps no inbox")
+		return
+	case err != nil:
+		log.Printf("failed to notify user: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to notify user")
+		return
+	}
+	writeJSON(w, n)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+const (
+	presenceMembersLimit    = 100
+	presenceMembersMaxLimit = 1000
+)
+
+type presenceResponse struct {
+	Scope repositories.Scope `json:"scope"`
+	Count int64              `json:"count"`
+}
+
+type presenceMembersResponse struct {
+	Scope   repositories.Scope `json:"scope"`
+	Members []string           `json:"members"`
+	// Cursor reads the next page; it is "0" after the last one.
+	Cursor string `json:"cursor"`
+}
+
+type userPresenceResponse struct {
+	UserID string `json:"user_id"`
+	Online bool   `json:"online"`
+}
+
+func (a *apiHandlers) presenceScope(w http.ResponseWriter, r *http.Request) (repositories.Scope, bool) {
+	scope, err := repositories.ParseScope(mux.Vars(r)["scope"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidScope, err.Error())
+		return "", false
+	}
+	return scope, true
+}
+
+func (a *apiHandlers) presenceCount(w http.ResponseWriter, r *http.Request) {
+	scope, ok := a.presenceScope(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := a.presence.Count(r.Context(), scope)
+	if err != nil {
+		log.Printf("failed to count presence: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to count presence")
+		return
+	}
+	writeJSON(w, presenceResponse{Scope: scope, Count: count})
+}
+
+// presenceMembers pages through the users present in a scope with ?cursor= and ?limit=.
+// A page may hold fewer or, with Redis, somewhat more users than the limit.
+func (a *apiHandlers) presenceMembers(w http.ResponseWriter, r *http.Request) {
+	scope, ok := a.presenceScope(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var cursor uint64
+	if raw := query.Get("cursor"); raw != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidCursor, "cursor must be the one returned by the previous page")
+			return
+		}
+	}
+	limit := int64(presenceMembersLimit)
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidPayload, "limit must be a positive number")
+			return
+		}
+		limit = min(n, presenceMembersMaxLimit)
+	}
+
+	members, next, err := a.presence.Members(r.Context(), scope, cursor, limit)
+	if err != nil {
+		log.Printf("failed to list presence: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to list presence")
+		return
+	}
+	if members == nil {
+		members = []string{}
+	}
+	writeJSON(w, presenceMembersResponse{Scope: scope, Members: members, Cursor: strconv.FormatUint(next, 10)})
+}
+
+func (a *apiHandlers) userPresence(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+	online, err := a.presence.Present(r.Context(), repositories.GlobalScope, userID)
+	if err != nil {
+		log.Printf("failed to read presence: %v", err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to read presence")
+		return
+	}
+	writeJSON(w, userPresenceResponse{UserID: userID, Online: online})
+}
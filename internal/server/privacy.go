@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/privacy"
+)
+
+type eraseRequest struct {
+	Mode string `json:"mode"`
+}
+
+func (a *apiHandlers) privacyService(w http.ResponseWriter, r *http.Request) (*privacy.Service, bool) {
+	source, ok := a.repo.(privacy.Source)
+	if !ok {
+		writeError(w, r, http.StatusNotImplemented, errCodeNotImplemented, "store driver does not support privacy requests")
+		return nil, false
+	}
+	history, _ := a.bus.(events.Purger)
+	return privacy.NewService(source, a.snapshots, history), true
+}
+
+func (a *apiHandlers) exportUserData(w http.ResponseWriter, r *http.Request) {
+	service, ok := a.privacyService(w, r)
+	if !ok {
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	data, err := service.Export(r.Context(), userID)
+	if err != nil {
+		log.Printf("failed to export data of user %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to export user data")
+		return
+	}
+
+	writeJSON(w, data)
+}
+
+func (a *apiHandlers) eraseUserData(w http.ResponseWriter, r *http.Request) {
+	service, ok := a.privacyService(w, r)
+	if !ok {
+		return
+	}
+
+	var req eraseRequest
+	// The body is optional; erasure is the default.
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeInvalidPayload(w, r, err)
+			return
+		}
+	}
+	mode, err := privacy.ParseMode(req.Mode)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidEraseMode, err.Error())
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	report, err := service.Erase(r.Context(), userID, mode)
+	if err != nil {
+		log.Printf("failed to erase data of user %s: %v", userID, err)
+		writeError(w, r, http.StatusInternalServerError, errCodeInternal, "failed to erase user data")
+		return
+	}
+
+	// The event must not carry the erased user id; clients only need to refresh.
+	if report.EntriesRemoved > 0 || report.EntriesAnonymized > 0 {
+		a.publishModeration(r, moderationEvent{Action: moderationEraseUser, Removed: report.EntriesRemoved})
+	}
+	writeJSON(w, report)
+}
@@ -1,127 +1,249 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/sunary/emu-game/internal/events"
 	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
 	"github.com/sunary/emu-game/pkg"
 )
 
-func (a *apiHandlers) joinQuiz(w http.ResponseWriter, r *http.Request) {
-	userID := pkg.GetUserID(r.Context())
+// submitEvent is the ranked score plus the leaderboard version that includes it; clients
+// holding a leaderboard at that version or newer need not fetch it again.
+type submitEvent struct {
+	models.UserQuiz
+	Version int64 `json:"version,omitempty"`
+}
 
-	var req joinQuizRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
-		return
-	}
+// actionError is a failed quiz action: the status REST answers with, and the code and
+// message both REST and websocket RPC report.
+type actionError struct {
+	status  int
+	code    errorCode
+	message string
+}
 
-	reqQuizID := mux.Vars(r)["id"]
-	if reqQuizID == "" {
-		http.Error(w, "quiz ID is required", http.StatusBadRequest)
-		return
-	}
+func writeActionError(w http.ResponseWriter, r *http.Request, err *actionError) {
+	writeError(w, r, err.status, err.code, err.message)
+}
 
-	quiz, err := a.repo.GetQuizByUserID(r.Context(), userID)
-	if err != nil {
-		log.Printf("failed to get quiz by user id: %v", err)
-		http.Error(w, "failed to get quiz by user id", http.StatusInternalServerError)
-		return
-	}
-	if quiz != "" {
-		if quiz == reqQuizID {
-			// Protect against accidental rejoin to the same quiz—prevent duplicate state.
-			http.Error(w, "user already joined quiz", http.StatusBadRequest)
-			return
-		}
+type actionResponse struct {
+	Message string `json:"message"`
+}
 
-		// Users cannot be in multiple quizzes simultaneously to avoid conflicting submissions.
-		http.Error(w, "user already joined another quiz", http.StatusBadRequest)
+func (a *apiHandlers) joinQuiz(w http.ResponseWriter, r *http.Request) {
+	var req joinQuizRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidPayload(w, r, err)
 		return
 	}
 
-	if err := a.repo.JoinQuiz(r.Context(), userID, reqQuizID); err != nil {
-		log.Printf("failed to join quiz: %v", err)
-		http.Error(w, "failed to join quiz", http.StatusInternalServerError)
+	resp, failure := a.join(r.Context(), pkg.GetUserID(r.Context()), mux.Vars(r)["id"])
+	if failure != nil {
+		writeActionError(w, r, failure)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("joined quiz %s", reqQuizID)}); err != nil {
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("failed to encode join quiz response: %v", err)
 	}
 }
 
-func (a *apiHandlers) submitQuiz(w http.ResponseWriter, r *http.Request) {
-	userID := pkg.GetUserID(r.Context())
+func (a *apiHandlers) join(ctx context.Context, userID, quizID string) (actionResponse, *actionError) {
+	if quizID == "" {
+		return actionResponse{}, &actionError{http.StatusBadRequest, errCodeMissingQuizID, "quiz ID is required"}
+	}
+
+	if err := a.repo.JoinQuiz(ctx, userID, quizID); err != nil {
+		// Users cannot be in multiple quizzes simultaneously, and re-joining the same quiz
+		// would reset its state, so both are conflicts with the existing membership.
+		switch {
+		case errors.Is(err, repositories.ErrAlreadyJoined):
+			return actionResponse{}, &actionError{http.StatusConflict, errCodeAlreadyJoined, err.Error()}
+		case errors.Is(err, repositories.ErrJoinedElsewhere):
+			return actionResponse{}, &actionError{http.StatusConflict, errCodeJoinedElsewhere, err.Error()}
+		case errors.Is(err, repositories.ErrBanned):
+			return actionResponse{}, &actionError{http.StatusForbidden, errCodeUserBanned, err.Error()}
+		default:
+			log.Printf("failed to join quiz: %v", err)
+			return actionResponse{}, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to join quiz"}
+		}
+	}
+	return actionResponse{Message: fmt.Sprintf("joined quiz %s", quizID)}, nil
+}
 
+func (a *apiHandlers) submitQuiz(w http.ResponseWriter, r *http.Request) {
 	var req submitQuizRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		writeInvalidPayload(w, r, err)
 		return
 	}
 
-	reqQuizID := mux.Vars(r)["id"]
-	if reqQuizID == "" {
-		http.Error(w, "quiz ID is required", http.StatusBadRequest)
+	resp, failure := a.submit(r.Context(), pkg.GetUserID(r.Context()), mux.Vars(r)["id"], req.Score)
+	if failure != nil {
+		writeActionError(w, r, failure)
 		return
 	}
+	writeJSON(w, resp)
+}
 
-	quiz, err := a.repo.GetQuizByUserID(r.Context(), userID)
-	if err != nil {
-		log.Printf("failed to get quiz by user id: %v", err)
-		http.Error(w, "failed to get quiz by user id", http.StatusInternalServerError)
-		return
+func (a *apiHandlers) submit(ctx context.Context, userID, quizID string, score float64) (actionResponse, *actionError) {
+	if quizID == "" {
+		return actionResponse{}, &actionError{http.StatusBadRequest, errCodeMissingQuizID, "quiz ID is required"}
 	}
-	if quiz != reqQuizID {
+
+	err := a.repo.SubmitQuiz(ctx, models.UserQuiz{UserID: userID, QuizID: quizID, Score: score})
+	switch {
+	case err == nil:
+		// Publish the event to the bus so that the websocket hub of every instance can broadcast it to all connected clients.
+		event := submitEvent{
+			UserQuiz: models.UserQuiz{UserID: userID, QuizID: quizID, Score: score},
+			Version:  a.leaderboardVersion(ctx),
+		}
+		if err := events.Publish(ctx, a.bus, events.SubmitQuiz, event); err != nil {
+			log.Printf("failed to publish event: %v", err)
+		}
+	case errors.Is(err, repositories.ErrShadowBanned):
+		// Answer exactly like a ranked submit so the user cannot tell, but broadcast nothing.
+	case errors.Is(err, repositories.ErrNotJoined):
 		// Only allow submissions for the quiz the user actually joined.
-		http.Error(w, "user did not join quiz", http.StatusBadRequest)
+		return actionResponse{}, &actionError{http.StatusConflict, errCodeNotJoined, err.Error()}
+	case errors.Is(err, repositories.ErrBanned):
+		return actionResponse{}, &actionError{http.StatusForbidden, errCodeUserBanned, err.Error()}
+	default:
+		log.Printf("failed to submit quiz: %v", err)
+		return actionResponse{}, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to submit quiz"}
+	}
+	return actionResponse{Message: fmt.Sprintf("submitted quiz %s", quizID)}, nil
+}
+
+func (a *apiHandlers) leaveQuiz(w http.ResponseWriter, r *http.Request) {
+	resp, failure := a.leave(r.Context(), pkg.GetUserID(r.Context()), mux.Vars(r)["id"])
+	if failure != nil {
+		writeActionError(w, r, failure)
 		return
 	}
+	writeJSON(w, resp)
+}
 
-	if err := a.repo.SubmitQuiz(r.Context(), models.UserQuiz{UserID: userID, QuizID: reqQuizID, Score: req.Score}); err != nil {
-		log.Printf("failed to submit quiz: %v", err)
-		http.Error(w, "failed to submit quiz", http.StatusInternalServerError)
+// leave gives up the user's membership without submitting, so they may join another quiz.
+func (a *apiHandlers) leave(ctx context.Context, userID, quizID string) (actionResponse, *actionError) {
+	if quizID == "" {
+		return actionResponse{}, &actionError{http.StatusBadRequest, errCodeMissingQuizID, "quiz ID is required"}
+	}
+	leaver, ok := a.repo.(repositories.Leaver)
+	if !ok {
+		return actionResponse{}, &actionError{http.StatusNotImplemented, errCodeNotImplemented, "leaving quizzes is not supported by this storage driver"}
+	}
+
+	if err := leaver.LeaveQuiz(ctx, userID, quizID); err != nil {
+		if errors.Is(err, repositories.ErrNotJoined) {
+			return actionResponse{}, &actionError{http.StatusConflict, errCodeNotJoined, err.Error()}
+		}
+		log.Printf("failed to leave quiz: %v", err)
+		return actionResponse{}, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to leave quiz"}
+	}
+	return actionResponse{Message: fmt.Sprintf("left quiz %s", quizID)}, nil
+}
+
+// userRank answers where the user stands on the board named by ?scope=, global by default.
+func (a *apiHandlers) userRank(w http.ResponseWriter, r *http.Request) {
+	ranked, failure := a.rank(r.Context(), pkg.GetUserID(r.Context()), r.URL.Query().Get("scope"))
+	if failure != nil {
+		writeActionError(w, r, failure)
 		return
 	}
+	writeJSON(w, ranked)
+}
 
-	data, _ := json.Marshal(models.UserQuiz{UserID: userID, QuizID: reqQuizID, Score: req.Score})
-	event := eventMessage{
-		Event: submitQuizEvent,
-		Data:  data,
+func (a *apiHandlers) rank(ctx context.Context, userID, rawScope string) (repositories.RankedEntry, *actionError) {
+	scope, err := repositories.ParseScope(rawScope)
+	if err != nil {
+		return repositories.RankedEntry{}, &actionError{http.StatusBadRequest, errCodeInvalidScope, err.Error()}
 	}
-	// Publish the event to the Redis channel so that the websocket hub can broadcast it to all connected clients.
-	if err := a.redis.Publish(r.Context(), eventsChannel, event).Err(); err != nil {
-		log.Printf("failed to publish event: %v", err)
+	ranker, ok := a.repo.(repositories.Ranker)
+	if !ok {
+		return repositories.RankedEntry{}, &actionError{http.StatusNotImplemented, errCodeNotImplemented, "ranks are not supported by this storage driver"}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("submitted quiz %s", reqQuizID)}); err != nil {
-		log.Printf("failed to encode submit quiz response: %v", err)
+	ranked, err := ranker.UserRank(ctx, scope, userID)
+	switch {
+	case errors.Is(err, repositories.ErrNotRanked):
+		return repositories.RankedEntry{}, &actionError{http.StatusNotFound, errCodeNotRanked, err.Error()}
+	case err != nil:
+		log.Printf("failed to rank user: %v", err)
+		return repositories.RankedEntry{}, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to rank user"}
 	}
+	return ranked, nil
 }
 
 func (a *apiHandlers) leaderboard(w http.ResponseWriter, r *http.Request) {
 	var req leaderboardRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		writeInvalidPayload(w, r, err)
 		return
 	}
 
-	scores, err := a.repo.ListUserScores(r.Context(), req.From, req.Limit)
-	if err != nil {
-		log.Printf("failed to list user scores: %v", err)
-		http.Error(w, "failed to list user scores", http.StatusInternalServerError)
+	// The version is read before the board, so a slice is never tagged newer than it is.
+	// Without one (the driver has no versions, or reading it failed) the slice is served
+	// uncached.
+	version := a.leaderboardVersion(r.Context())
+	if version != 0 {
+		etag := leaderboardETag(version, req)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set(leaderboardVersionHeader, strconv.FormatInt(version, 10))
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	body, failure := a.leaderboardSlice(r.Context(), req, version)
+	if failure != nil {
+		writeActionError(w, r, failure)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(scores); err != nil {
+	if _, err := w.Write(body); err != nil {
+		log.Printf("failed to write leaderboard response: %v", err)
+	}
+}
+
+// leaderboardSlice returns the JSON encoding of a slice of the global board, from the cache
+// when version is set.
+func (a *apiHandlers) leaderboardSlice(ctx context.Context, req leaderboardRequest, version int64) ([]byte, *actionError) {
+	key := sliceKey{tenant: pkg.GetTenant(ctx).ID, from: req.From, limit: req.Limit}
+	if version != 0 {
+		if body, ok := a.cache.get(key, version); ok {
+			return body, nil
+		}
+	}
+
+	scores, err := a.repo.ListUserScores(ctx, req.From, req.Limit)
+	if err != nil {
+		log.Printf("failed to list user scores: %v", err)
+		return nil, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to list user scores"}
+	}
+	body, err := json.Marshal(scores)
+	if err != nil {
 		log.Printf("failed to encode leaderboard response: %v", err)
+		return nil, &actionError{http.StatusInternalServerError, errCodeInternal, "failed to encode leaderboard"}
+	}
+	body = append(body, '\n')
+	if version != 0 {
+		a.cache.put(key, version, body)
 	}
+	return body, nil
 }
@@ -1,5 +1,7 @@
 package server
 
+import "github.com/sunary/emu-game/internal/repositories"
+
 type joinQuizRequest struct {
 }
 
@@ -7,6 +9,10 @@ type submitQuizRequest struct {
 	Score float64 `json:"score"`
 }
 
+type submitAttemptsRequest struct {
+	Attempts []repositories.Attempt `json:"attempts"`
+}
+
 type leaderboardRequest struct {
 	From  int64 `json:"from"`
 	Limit int64 `json:"limit"`
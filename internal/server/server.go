@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -10,10 +11,14 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
 
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
 	"github.com/sunary/emu-game/internal/external"
+	"github.com/sunary/emu-game/internal/presence"
 	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
 	"github.com/sunary/emu-game/pkg"
 )
 
@@ -21,6 +26,8 @@ const (
 	wsWriteWait  = 10 * time.Second
 	wsPongWait   = 60 * time.Second
 	wsPingPeriod = (wsPongWait * 9) / 10
+
+	adminGroup = "admin"
 )
 
 var upgrader = websocket.Upgrader{
@@ -29,6 +36,9 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeError(w, r, status, errCodeUpgradeFailed, reason.Error())
+	},
 }
 
 type healthResponse struct {
@@ -37,25 +47,86 @@ type healthResponse struct {
 }
 
 type apiHandlers struct {
-	repo  repositories.Repository
-	redis *redis.Client
-	hub   *wsHub
+	repo      repositories.Repository
+	bus       events.Bus
+	hub       *wsHub
+	snapshots *snapshots.Manager
+	cache     *leaderboardCache
+	presence  presence.Store
 }
 
-func New(ctx context.Context, addr string, repo repositories.Repository, redis *redis.Client) *http.Server {
+func New(ctx context.Context, cfg *configs.Config, backend *bootstrap.Backend) (*http.Server, error) {
 	router := mux.NewRouter()
-	hub := newHub(redis)
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	if err := validSlowConsumer(cfg.Server.Websocket.SlowConsumer); err != nil {
+		return nil, err
+	}
+	tenants, err := newTenantResolver(cfg.Tenancy)
+	if err != nil {
+		return nil, err
+	}
+	tenantList, err := bootstrap.Tenants(cfg.Tenancy)
+	if err != nil {
+		return nil, err
+	}
+	hub := newHub(backend.Bus, cfg.Server.Websocket)
 
-	go hub.subscribe(ctx)
+	ch, err := backend.Bus.Subscribe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe events: %w", err)
+	}
+	if top := cfg.Server.Websocket.DeltaTop; top > 0 {
+		if store, ok := backend.Repo.(repositories.ScopeStore); ok {
+			hub.deltas = newLeaderboardDeltas(hub, store, top)
+			go hub.deltas.run(ctx)
+		}
+	}
+	presenceStore := backend.Presence
+	if presenceStore == nil {
+		presenceStore = presence.NewLocalStore()
+	}
+	hub.presence = newPresenceTracker(presenceStore, backend.Bus, tenantList, cfg.Presence.Heartbeat)
+	go hub.presence.run(ctx)
+	go hub.subscribe(ctx, ch)
 
-	api := &apiHandlers{repo: repo, redis: redis, hub: hub}
+	api := &apiHandlers{
+		repo:      backend.Repo,
+		bus:       backend.Bus,
+		hub:       hub,
+		snapshots: backend.Snapshots,
+		cache:     newLeaderboardCache(cfg.Server.LeaderboardCache),
+		presence:  presenceStore,
+	}
 
-	router.Use(userAuthMiddleware())
+	router.Use(tenantMiddleware(tenants))
+	router.Use(userAuthMiddleware(tenants))
 	router.HandleFunc("/health", healthHandler).Methods(http.MethodGet)
-	router.HandleFunc("/ws", wsHandler(api.hub)).Methods(http.MethodGet)
+	router.HandleFunc("/ws", wsHandler(api, tenants, cfg.Server.Websocket)).Methods(http.MethodGet)
+	router.HandleFunc("/events", sseHandler(api.hub, tenants, cfg.Server)).Methods(http.MethodGet)
 	router.HandleFunc("/user/quiz/{id}/join", api.joinQuiz).Methods(http.MethodPost)
 	router.HandleFunc("/user/quiz/{id}/submit", api.submitQuiz).Methods(http.MethodPost)
+	router.HandleFunc("/user/quiz/{id}/leave", api.leaveQuiz).Methods(http.MethodPost)
+	router.HandleFunc("/user/rank", api.userRank).Methods(http.MethodGet)
+	router.HandleFunc("/user/attempts", api.submitAttempts).Methods(http.MethodPost)
 	router.HandleFunc("/leaderboard", api.leaderboard).Methods(http.MethodGet)
+	router.HandleFunc("/presence/users/{id}", api.userPresence).Methods(http.MethodGet)
+	router.HandleFunc("/presence/{scope}", api.presenceCount).Methods(http.MethodGet)
+	router.HandleFunc("/presence/{scope}/members", api.presenceMembers).Methods(http.MethodGet)
+	router.HandleFunc("/admin/leaderboard/rebuild", api.rebuildLeaderboard).Methods(http.MethodPost)
+	router.HandleFunc("/admin/leaderboard/consistency", api.checkLeaderboard).Methods(http.MethodGet)
+	router.HandleFunc("/admin/snapshots", api.createSnapshot).Methods(http.MethodPost)
+	router.HandleFunc("/admin/snapshots", api.listSnapshots).Methods(http.MethodGet)
+	router.HandleFunc("/admin/snapshots/{id}/restore", api.restoreSnapshot).Methods(http.MethodPost)
+	router.HandleFunc("/admin/moderation/users/{id}/scores", api.deleteUserScores).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/moderation/quizzes/{id}/scores", api.deleteQuizScores).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/moderation/users/{id}/ban", api.banUser).Methods(http.MethodPut)
+	router.HandleFunc("/admin/moderation/users/{id}/ban", api.unbanUser).Methods(http.MethodDelete)
+	router.HandleFunc("/admin/moderation/bans", api.listBans).Methods(http.MethodGet)
+	router.HandleFunc("/admin/privacy/users/{id}", api.exportUserData).Methods(http.MethodGet)
+	router.HandleFunc("/admin/privacy/users/{id}/erase", api.eraseUserData).Methods(http.MethodPost)
+	router.HandleFunc("/admin/metrics/websocket", api.websocketMetrics).Methods(http.MethodGet)
+	router.HandleFunc("/admin/notifications/users/{id}", api.notifyUser).Methods(http.MethodPost)
 
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -65,12 +136,12 @@ func New(ctx context.Context, addr string, repo repositories.Repository, redis *
 	})
 
 	return &http.Server{
-		Addr:              addr,
-		Handler:           router,
+		Addr:              cfg.Server.Addr,
+		Handler:           requestIDMiddleware(router),
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
-	}
+	}, nil
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -81,13 +152,53 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func wsHandler(hub *wsHub) http.HandlerFunc {
+// wsHandler upgrades /ws connections. A token may come with the upgrade (Authorization
+// header, access_token query parameter or bearer subprotocol) or in a first auth message;
+// an invalid token offered with the upgrade rejects it outright.
+func wsHandler(api *apiHandlers, tenants *tenantResolver, cfg configs.WebsocketConfig) http.HandlerFunc {
+	hub := api.hub
+	authWait := cfg.AuthTimeout
+	if authWait <= 0 {
+		authWait = wsAuthWait
+	}
+	upgrader := upgrader
+	upgrader.EnableCompression = cfg.Compression
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		encoding, negotiated := negotiateEncoding(r)
+		client := &wsClient{tenant: pkg.GetTenant(r.Context()).ID, encoding: encoding}
+
+		resumeFrom, resuming, err := resumeParam(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidResume, wsResumeParam+" must be an event sequence number")
+			return
+		}
+
+		var responseHeader http.Header
+		payload, protocol, err := upgradeClaims(r)
+		if err == nil && payload != nil {
+			err = bindClaims(client, tenants, r, payload)
+		}
+		if err != nil {
+			log.Printf("websocket authentication failed: %v", err)
+			status, code, message := authFailure(err)
+			writeError(w, r, status, code, message)
+			return
+		}
+		// A single subprotocol is echoed: the encoding, or "bearer" for clients naming none.
+		switch {
+		case negotiated:
+			responseHeader = http.Header{"Sec-WebSocket-Protocol": {encoding}}
+		case protocol:
+			responseHeader = http.Header{"Sec-WebSocket-Protocol": {wsBearerProtocol}}
+		}
+
+		conn, err := upgrader.Upgrade(w, r, responseHeader)
 		if err != nil {
 			log.Printf("failed to upgrade connection: %v", err)
 			return
 		}
+		client.conn = conn
 
 		// Heartbeat configuration: enforce read limits to prevent memory pressure, and
 		// refresh deadlines whenever we receive a pong so that idle clients are detected.
@@ -98,7 +209,19 @@ func wsHandler(hub *wsHub) http.HandlerFunc {
 			return nil
 		})
 
-		hub.add(conn)
+		if _, userID := client.identity(); userID == "" && cfg.RequireAuth {
+			if !awaitAuth(client, tenants, r, authWait) {
+				conn.Close()
+				return
+			}
+		}
+
+		if resuming {
+			hub.addResuming(r.Context(), client, resumeFrom, ownTopics(client)...)
+		} else {
+			hub.add(client, ownTopics(client)...)
+		}
+		hub.bindUser(r.Context(), client)
 		done := make(chan struct{})
 		// Ping loop ensures clients stay responsive; if a ping write fails the connection is closed.
 		go func() {
@@ -107,8 +230,7 @@ func wsHandler(hub *wsHub) http.HandlerFunc {
 			for {
 				select {
 				case <-ticker.C:
-					conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
-					if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					if err := client.write(websocket.PingMessage, nil); err != nil {
 						log.Printf("ping error: %v", err)
 						conn.Close()
 						return
@@ -121,35 +243,79 @@ func wsHandler(hub *wsHub) http.HandlerFunc {
 
 		defer func() {
 			close(done)
-			hub.remove(conn)
+			hub.remove(client)
 			conn.Close()
 		}()
 
 		for {
-			_, message, err := conn.ReadMessage()
+			messageType, message, err := conn.ReadMessage()
 			if err != nil {
 				log.Printf("read error: %v", err)
 				return
 			}
 
-			log.Printf("received message: %s", string(message))
+			var msg wsMessage
+			if err := decodeMessage(messageType, message, &msg); err != nil {
+				log.Printf("received message: %s", string(message))
+				continue
+			}
+			switch {
+			case msg.Type == wsTypeAuth:
+				handleAuth(hub, client, tenants, r, msg)
+			case msg.Op == wsOpSubscribe, msg.Op == wsOpUnsubscribe:
+				handleSubscription(r.Context(), hub, client, msg)
+			case msg.Op == wsOpResume:
+				hub.resume(r.Context(), client, msg.Seq)
+			case isRPC(msg.Op):
+				handleRPC(r.Context(), api, client, msg)
+			case msg.Op != "":
+				sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeUnknownOp, ID: msg.ID,
+					Message: "op must be subscribe, unsubscribe, resume, join, submit, leave, leaderboard or rank"})
+			default:
+				log.Printf("received message: %s", string(message))
+			}
 		}
 	}
 }
 
-func userAuthMiddleware() mux.MiddlewareFunc {
+// requiresUser reports whether a route is only open to authenticated users: the /user routes,
+// and the presence routes that name users, unlike the public presence counts.
+func requiresUser(path string) bool {
+	if strings.HasPrefix(path, "/user") {
+		return true
+	}
+	rest, ok := strings.CutPrefix(path, "/presence/")
+	return ok && (strings.HasPrefix(rest, "users/") || strings.HasSuffix(rest, "/members"))
+}
+
+func userAuthMiddleware(tenants *tenantResolver) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !strings.HasPrefix(r.URL.Path, "/user") {
+			isAdmin := strings.HasPrefix(r.URL.Path, "/admin")
+			if !requiresUser(r.URL.Path) && !isAdmin {
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			payload, err := external.ValidateJWT(r.Header.Get("Authorization"))
 			if err != nil {
-				status := http.StatusUnauthorized
 				log.Printf("jwt validation failed: %v", err)
-				http.Error(w, http.StatusText(status), status)
+				status, code, message := authFailure(err)
+				writeError(w, r, status, code, message)
+				return
+			}
+
+			// Admin routes additionally require the admin group claim.
+			if isAdmin && !payload.HasGroup(adminGroup) {
+				writeError(w, r, http.StatusForbidden, errCodeAdminRequired, "admin group is required")
+				return
+			}
+
+			r, ok := tenants.claimTenant(r, payload)
+			if !ok {
+				log.Printf("tenant claim %q rejected for host %s", payload.Tenant, r.Host)
+				writeErrorDetails(w, r, http.StatusForbidden, errCodeTenantMismatch, "token tenant does not match the request host",
+					map[string]string{"tenant": payload.Tenant})
 				return
 			}
 
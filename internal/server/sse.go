@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// sseTopicParam names a topic to follow and may be repeated: /events?topic=quiz:42&topic=user:7.
+	// Without it a stream follows the global topic.
+	sseTopicParam = "topic"
+	// sseLastEventID is sent by browsers reconnecting an EventSource, with the id of the last
+	// event they received.
+	sseLastEventID = "Last-Event-ID"
+
+	// sseKeepAlive is the default interval between keep-alive comments.
+	sseKeepAlive = 15 * time.Second
+)
+
+var errSSEClosed = errors.New("event stream closed")
+
+// sseStream writes hub frames to a text/event-stream response. Frames with a sequence number
+// carry it as their id, so a reconnecting EventSource resumes after it with Last-Event-ID.
+// Its methods are called with the client's writeMu held.
+type sseStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+	// closed is set once the handler returns, after which the response must not be written.
+	closed bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	return &sseStream{w: w, rc: http.NewResponseController(w), done: make(chan struct{})}
+}
+
+// event writes data as one event, one data line per line of data.
+func (s *sseStream) event(seq uint64, data []byte) error {
+	var buf bytes.Buffer
+	if seq != 0 {
+		buf.WriteString("id: ")
+		buf.WriteString(strconv.FormatUint(seq, 10))
+		buf.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return s.flush(buf.Bytes())
+}
+
+// comment writes a line clients ignore, keeping the stream alive through proxies.
+func (s *sseStream) comment(text string) error {
+	return s.flush([]byte(": " + text + "\n\n"))
+}
+
+func (s *sseStream) flush(frame []byte) error {
+	if s.closed {
+		return errSSEClosed
+	}
+	// Each write gets its own deadline, in place of the server's WriteTimeout, so the stream
+	// outlives it while a stalled client is still dropped.
+	if err := s.rc.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// close asks the handler to end the stream; it never blocks.
+func (s *sseStream) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// sseResume reads where a stream resumes from: the Last-Event-ID header, or resume_from as
+// on /ws. ok is false when neither is present.
+func sseResume(r *http.Request) (seq uint64, ok bool, err error) {
+	if raw := r.Header.Get(sseLastEventID); raw != "" {
+		seq, err = strconv.ParseUint(raw, 10, 64)
+		return seq, err == nil, err
+	}
+	return resumeParam(r)
+}
+
+// sseTopics checks the topics a stream asks for, as a websocket subscription would be. It
+// returns the leaderboard topics apart, as they are followed once the stream is registered.
+func sseTopics(w http.ResponseWriter, r *http.Request, hub *wsHub, client *wsClient) (topics, leaderboards []string, ok bool) {
+	requested := r.URL.Query()[sseTopicParam]
+	if len(requested) == 0 {
+		requested = []string{wsTopicGlobal}
+	}
+
+	seen := make(map[string]struct{}, len(requested))
+	for _, topic := range requested {
+		if _, ok := seen[topic]; ok {
+			continue
+		}
+		seen[topic] = struct{}{}
+
+		switch _, leaderboard := leaderboardScope(topic); {
+		case !validTopic(topic):
+			writeErrorDetails(w, r, http.StatusBadRequest, errCodeInvalidTopic, invalidTopicMessage, map[string]string{"topic": topic})
+			return nil, nil, false
+		case !mayFollow(client, topic):
+			writeErrorDetails(w, r, http.StatusForbidden, errCodeForbiddenTopic, forbiddenTopicMessage, map[string]string{"topic": topic})
+			return nil, nil, false
+		case leaderboard && hub.deltas == nil:
+			writeErrorDetails(w, r, http.StatusNotImplemented, errCodeNotImplemented, "leaderboard deltas are not enabled", map[string]string{"topic": topic})
+			return nil, nil, false
+		case leaderboard:
+			leaderboards = append(leaderboards, topic)
+		default:
+			topics = append(topics, topic)
+		}
+	}
+	if len(seen) > wsMaxTopics {
+		writeError(w, r, http.StatusBadRequest, errCodeTooManyTopics, "a stream follows at most "+strconv.Itoa(wsMaxTopics)+" topics")
+		return nil, nil, false
+	}
+	return topics, leaderboards, true
+}
+
+// sseHandler streams the events of /ws as Server-Sent Events, for clients and proxies that
+// cannot hold a websocket. A stream is a hub connection like any other, fixed to the topics
+// of its query and to the user of a token offered with the request (Authorization header or
+// access_token query parameter), as streams cannot send messages.
+func sseHandler(hub *wsHub, tenants *tenantResolver, cfg configs.Server) http.HandlerFunc {
+	keepAlive := cfg.SSE.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = sseKeepAlive
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := &wsClient{tenant: pkg.GetTenant(r.Context()).ID}
+
+		resumeFrom, resuming, err := sseResume(r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, errCodeInvalidResume, sseLastEventID+" and "+wsResumeParam+" must be event sequence numbers")
+			return
+		}
+
+		payload, _, err := upgradeClaims(r)
+		if err == nil && payload != nil {
+			err = bindClaims(client, tenants, r, payload)
+		}
+		if err != nil {
+			log.Printf("event stream authentication failed: %v", err)
+			status, code, message := authFailure(err)
+			writeError(w, r, status, code, message)
+			return
+		}
+		if _, userID := client.identity(); userID == "" && cfg.Websocket.RequireAuth {
+			writeError(w, r, http.StatusUnauthorized, errCodeMissingToken, "authorization token is required")
+			return
+		}
+
+		topics, leaderboards, ok := sseTopics(w, r, hub, client)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Ask buffering proxies such as nginx to pass events through as they come.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		stream := newSSEStream(w)
+		client.stream = stream
+		defer func() {
+			client.writeMu.Lock()
+			stream.closed = true
+			client.writeMu.Unlock()
+			hub.remove(client)
+		}()
+
+		if resuming {
+			hub.addResuming(r.Context(), client, resumeFrom, topics...)
+		} else {
+			hub.add(client, topics...)
+		}
+		hub.bindUser(r.Context(), client)
+		for _, topic := range leaderboards {
+			scope, _ := leaderboardScope(topic)
+			hub.deltas.subscribe(r.Context(), client, topic, scope)
+		}
+
+		// Tell the client it is registered: events published from now on reach it.
+		client.writeMu.Lock()
+		err = stream.comment("connected")
+		client.writeMu.Unlock()
+		if err != nil {
+			log.Printf("failed to open event stream: %v", err)
+			return
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-stream.done:
+				return
+			case <-ticker.C:
+				client.writeMu.Lock()
+				err := stream.comment("keep-alive")
+				client.writeMu.Unlock()
+				if err != nil {
+					log.Printf("keep-alive error: %v", err)
+					return
+				}
+			}
+		}
+	}
+}
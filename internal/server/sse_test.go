@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// sseFrame is one event of a stream, or a comment when Comment is set.
+type sseFrame struct {
+	ID      string
+	Data    string
+	Comment string
+}
+
+func openSSE(t *testing.T, ts *httptest.Server, query string, header http.Header) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events?"+query, nil)
+	require.NoError(t, err)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sseFrame {
+	t.Helper()
+
+	var frame sseFrame
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return frame
+		case strings.HasPrefix(line, ": "):
+			frame.Comment = strings.TrimPrefix(line, ": ")
+		case strings.HasPrefix(line, "id: "):
+			frame.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			frame.Data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// readSSEEvent skips comments up to the next event.
+func readSSEEvent(t *testing.T, r *bufio.Reader) sseFrame {
+	t.Helper()
+
+	for {
+		if frame := readSSE(t, r); frame.Comment == "" {
+			return frame
+		}
+	}
+}
+
+func TestSSEStreamsSubscribedTopics(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	resp, stream := openSSE(t, ts, "topic=quiz:quiz-1", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, sseFrame{Comment: "connected"}, readSSE(t, stream))
+
+	submitScore(t, ts, "user-1", "quiz-2", 5)
+	submitScore(t, ts, "user-1", "quiz-1", 10)
+
+	frame := readSSEEvent(t, stream)
+	require.Equal(t, "2", frame.ID, "the event id is its sequence number")
+	var event topicEvent
+	require.NoError(t, json.Unmarshal([]byte(frame.Data), &event))
+	require.Equal(t, "quiz-1", event.QuizID, "quiz-2 traffic is never streamed to quiz-1 followers")
+}
+
+func TestSSEResumesFromLastEventID(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	for _, quiz := range []string{"quiz-1", "quiz-2", "quiz-3"} {
+		submitScore(t, ts, "user-1", quiz, 1)
+	}
+
+	_, stream := openSSE(t, ts, "", http.Header{sseLastEventID: {"1"}})
+	for _, want := range []string{"2", "3"} {
+		require.Equal(t, want, readSSEEvent(t, stream).ID)
+	}
+	resumed := readSSEEvent(t, stream)
+	require.Empty(t, resumed.ID, "control frames carry no id")
+	require.JSONEq(t, `{"type":"resumed","seq":3}`, resumed.Data)
+
+	submitScore(t, ts, "user-1", "quiz-4", 1)
+	require.Equal(t, "4", readSSEEvent(t, stream).ID, "live events continue after the replay")
+}
+
+func TestSSEKeepAlive(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{Server: configs.Server{SSE: configs.SSEConfig{KeepAlive: 20 * time.Millisecond}}}, &mockRepository{})
+
+	_, stream := openSSE(t, ts, "", nil)
+	readSSE(t, stream)
+	require.Equal(t, sseFrame{Comment: "keep-alive"}, readSSE(t, stream))
+}
+
+func TestSSEUserStreams(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+
+	resp, stream := openSSE(t, ts, "topic=user:user-1", http.Header{"Authorization": {"Bearer " + token}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	readSSE(t, stream)
+
+	require.Equal(t, http.StatusOK, notify(t, ts, "user-1", `{"kind":"overtaken"}`).StatusCode)
+	var frame notificationFrame
+	require.NoError(t, json.Unmarshal([]byte(readSSEEvent(t, stream).Data), &frame))
+	require.Equal(t, wsTypeNotification, frame.Type)
+	require.Equal(t, "overtaken", frame.Kind)
+
+	submitScore(t, ts, "user-1", "quiz-1", 3)
+	var event topicEvent
+	require.NoError(t, json.Unmarshal([]byte(readSSEEvent(t, stream).Data), &event))
+	require.Equal(t, "user-1", event.UserID)
+}
+
+func TestSSERejectsBadRequests(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	cases := []struct {
+		name   string
+		query  string
+		header http.Header
+		status int
+		code   errorCode
+	}{
+		{name: "invalid topic", query: "topic=weekly", status: http.StatusBadRequest, code: errCodeInvalidTopic},
+		{name: "foreign user topic", query: "topic=user:user-2", status: http.StatusForbidden, code: errCodeForbiddenTopic},
+		{name: "anonymous presence topic", query: "topic=presence:global", status: http.StatusForbidden, code: errCodeForbiddenTopic},
+		{name: "anonymous quiz presence topic", query: "topic=presence:quiz:quiz-1", status: http.StatusForbidden, code: errCodeForbiddenTopic},
+		{name: "bad last event id", header: http.Header{sseLastEventID: {"latest"}}, status: http.StatusBadRequest, code: errCodeInvalidResume},
+		{name: "bad token", query: "access_token=nope", status: http.StatusUnauthorized, code: errCodeInvalidToken},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := openSSE(t, ts, tc.query, tc.header)
+			require.Equal(t, tc.status, resp.StatusCode)
+			var body errorResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.Equal(t, tc.code, body.Error.Code)
+		})
+	}
+
+	authed := newTestServer(t, &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{RequireAuth: true}}}, &mockRepository{})
+	resp, _ := openSSE(t, authed, "", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "require_auth applies to streams too")
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/pkg"
+)
+
+type tenantResolver struct {
+	enabled bool
+	hosts   map[string]string
+	tenants map[string]configs.TenantConfig
+}
+
+// newTenantResolver rejects configured tenant IDs that would be unsafe in keys and channels.
+func newTenantResolver(cfg configs.TenancyConfig) (*tenantResolver, error) {
+	resolver := &tenantResolver{
+		enabled: cfg.Enabled,
+		hosts:   make(map[string]string),
+		tenants: cfg.Tenants,
+	}
+	for id, tenant := range cfg.Tenants {
+		if !pkg.ValidTenantID(id) {
+			return nil, fmt.Errorf("invalid tenant ID %q", id)
+		}
+		for _, host := range tenant.Hosts {
+			resolver.hosts[strings.ToLower(host)] = id
+		}
+	}
+	return resolver, nil
+}
+
+// fromHost maps the request Host header to a configured tenant, ignoring any port.
+func (t *tenantResolver) fromHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return t.hosts[strings.ToLower(host)]
+}
+
+// tenant builds the request tenant with its configuration overrides applied.
+func (t *tenantResolver) tenant(id string) pkg.Tenant {
+	cfg := t.tenants[id]
+	return pkg.Tenant{
+		ID:               id,
+		MembershipTTL:    cfg.MembershipTTL,
+		LeaderboardLimit: cfg.LeaderboardLimit,
+	}
+}
+
+// tenantMiddleware resolves the tenant from the Host header; authenticated routes may refine
+// it from the JWT claim in userAuthMiddleware.
+func tenantMiddleware(tenants *tenantResolver) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tenants.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := pkg.WithTenant(r.Context(), tenants.tenant(tenants.fromHost(r.Host)))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// claimTenant applies the tenant claim of an authenticated request. A claim that is malformed
+// or disagrees with the tenant of the Host header is rejected.
+func (t *tenantResolver) claimTenant(r *http.Request, payload *pkg.Payload) (*http.Request, bool) {
+	if !t.enabled || payload.Tenant == "" {
+		return r, true
+	}
+
+	if !pkg.ValidTenantID(payload.Tenant) {
+		return r, false
+	}
+	if host := pkg.GetTenant(r.Context()).ID; host != "" && host != payload.Tenant {
+		return r, false
+	}
+
+	return r.WithContext(pkg.WithTenant(r.Context(), t.tenant(payload.Tenant))), true
+}
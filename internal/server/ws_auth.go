@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sunary/emu-game/internal/external"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// wsAuthWait is how long a connection may take to send its auth message when
+	// anonymous access is disabled and no token came with the upgrade.
+	wsAuthWait = 10 * time.Second
+
+	wsAccessTokenParam = "access_token"
+	// wsBearerProtocol is offered ahead of the token by browsers, which cannot set headers
+	// on an upgrade: new WebSocket(url, ["bearer", token]), or ["msgpack", "bearer", token]
+	// along with an encoding. The token is never echoed back.
+	wsBearerProtocol = "bearer"
+)
+
+// Control messages exchanged on a websocket. Broadcast events keep their own format.
+const (
+	wsTypeAuth          = "auth"
+	wsTypeAuthenticated = "authenticated"
+	wsTypeError         = "error"
+)
+
+type wsMessage struct {
+	Type    string    `json:"type,omitempty"`
+	Op      string    `json:"op,omitempty"`
+	Topic   string    `json:"topic,omitempty"`
+	Seq     uint64    `json:"seq,omitempty"`
+	Token   string    `json:"token,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Code    errorCode `json:"code,omitempty"`
+	Message string    `json:"message,omitempty"`
+	// ID correlates an RPC request with its result or error; see handleRPC.
+	ID     string    `json:"id,omitempty"`
+	Params *wsParams `json:"params,omitempty"`
+	Result any       `json:"result,omitempty"`
+}
+
+// errWSTenantMismatch is reported when a token's tenant claim disagrees with the host.
+var errWSTenantMismatch = errors.New("token tenant does not match the request host")
+
+// upgradeClaims validates a token offered with an upgrade request, through the Authorization
+// header, the access_token query parameter or the bearer subprotocol. It returns nil claims
+// when no token was offered, and whether the token came through the subprotocol.
+func upgradeClaims(r *http.Request) (*pkg.Payload, bool, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		payload, err := external.ValidateJWT(header)
+		return payload, false, err
+	}
+	if token := r.URL.Query().Get(wsAccessTokenParam); token != "" {
+		payload, err := external.ValidateToken(token)
+		return payload, false, err
+	}
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == wsBearerProtocol {
+			payload, err := external.ValidateToken(protocols[i+1])
+			return payload, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// bindClaims binds a client to the user of validated claims and the tenant they were
+// accepted for on the connection opened with r.
+func bindClaims(client *wsClient, tenants *tenantResolver, r *http.Request, payload *pkg.Payload) error {
+	claimed, ok := tenants.claimTenant(r, payload)
+	if !ok {
+		log.Printf("tenant claim %q rejected for websocket on host %s", payload.Tenant, r.Host)
+		return errWSTenantMismatch
+	}
+	client.authenticate(pkg.GetTenant(claimed.Context()).ID, payload)
+	return nil
+}
+
+// authenticateMessage validates the token of an auth message and binds the client to it.
+func authenticateMessage(client *wsClient, tenants *tenantResolver, r *http.Request, msg wsMessage) error {
+	payload, err := external.ValidateToken(msg.Token)
+	if err != nil {
+		return err
+	}
+	return bindClaims(client, tenants, r, payload)
+}
+
+// authFailure maps a token error to its status, code and message. Signature and expiry
+// failures are not distinguished to avoid leaking token details.
+func authFailure(err error) (int, errorCode, string) {
+	switch {
+	case errors.Is(err, external.ErrEmptyToken):
+		return http.StatusUnauthorized, errCodeMissingToken, "authorization token is required"
+	case errors.Is(err, external.ErrBadFormat):
+		return http.StatusUnauthorized, errCodeMalformedToken, err.Error()
+	case errors.Is(err, errWSTenantMismatch):
+		return http.StatusForbidden, errCodeTenantMismatch, err.Error()
+	default:
+		return http.StatusUnauthorized, errCodeInvalidToken, "authorization token is invalid or expired"
+	}
+}
+
+// awaitAuth reads the first message of a connection that must authenticate, giving up after
+// timeout. On failure the client is told why and the connection is closed.
+func awaitAuth(client *wsClient, tenants *tenantResolver, r *http.Request, timeout time.Duration) bool {
+	client.conn.SetReadDeadline(time.Now().Add(timeout))
+	messageType, raw, err := client.conn.ReadMessage()
+	if err != nil {
+		var netErr interface{ Timeout() bool }
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			rejectWS(client, errCodeAuthTimeout, "authentication timed out")
+		}
+		return false
+	}
+
+	var msg wsMessage
+	if err := decodeMessage(messageType, raw, &msg); err != nil || msg.Type != wsTypeAuth {
+		rejectWS(client, errCodeMissingToken, "the first message must authenticate")
+		return false
+	}
+	if err := authenticateMessage(client, tenants, r, msg); err != nil {
+		log.Printf("websocket authentication failed: %v", err)
+		_, code, message := authFailure(err)
+		rejectWS(client, code, message)
+		return false
+	}
+
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	return sendAuthenticated(client)
+}
+
+// handleAuth authenticates an anonymous connection that sends an auth message later on and
+// subscribes it to its user's topic and notifications. A connection's identity is bound once; a failed
+// attempt leaves it anonymous.
+func handleAuth(hub *wsHub, client *wsClient, tenants *tenantResolver, r *http.Request, msg wsMessage) {
+	if _, userID := client.identity(); userID != "" {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeAlreadyAuthenticated, Message: "connection is already authenticated"})
+		return
+	}
+
+	if err := authenticateMessage(client, tenants, r, msg); err != nil {
+		log.Printf("websocket authentication failed: %v", err)
+		_, code, message := authFailure(err)
+		sendWS(client, wsMessage{Type: wsTypeError, Code: code, Message: message})
+		return
+	}
+	if sendAuthenticated(client) {
+		hub.followUser(client)
+		hub.bindUser(r.Context(), client)
+	}
+}
+
+func sendAuthenticated(client *wsClient) bool {
+	_, userID := client.identity()
+	return sendWS(client, wsMessage{Type: wsTypeAuthenticated, UserID: userID})
+}
+
+func sendWS(client *wsClient, msg wsMessage) bool {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("failed to encode websocket message: %v", err)
+		return false
+	}
+	if err := client.writeFrame(wsOutbound{data: raw}); err != nil {
+		log.Printf("failed to send websocket message: %v", err)
+		return false
+	}
+	return true
+}
+
+// rejectWS reports an authentication failure and closes the connection as a policy violation.
+func rejectWS(client *wsClient, code errorCode, message string) {
+	sendWS(client, wsMessage{Type: wsTypeError, Code: code, Message: message})
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	client.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, string(code)), time.Now().Add(wsWriteWait))
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encodings a websocket client may negotiate by offering them as a subprotocol, e.g.
+// new WebSocket(url, ["msgpack"]). Frames keep the shape of their JSON form either way.
+const (
+	wsEncodingJSON    = "json"
+	wsEncodingMsgpack = "msgpack"
+
+	// wsCompressMin is the smallest frame worth compressing when permessage-deflate was negotiated.
+	wsCompressMin = 256
+)
+
+// negotiateEncoding picks the first encoding among the subprotocols a client offered. offered
+// is false when it named none, in which case frames are JSON.
+func negotiateEncoding(r *http.Request) (encoding string, offered bool) {
+	for _, protocol := range websocket.Subprotocols(r) {
+		switch protocol {
+		case wsEncodingJSON, wsEncodingMsgpack:
+			return protocol, true
+		}
+	}
+	return wsEncodingJSON, false
+}
+
+// encodeFrame turns the JSON form of a frame into the message sent to a client of encoding.
+func encodeFrame(encoding string, data []byte) (messageType int, payload []byte, err error) {
+	if encoding != wsEncodingMsgpack {
+		return websocket.TextMessage, data, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return 0, nil, fmt.Errorf("decode frame: %w", err)
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(compactNumbers(value)); err != nil {
+		return 0, nil, fmt.Errorf("encode frame: %w", err)
+	}
+	return websocket.BinaryMessage, buf.Bytes(), nil
+}
+
+// compactNumbers replaces the JSON numbers of a decoded value with integers where they are
+// whole, so sequence numbers and versions keep their precision.
+func compactNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = compactNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = compactNumbers(item)
+		}
+	}
+	return value
+}
+
+// decodeMessage reads a control message, JSON in text frames and MessagePack in binary ones.
+func decodeMessage(messageType int, data []byte, msg *wsMessage) error {
+	if messageType != websocket.BinaryMessage {
+		return json.Unmarshal(data, msg)
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(msg)
+}
+
+// wsFrames caches the frames of one message per encoding. A message broadcast to many
+// connections is encoded, and compressed, once per encoding rather than once per connection.
+type wsFrames struct {
+	mu         sync.Mutex
+	byEncoding map[string]preparedFrame
+}
+
+type preparedFrame struct {
+	message *websocket.PreparedMessage
+	size    int
+}
+
+func (f *wsFrames) prepare(encoding string, data []byte) (preparedFrame, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if frame, ok := f.byEncoding[encoding]; ok {
+		return frame, nil
+	}
+
+	messageType, payload, err := encodeFrame(encoding, data)
+	if err != nil {
+		return preparedFrame{}, err
+	}
+	// The prepared message compresses lazily, once for all connections that negotiated it.
+	message, err := websocket.NewPreparedMessage(messageType, payload)
+	if err != nil {
+		return preparedFrame{}, err
+	}
+	if f.byEncoding == nil {
+		f.byEncoding = make(map[string]preparedFrame)
+	}
+	frame := preparedFrame{message: message, size: len(payload)}
+	f.byEncoding[encoding] = frame
+	return frame, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func TestEncodeFrame(t *testing.T) {
+	data := []byte(`{"seq":9007199254740993,"score":1.5,"user_id":"user-1","entries":[{"rank":1}]}`)
+
+	messageType, payload, err := encodeFrame(wsEncodingJSON, data)
+	require.NoError(t, err)
+	require.Equal(t, websocket.TextMessage, messageType)
+	require.Equal(t, data, payload)
+
+	messageType, payload, err = encodeFrame(wsEncodingMsgpack, data)
+	require.NoError(t, err)
+	require.Equal(t, websocket.BinaryMessage, messageType)
+	require.Less(t, len(payload), len(data))
+
+	var decoded map[string]any
+	require.NoError(t, msgpack.Unmarshal(payload, &decoded))
+	require.EqualValues(t, uint64(9007199254740993), decoded["seq"], "whole numbers keep their precision")
+	require.EqualValues(t, 1.5, decoded["score"])
+	require.Equal(t, "user-1", decoded["user_id"])
+	require.EqualValues(t, 1, decoded["entries"].([]any)[0].(map[string]any)["rank"])
+}
+
+func TestFramesAreEncodedOncePerEncoding(t *testing.T) {
+	frames := &wsFrames{}
+	data := []byte(`{"score":1}`)
+
+	first, err := frames.prepare(wsEncodingMsgpack, data)
+	require.NoError(t, err)
+	again, err := frames.prepare(wsEncodingMsgpack, data)
+	require.NoError(t, err)
+	require.Same(t, first.message, again.message, "recipients of a broadcast share the encoded frame")
+
+	text, err := frames.prepare(wsEncodingJSON, data)
+	require.NoError(t, err)
+	require.NotSame(t, first.message, text.message)
+}
+
+func dialEncoded(t *testing.T, dialer *websocket.Dialer, url string, protocols ...string) *websocket.Conn {
+	t.Helper()
+
+	d := *dialer
+	d.Subprotocols = protocols
+	conn, resp, err := d.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.Equal(t, protocols[0], resp.Header.Get("Sec-WebSocket-Protocol"))
+	return conn
+}
+
+func readMsgpack(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, websocket.BinaryMessage, messageType)
+	var frame map[string]any
+	require.NoError(t, msgpack.Unmarshal(data, &frame))
+	return frame
+}
+
+func TestWebsocketMsgpackEncoding(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	conn := dialEncoded(t, websocket.DefaultDialer, ts.URL, wsEncodingMsgpack)
+	text := dialWS(t, ts, "")
+	subscribe(t, text, wsTopicGlobal)
+
+	op, err := msgpack.Marshal(map[string]any{"op": wsOpSubscribe, "topic": "quiz:quiz-1"})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, op))
+	require.Equal(t, map[string]any{"type": wsTypeSubscribed, "topic": "quiz:quiz-1"}, readMsgpack(t, conn))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"op":"unsubscribe","topic":"quiz:quiz-2"}`)),
+		"text frames are still read as JSON")
+	require.Equal(t, map[string]any{"type": wsTypeUnsubscribed, "topic": "quiz:quiz-2"}, readMsgpack(t, conn))
+
+	submitScore(t, ts, "user-1", "quiz-1", 10)
+	event := readMsgpack(t, conn)
+	require.EqualValues(t, 1, event["seq"])
+	require.Equal(t, "quiz-1", event["quiz_id"])
+
+	var same topicEvent
+	text.SetReadDeadline(time.Now().Add(time.Second))
+	require.NoError(t, text.ReadJSON(&same), "JSON clients receive the same event as text")
+	require.Equal(t, "quiz-1", same.QuizID)
+}
+
+func TestWebsocketEncodingWithBearerProtocol(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+
+	conn := dialEncoded(t, websocket.DefaultDialer, ts.URL, wsEncodingMsgpack, wsBearerProtocol, token)
+	// The acknowledgement tells the connection is registered with the hub.
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"op":"subscribe","topic":"user:user-1"}`)))
+	require.Equal(t, map[string]any{"type": wsTypeSubscribed, "topic": "user:user-1"}, readMsgpack(t, conn),
+		"the token after bearer authenticated the connection")
+
+	require.Equal(t, http.StatusOK, notify(t, ts, "user-1", `{"kind":"overtaken"}`).StatusCode)
+	frame := readMsgpack(t, conn)
+	require.Equal(t, wsTypeNotification, frame["type"])
+	require.Equal(t, "overtaken", frame["kind"])
+}
+
+func TestWebsocketCompression(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{Compression: true}}}, &mockRepository{})
+
+	dialer := &websocket.Dialer{EnableCompression: true}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	subscribe(t, conn, wsTopicGlobal)
+
+	submitScore(t, ts, "user-1", strings.Repeat("q", 300), 10)
+	var event topicEvent
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, strings.Repeat("q", 300), event.QuizID)
+}
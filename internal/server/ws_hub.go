@@ -1,90 +1,344 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
-)
 
-const (
-	eventsChannel   = "emu-game:events"
-	submitQuizEvent = "submit_quiz_event"
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/pkg"
 )
 
+// wsHub routes events to connections. Each connection has a bounded queue drained by its
+// own writer, so a slow client only ever delays itself.
 type wsHub struct {
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+	// topics indexes the subscribers of each topic, across tenants.
+	topics map[string]map[*wsClient]struct{}
+	bus    events.Bus
+
+	queueSize int
+	policy    string
+	stats     wsStats
+
+	// deltas follows leaderboard topics; nil when leaderboard deltas are disabled.
+	deltas *leaderboardDeltas
+	// presence learns which users and quizzes the hub's connections hold; nil when untracked.
+	presence *presenceTracker
+}
+
+// wsClient is one websocket connection or SSE stream and the identity it authenticated as,
+// if any.
+type wsClient struct {
+	conn *websocket.Conn
+	// stream replaces conn for Server-Sent Events clients.
+	stream *sseStream
+	// encoding is the frame encoding the websocket negotiated; see negotiateEncoding.
+	encoding string
+	// writeMu serializes writes, since a websocket allows a single concurrent writer.
+	writeMu sync.Mutex
+
 	mu     sync.RWMutex
-	conns  map[*websocket.Conn]struct{}
-	pubsub *redis.PubSub
+	tenant string
+	// userID and claims stay empty while the connection is anonymous.
+	userID string
+	claims *pkg.Payload
+
+	// subscriptions and send are guarded by the hub's mu, which keeps them in step with
+	// wsHub.topics; send is closed once the connection leaves the hub.
+	subscriptions map[string]struct{}
+	send          chan wsOutbound
+
+	// replayMu is held while an event is written, and for the whole of a replay so live
+	// events wait behind it. delivered is the highest sequence written so far.
+	replayMu  sync.Mutex
+	delivered uint64
+}
+
+// wsOutbound is the JSON form of an event frame and its sequence number, 0 when the event
+// has none. frames, when set, shares the encoded frame between the recipients of a broadcast.
+type wsOutbound struct {
+	seq    uint64
+	data   []byte
+	frames *wsFrames
+}
+
+// newHub buffers cfg.SendQueue events per connection and applies cfg.SlowConsumer once a
+// queue is full, defaulting to 64 events and drop_oldest.
+func newHub(bus events.Bus, cfg configs.WebsocketConfig) *wsHub {
+	hub := &wsHub{
+		clients:   make(map[*wsClient]struct{}),
+		topics:    make(map[string]map[*wsClient]struct{}),
+		bus:       bus,
+		queueSize: cfg.SendQueue,
+		policy:    cfg.SlowConsumer,
+	}
+	if hub.queueSize <= 0 {
+		hub.queueSize = wsSendQueue
+	}
+	if hub.policy == "" {
+		hub.policy = wsDropOldest
+	}
+	return hub
+}
+
+// identity returns the tenant the connection receives events of and its user, if any.
+func (c *wsClient) identity() (tenant, userID string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tenant, c.userID
+}
+
+// authenticate binds the connection to a user and the tenant their token was accepted for.
+func (c *wsClient) authenticate(tenant string, claims *pkg.Payload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tenant, c.userID, c.claims = tenant, claims.Sub, claims
 }
 
-func newHub(redis *redis.Client) *wsHub {
-	return &wsHub{
-		conns:  make(map[*websocket.Conn]struct{}),
-		pubsub: redis.Subscribe(context.Background(), eventsChannel),
+func (c *wsClient) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// writeFrame writes an event or control frame in the connection's encoding. Frames too small
+// to gain from it are sent uncompressed.
+func (c *wsClient) writeFrame(message wsOutbound) error {
+	if c.stream != nil {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		return c.stream.event(message.seq, message.data)
+	}
+
+	frames := message.frames
+	if frames == nil {
+		frames = &wsFrames{}
+	}
+	frame, err := frames.prepare(c.encoding, message.data)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	c.conn.EnableWriteCompression(frame.size >= wsCompressMin)
+	return c.conn.WritePreparedMessage(frame.message)
+}
+
+// close ends the connection without waiting; its handler then removes it from the hub.
+func (c *wsClient) close() {
+	if c.stream != nil {
+		c.stream.close()
+		return
+	}
+	c.conn.Close()
+}
+
+// add registers a connection, subscribed to topics and, once authenticated, to its user's
+// notifications, and starts its writer.
+func (h *wsHub) add(client *wsClient, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client] = struct{}{}
+	client.subscriptions = make(map[string]struct{})
+	client.send = make(chan wsOutbound, h.queueSize)
+	for _, topic := range topics {
+		h.addTopicLocked(client, topic)
+	}
+	if _, userID := client.identity(); userID != "" {
+		h.addTopicLocked(client, wsTopicNotify+userID)
+	}
+	go client.writePump()
+}
+
+// remove unregisters a connection and stops its writer; removing it twice is harmless.
+func (h *wsHub) remove(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+	for topic := range client.subscriptions {
+		h.removeTopicLocked(client, topic)
 	}
+	close(client.send)
 }
 
-func (h *wsHub) add(conn *websocket.Conn) {
+// addTopic subscribes a connection to a topic. It reports false when the connection already
+// holds wsMaxTopics other subscriptions; subscribing twice is not an error.
+func (h *wsHub) addTopic(client *wsClient, topic string) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.conns[conn] = struct{}{}
+	if _, ok := client.subscriptions[topic]; ok {
+		return true
+	}
+	if len(client.subscriptions) >= wsMaxTopics {
+		return false
+	}
+	h.addTopicLocked(client, topic)
+	return true
 }
 
-func (h *wsHub) remove(conn *websocket.Conn) {
+func (h *wsHub) removeTopic(client *wsClient, topic string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	delete(h.conns, conn)
+	h.removeTopicLocked(client, topic)
+}
+
+func (h *wsHub) addTopicLocked(client *wsClient, topic string) {
+	if _, ok := client.subscriptions[topic]; ok {
+		return
+	}
+	subscribers, ok := h.topics[topic]
+	if !ok {
+		subscribers = make(map[*wsClient]struct{})
+		h.topics[topic] = subscribers
+	}
+	subscribers[client] = struct{}{}
+	client.subscriptions[topic] = struct{}{}
+	h.trackPresence(client, topic, 1)
 }
 
-func (h *wsHub) broadcast(message []byte) {
+func (h *wsHub) removeTopicLocked(client *wsClient, topic string) {
+	if _, ok := client.subscriptions[topic]; !ok {
+		return
+	}
+	h.trackPresence(client, topic, -1)
+	delete(client.subscriptions, topic)
+	subscribers := h.topics[topic]
+	delete(subscribers, client)
+	if len(subscribers) == 0 {
+		delete(h.topics, topic)
+	}
+}
+
+// broadcast queues a message once for every connection of the tenant subscribed to any of
+// topics, or for all of the tenant's connections when topics is nil, and returns how many
+// connections it was queued for.
+func (h *wsHub) broadcast(tenant string, topics []string, message wsOutbound) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for conn := range h.conns {
-		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
-		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Printf("failed to send broadcast: %v", err)
-			conn.Close()
-			go h.remove(conn)
+	if message.frames == nil {
+		message.frames = &wsFrames{}
+	}
+
+	recipients := h.clients
+	if topics != nil {
+		recipients = make(map[*wsClient]struct{})
+		for _, topic := range topics {
+			for client := range h.topics[topic] {
+				recipients[client] = struct{}{}
+			}
 		}
 	}
+
+	queued := 0
+	for client := range recipients {
+		if clientTenant, _ := client.identity(); clientTenant != tenant {
+			continue
+		}
+		h.enqueue(client, message)
+		queued++
+	}
+	return queued
 }
 
-type eventMessage struct {
-	Event string          `json:"event"`
-	Data  json.RawMessage `json:"data"`
+// send queues messages for one connection. It reports false when the connection already
+// left the hub.
+func (h *wsHub) send(client *wsClient, messages ...wsOutbound) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	for _, message := range messages {
+		h.enqueue(client, message)
+	}
+	return true
 }
 
-func (m eventMessage) MarshalBinary() ([]byte, error) {
-	return json.Marshal(m)
+// hasSubscribers reports whether any connection of the tenant is subscribed to topic.
+func (h *wsHub) hasSubscribers(tenant, topic string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.topics[topic] {
+		if clientTenant, _ := client.identity(); clientTenant == tenant {
+			return true
+		}
+	}
+	return false
 }
 
-func (h *wsHub) subscribe(ctx context.Context) {
+func (h *wsHub) subscribe(ctx context.Context, ch <-chan events.Message) {
 	log.Printf("subscribing to events channel")
 
-	ch := h.pubsub.Channel()
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("context done")
 			return
-		case msg := <-ch:
+		case msg, ok := <-ch:
+			if !ok {
+				log.Printf("events channel closed")
+				return
+			}
 			log.Printf("received event: %s", msg.Payload)
-			var event eventMessage
-			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
-				log.Printf("failed to unmarshal event message: %v", err)
-				continue
+			if topics, data, ok := render(msg); ok && h.broadcast(msg.Tenant, topics, wsOutbound{seq: msg.Seq, data: data}) > 0 {
+				h.notified(ctx, msg)
 			}
-
-			switch event.Event {
-			case submitQuizEvent:
-				h.broadcast(event.Data)
+			if h.deltas != nil {
+				h.deltas.touch(msg)
 			}
 		}
 	}
 }
+
+// render turns a bus message into the frame sent to clients and the topics it is delivered
+// on. ok is false for events clients never see.
+func render(msg events.Message) (topics []string, data []byte, ok bool) {
+	var event events.Event
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		log.Printf("failed to unmarshal event message: %v", err)
+		return nil, nil, false
+	}
+
+	switch event.Event {
+	case events.SubmitQuiz:
+		// Submissions are sent as the bare score for compatibility with existing clients.
+		return eventTopics(event), withSeq(event.Data, msg.Seq), true
+	case events.LeaderboardRestored, events.Moderation:
+		return eventTopics(event), withSeq(msg.Payload, msg.Seq), true
+	case events.Presence:
+		return presenceTopics(event), withSeq(msg.Payload, msg.Seq), true
+	case events.UserNotification:
+		topics, data, ok := renderNotification(event.Data)
+		return topics, withSeq(data, msg.Seq), ok
+	}
+	return nil, nil, false
+}
+
+// withSeq adds the event's sequence number to a JSON object as "seq", so clients can resume
+// after it.
+func withSeq(data []byte, seq uint64) []byte {
+	if seq == 0 || len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	out := strconv.AppendUint(append(make([]byte, 0, len(data)+24), `{"seq":`...), seq, 10)
+	rest := bytes.TrimLeft(data[1:], " \t\r\n")
+	if len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...)
+}
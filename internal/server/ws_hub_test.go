@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/snapshots"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func newTestServer(t *testing.T, cfg *configs.Config, repo *mockRepository) *httptest.Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	srv, err := New(ctx, cfg, &bootstrap.Backend{Repo: repo, Bus: bus})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func dialWS(t *testing.T, ts *httptest.Server, host string) *websocket.Conn {
+	t.Helper()
+
+	header := http.Header{}
+	if host != "" {
+		header.Set("Host", host)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHubRoutesEventsByTenant(t *testing.T) {
+	cfg := &configs.Config{Tenancy: configs.TenancyConfig{
+		Enabled: true,
+		Tenants: map[string]configs.TenantConfig{
+			"acme":   {Hosts: []string{"acme.quiz.test"}},
+			"globex": {Hosts: []string{"globex.quiz.test"}},
+		},
+	}}
+	ts := newTestServer(t, cfg, &mockRepository{})
+
+	acme := dialWS(t, ts, "acme.quiz.test")
+	globex := dialWS(t, ts, "globex.quiz.test")
+	subscribe(t, acme, wsTopicGlobal)
+	subscribe(t, globex, wsTopicGlobal)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1", Tenant: "acme"})
+	require.NoError(t, err)
+
+	for _, call := range []struct{ action, body string }{{"join", `{}`}, {"submit", `{"score":10}`}} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/user/quiz/quiz-1/"+call.action, bytes.NewBufferString(call.body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Less(t, resp.StatusCode, 300, call.action)
+	}
+
+	acme.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := acme.ReadMessage()
+	require.NoError(t, err)
+	require.JSONEq(t, `{"seq":1,"user_id":"user-1","quiz_id":"quiz-1","score":10}`, string(message))
+
+	globex.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = globex.ReadMessage()
+	require.Error(t, err, "events must not cross tenants")
+}
+
+func TestRestoreSnapshotNotifiesClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{
+		Repo:      repo,
+		Bus:       bus,
+		Snapshots: snapshots.NewManager(repo, snapshots.NewDirStore(t.TempDir()), bus),
+	})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "admin-1"}, adminGroup)
+	require.NoError(t, err)
+	adminRequest := func(path string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := adminRequest("/admin/snapshots")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var meta snapshots.Meta
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&meta))
+
+	conn := dialWS(t, ts, "")
+	subscribe(t, conn, "quiz:quiz-1")
+
+	resp = adminRequest("/admin/snapshots/" + meta.ID + "/restore")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	var event events.Event
+	require.NoError(t, json.Unmarshal(message, &event))
+	require.Equal(t, events.LeaderboardRestored, event.Event)
+
+	resp = adminRequest("/admin/snapshots/20000101T000000.000000000Z/restore")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func readWSMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestWebsocketAuthenticatesUpgrade(t *testing.T) {
+	cfg := &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{RequireAuth: true}}}
+	ts := newTestServer(t, cfg, &mockRepository{})
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+token, nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsBearerProtocol, token}}
+	conn, resp, err := dialer.Dial(url, nil)
+	require.NoError(t, err)
+	require.Equal(t, wsBearerProtocol, resp.Header.Get("Sec-WebSocket-Protocol"), "the token is never echoed")
+	conn.Close()
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"?access_token=forged", nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, errCodeInvalidToken, body.Error.Code)
+}
+
+func TestWebsocketFirstMessageAuth(t *testing.T) {
+	cfg := &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{
+		RequireAuth: true,
+		AuthTimeout: 100 * time.Millisecond,
+	}}}
+	ts := newTestServer(t, cfg, &mockRepository{})
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+
+	conn := dialWS(t, ts, "")
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsTypeAuth, Token: token}))
+	require.Equal(t, wsMessage{Type: wsTypeAuthenticated, UserID: "user-1"}, readWSMessage(t, conn))
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsTypeAuth, Token: token}))
+	require.Equal(t, errCodeAlreadyAuthenticated, readWSMessage(t, conn).Code)
+
+	silent := dialWS(t, ts, "")
+	require.Equal(t, errCodeAuthTimeout, readWSMessage(t, silent).Code)
+	_, _, err = silent.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+
+	forged := dialWS(t, ts, "")
+	require.NoError(t, forged.WriteJSON(wsMessage{Type: wsTypeAuth, Token: "forged"}))
+	require.Equal(t, errCodeInvalidToken, readWSMessage(t, forged).Code)
+	_, _, err = forged.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+}
+
+func TestWebsocketAnonymousMayAuthenticateLater(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	conn := dialWS(t, ts, "")
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsTypeAuth, Token: "forged"}))
+	require.Equal(t, errCodeInvalidToken, readWSMessage(t, conn).Code, "a failed attempt leaves the connection open")
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsTypeAuth, Token: token}))
+	require.Equal(t, wsMessage{Type: wsTypeAuthenticated, UserID: "user-1"}, readWSMessage(t, conn))
+}
+
+func TestWebsocketRejectsForeignTenantToken(t *testing.T) {
+	cfg := &configs.Config{Tenancy: configs.TenancyConfig{
+		Enabled: true,
+		Tenants: map[string]configs.TenantConfig{
+			"acme":   {Hosts: []string{"acme.quiz.test"}},
+			"globex": {Hosts: []string{"globex.quiz.test"}},
+		},
+	}}
+	ts := newTestServer(t, cfg, &mockRepository{})
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1", Tenant: "globex"})
+	require.NoError(t, err)
+
+	header := http.Header{"Host": {"acme.quiz.test"}, "Authorization": {"Bearer " + token}}
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", header)
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func submitScore(t *testing.T, ts *httptest.Server, userID, quizID string, score float64) {
+	t.Helper()
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: userID})
+	require.NoError(t, err)
+	body, err := json.Marshal(map[string]float64{"score": score})
+	require.NoError(t, err)
+
+	for _, call := range []struct {
+		action string
+		body   []byte
+		status int
+	}{
+		{"join", []byte(`{}`), http.StatusCreated},
+		{"submit", body, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/user/quiz/"+quizID+"/"+call.action, bytes.NewReader(call.body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, call.status, resp.StatusCode, call.action)
+	}
+}
+
+func TestHubRoutesEventsByTopic(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	everything := dialWS(t, ts, "")
+	subscribe(t, everything, wsTopicGlobal)
+	quiz := dialWS(t, ts, "")
+	subscribe(t, quiz, "quiz:quiz-1")
+	idle := dialWS(t, ts, "")
+	subscribe(t, idle, "quiz:quiz-3")
+
+	submitScore(t, ts, "user-1", "quiz-2", 5)
+	submitScore(t, ts, "user-1", "quiz-1", 10)
+
+	for _, want := range []string{"quiz-2", "quiz-1"} {
+		everything.SetReadDeadline(time.Now().Add(time.Second))
+		var event topicEvent
+		require.NoError(t, everything.ReadJSON(&event))
+		require.Equal(t, want, event.QuizID, "global subscribers receive every quiz")
+	}
+
+	quiz.SetReadDeadline(time.Now().Add(time.Second))
+	var event topicEvent
+	require.NoError(t, quiz.ReadJSON(&event))
+	require.Equal(t, "quiz-1", event.QuizID, "quiz-2 traffic is never sent to quiz-1 subscribers")
+	quiz.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := quiz.ReadMessage()
+	require.Error(t, err, "a connection does not follow global unless it subscribes to it")
+
+	idle.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err = idle.ReadMessage()
+	require.Error(t, err, "other quizzes' subscribers receive nothing")
+}
+
+func TestWebsocketUserTopicsArePrivate(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?access_token="+token, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "user:user-2"}))
+	require.Equal(t, errCodeForbiddenTopic, readWSMessage(t, conn).Code)
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "lobby"}))
+	require.Equal(t, errCodeInvalidTopic, readWSMessage(t, conn).Code)
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: "publish", Topic: "quiz:quiz-1"}))
+	require.Equal(t, errCodeUnknownOp, readWSMessage(t, conn).Code)
+
+	// Authenticated connections start subscribed to their own user topic.
+	submitScore(t, ts, "user-2", "quiz-1", 5)
+	submitScore(t, ts, "user-1", "quiz-1", 10)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var event topicEvent
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, "user-1", event.UserID)
+}
+
+func TestEventTopics(t *testing.T) {
+	submit := events.Event{Event: events.SubmitQuiz, Data: json.RawMessage(`{"user_id":"u1","quiz_id":"q1","score":1}`)}
+	require.Equal(t, []string{"global", "quiz:q1", "user:u1"}, eventTopics(submit))
+
+	ban := events.Event{Event: events.Moderation, Data: json.RawMessage(`{"action":"ban","user_id":"u1"}`)}
+	require.Equal(t, []string{"global", "user:u1"}, eventTopics(ban))
+
+	require.Nil(t, eventTopics(events.Event{Event: events.LeaderboardRestored, Data: json.RawMessage(`{}`)}),
+		"a restore reaches every connection")
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// wsTopicLeaderboard prefixes the topics that follow the top of a leaderboard scope:
+	// "leaderboard:global" or "leaderboard:quiz:42".
+	wsTopicLeaderboard = "leaderboard:"
+
+	// wsTypeLeaderboard carries the tracked top of a scope when a connection subscribes to it;
+	// wsTypeLeaderboardDelta carries the changes to it after every update.
+	wsTypeLeaderboard      = "leaderboard"
+	wsTypeLeaderboardDelta = "leaderboard_delta"
+)
+
+// Kinds of change in a leaderboard delta.
+const (
+	// deltaEnter and deltaLeave mark entries entering or leaving the top N.
+	deltaEnter = "enter"
+	deltaLeave = "leave"
+	// deltaMove marks an entry whose rank changed, with its new score; deltaScore one whose
+	// score changed in place.
+	deltaMove  = "move"
+	deltaScore = "score"
+)
+
+// boardEntry is one row of a leaderboard frame; ranks start at 1.
+type boardEntry struct {
+	Rank int `json:"rank"`
+	models.UserQuiz
+}
+
+// boardChange is one change of a delta. Rank is omitted when the entry left the top N and
+// PreviousRank when it entered it or only its score changed.
+type boardChange struct {
+	Op           string `json:"op"`
+	Rank         int    `json:"rank,omitempty"`
+	PreviousRank int    `json:"previous_rank,omitempty"`
+	models.UserQuiz
+}
+
+type boardFrame struct {
+	Type    string       `json:"type"`
+	Topic   string       `json:"topic"`
+	Version int64        `json:"version,omitempty"`
+	Entries []boardEntry `json:"entries"`
+}
+
+type deltaFrame struct {
+	Type    string        `json:"type"`
+	Topic   string        `json:"topic"`
+	Version int64         `json:"version,omitempty"`
+	Changes []boardChange `json:"changes"`
+}
+
+// leaderboardScope parses a leaderboard topic into the scope it follows.
+func leaderboardScope(topic string) (repositories.Scope, bool) {
+	return topicScope(wsTopicLeaderboard, topic)
+}
+
+// topicScope parses a topic naming a scope after prefix, such as "leaderboard:quiz:42".
+func topicScope(prefix, topic string) (repositories.Scope, bool) {
+	raw, ok := strings.CutPrefix(topic, prefix)
+	if !ok || raw == "" {
+		return "", false
+	}
+	scope, err := repositories.ParseScope(raw)
+	return scope, err == nil
+}
+
+type boardKey struct {
+	tenant string
+	scope  repositories.Scope
+}
+
+// trackedBoard is the top of a scope as last sent to its subscribers.
+type trackedBoard struct {
+	entries []models.UserQuiz
+	version int64
+}
+
+// pendingScopes are the scopes of a tenant waiting to be re-read; all covers every tracked one.
+type pendingScopes struct {
+	all    bool
+	scopes map[repositories.Scope]struct{}
+}
+
+// leaderboardDeltas follows the top entries of every scope a connection subscribed to. Events
+// only mark scopes as changed; a single worker re-reads each changed scope once, however many
+// events touched it and however many connections follow it, and queues the difference to its
+// subscribers. Scopes nobody follows any more are forgotten on their next change.
+type leaderboardDeltas struct {
+	hub       *wsHub
+	store     repositories.ScopeStore
+	versioned repositories.Versioned
+	top       int64
+
+	// mu guards boards and is held while a board is read and its frames queued, so the
+	// snapshot a subscriber receives and the deltas after it are queued in order.
+	mu     sync.Mutex
+	boards map[boardKey]*trackedBoard
+
+	pendingMu sync.Mutex
+	pending   map[string]*pendingScopes
+	wake      chan struct{}
+}
+
+// newLeaderboardDeltas tracks the top entries of each followed scope of store. The board
+// version is included in frames when store is also repositories.Versioned.
+func newLeaderboardDeltas(hub *wsHub, store repositories.ScopeStore, top int) *leaderboardDeltas {
+	versioned, _ := store.(repositories.Versioned)
+	return &leaderboardDeltas{
+		hub:       hub,
+		store:     store,
+		versioned: versioned,
+		top:       int64(top),
+		boards:    make(map[boardKey]*trackedBoard),
+		pending:   make(map[string]*pendingScopes),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// touch marks the scopes an event may have changed: a submission changes the global board and
+// its quiz's, while moderation and restores may change any board of the tenant.
+func (d *leaderboardDeltas) touch(msg events.Message) {
+	var event events.Event
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		return
+	}
+
+	d.pendingMu.Lock()
+	pending, ok := d.pending[msg.Tenant]
+	if !ok {
+		pending = &pendingScopes{scopes: make(map[repositories.Scope]struct{})}
+		d.pending[msg.Tenant] = pending
+	}
+	switch event.Event {
+	case events.SubmitQuiz:
+		pending.scopes[repositories.GlobalScope] = struct{}{}
+		var data topicEvent
+		if err := json.Unmarshal(event.Data, &data); err == nil && data.QuizID != "" {
+			pending.scopes[repositories.QuizScope(data.QuizID)] = struct{}{}
+		}
+	case events.LeaderboardRestored, events.Moderation:
+		pending.all = true
+	}
+	d.pendingMu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run refreshes the marked scopes until ctx is done. Events arriving during a refresh are
+// coalesced into the next one.
+func (d *leaderboardDeltas) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		}
+
+		d.pendingMu.Lock()
+		pending := d.pending
+		d.pending = make(map[string]*pendingScopes)
+		d.pendingMu.Unlock()
+
+		d.mu.Lock()
+		for key, board := range d.boards {
+			marked, ok := pending[key.tenant]
+			if !ok {
+				continue
+			}
+			if _, ok := marked.scopes[key.scope]; ok || marked.all {
+				d.refreshLocked(ctx, key, board)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// refreshLocked re-reads a board and queues what changed to its subscribers. Callers hold mu.
+func (d *leaderboardDeltas) refreshLocked(ctx context.Context, key boardKey, board *trackedBoard) {
+	topic := wsTopicLeaderboard + string(key.scope)
+	if !d.hub.hasSubscribers(key.tenant, topic) {
+		delete(d.boards, key)
+		return
+	}
+
+	entries, version, err := d.read(ctx, key)
+	if err != nil {
+		// The next change retries; until then subscribers keep the last board they were sent.
+		log.Printf("failed to refresh leaderboard %s: %v", key.scope, err)
+		return
+	}
+	changes := diffBoards(board.entries, entries)
+	board.entries, board.version = entries, version
+	if len(changes) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(deltaFrame{Type: wsTypeLeaderboardDelta, Topic: topic, Version: version, Changes: changes})
+	if err != nil {
+		log.Printf("failed to encode leaderboard delta: %v", err)
+		return
+	}
+	d.hub.broadcast(key.tenant, []string{topic}, wsOutbound{data: data})
+}
+
+// read lists the top of a board, reading the version first so a board is never tagged newer
+// than it is.
+func (d *leaderboardDeltas) read(ctx context.Context, key boardKey) ([]models.UserQuiz, int64, error) {
+	ctx = pkg.WithTenant(ctx, pkg.Tenant{ID: key.tenant})
+
+	var version int64
+	if d.versioned != nil {
+		var err error
+		if version, err = d.versioned.LeaderboardVersion(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	entries, err := d.store.ListScopeScores(ctx, key.scope, 0, d.top)
+	return entries, version, err
+}
+
+// subscribe adds a connection to a leaderboard topic and queues the acknowledgement and the
+// current top of the scope, ahead of any delta.
+func (d *leaderboardDeltas) subscribe(ctx context.Context, client *wsClient, topic string, scope repositories.Scope) {
+	tenant, _ := client.identity()
+	key := boardKey{tenant: tenant, scope: scope}
+
+	d.mu.Lock()
+	board, ok := d.boards[key]
+	if !ok {
+		entries, version, err := d.read(ctx, key)
+		if err != nil {
+			d.mu.Unlock()
+			log.Printf("failed to read leaderboard %s: %v", scope, err)
+			sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeInternal, Topic: topic, Message: "failed to read leaderboard"})
+			return
+		}
+		board = &trackedBoard{entries: entries, version: version}
+		d.boards[key] = board
+	}
+	if !d.hub.addTopic(client, topic) {
+		d.mu.Unlock()
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeTooManyTopics, Topic: topic,
+			Message: "too many subscriptions on this connection"})
+		return
+	}
+
+	ack, err := json.Marshal(wsMessage{Type: wsTypeSubscribed, Topic: topic})
+	if err == nil {
+		var snapshot []byte
+		snapshot, err = json.Marshal(boardFrame{Type: wsTypeLeaderboard, Topic: topic, Version: board.version, Entries: rankEntries(board.entries)})
+		d.hub.send(client, wsOutbound{data: ack}, wsOutbound{data: snapshot})
+	}
+	d.mu.Unlock()
+	if err != nil {
+		log.Printf("failed to encode leaderboard: %v", err)
+	}
+}
+
+func rankEntries(entries []models.UserQuiz) []boardEntry {
+	ranked := make([]boardEntry, len(entries))
+	for i, entry := range entries {
+		ranked[i] = boardEntry{Rank: i + 1, UserQuiz: entry}
+	}
+	return ranked
+}
+
+// diffBoards lists the changes from prev to next, both ordered by rank: the entries that left
+// first, then the entries of next that entered, moved or changed score, in rank order.
+func diffBoards(prev, next []models.UserQuiz) []boardChange {
+	type ranked struct {
+		rank  int
+		entry models.UserQuiz
+	}
+	entryKey := func(entry models.UserQuiz) string {
+		return entry.UserID + "\x00" + entry.QuizID
+	}
+
+	before := make(map[string]ranked, len(prev))
+	for i, entry := range prev {
+		before[entryKey(entry)] = ranked{rank: i + 1, entry: entry}
+	}
+	after := make(map[string]struct{}, len(next))
+	for _, entry := range next {
+		after[entryKey(entry)] = struct{}{}
+	}
+
+	var changes []boardChange
+	for i, entry := range prev {
+		if _, ok := after[entryKey(entry)]; !ok {
+			changes = append(changes, boardChange{Op: deltaLeave, PreviousRank: i + 1, UserQuiz: entry})
+		}
+	}
+	for i, entry := range next {
+		rank := i + 1
+		old, ok := before[entryKey(entry)]
+		switch {
+		case !ok:
+			changes = append(changes, boardChange{Op: deltaEnter, Rank: rank, UserQuiz: entry})
+		case old.rank != rank:
+			changes = append(changes, boardChange{Op: deltaMove, Rank: rank, PreviousRank: old.rank, UserQuiz: entry})
+		case old.entry.Score != entry.Score:
+			changes = append(changes, boardChange{Op: deltaScore, Rank: rank, UserQuiz: entry})
+		}
+	}
+	return changes
+}
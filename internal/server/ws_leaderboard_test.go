@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func TestDiffBoards(t *testing.T) {
+	alice := models.UserQuiz{UserID: "alice", QuizID: "quiz-1", Score: 50}
+	bob := models.UserQuiz{UserID: "bob", QuizID: "quiz-1", Score: 40}
+	carol := models.UserQuiz{UserID: "carol", QuizID: "quiz-1", Score: 60}
+	bobAgain := models.UserQuiz{UserID: "bob", QuizID: "quiz-1", Score: 45}
+
+	require.Empty(t, diffBoards([]models.UserQuiz{alice, bob}, []models.UserQuiz{alice, bob}))
+	require.Equal(t, []boardChange{
+		{Op: deltaLeave, PreviousRank: 2, UserQuiz: bob},
+		{Op: deltaEnter, Rank: 1, UserQuiz: carol},
+		{Op: deltaMove, Rank: 2, PreviousRank: 1, UserQuiz: alice},
+	}, diffBoards([]models.UserQuiz{alice, bob}, []models.UserQuiz{carol, alice}))
+	require.Equal(t, []boardChange{
+		{Op: deltaScore, Rank: 2, UserQuiz: bobAgain},
+	}, diffBoards([]models.UserQuiz{alice, bob}, []models.UserQuiz{alice, bobAgain}))
+	require.Equal(t, []boardChange{
+		{Op: deltaEnter, Rank: 1, UserQuiz: alice},
+	}, diffBoards(nil, []models.UserQuiz{alice}))
+}
+
+func readFrame[T any](t *testing.T, conn *websocket.Conn) T {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var frame T
+	require.NoError(t, conn.ReadJSON(&frame))
+	return frame
+}
+
+func TestWebsocketLeaderboardDeltas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	cfg := &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{DeltaTop: 2}}}
+	srv, err := New(ctx, cfg, &bootstrap.Backend{Repo: repo, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	alice := models.UserQuiz{UserID: "alice", QuizID: "quiz-1", Score: 50}
+	bob := models.UserQuiz{UserID: "bob", QuizID: "quiz-1", Score: 40}
+	for _, entry := range []models.UserQuiz{alice, bob} {
+		require.NoError(t, repo.JoinQuiz(ctx, entry.UserID, entry.QuizID))
+		require.NoError(t, repo.SubmitQuiz(ctx, entry))
+	}
+
+	conn := dialWS(t, ts, "")
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "leaderboard:global"}))
+	require.Equal(t, wsMessage{Type: wsTypeSubscribed, Topic: "leaderboard:global"}, readWSMessage(t, conn))
+
+	board := readFrame[boardFrame](t, conn)
+	require.NotZero(t, board.Version)
+	require.Equal(t, boardFrame{Type: wsTypeLeaderboard, Topic: "leaderboard:global", Version: board.Version, Entries: []boardEntry{
+		{Rank: 1, UserQuiz: alice},
+		{Rank: 2, UserQuiz: bob},
+	}}, board)
+
+	carol := models.UserQuiz{UserID: "carol", QuizID: "quiz-1", Score: 60}
+	submitScore(t, ts, carol.UserID, carol.QuizID, carol.Score)
+
+	delta := readFrame[deltaFrame](t, conn)
+	require.Greater(t, delta.Version, board.Version)
+	require.Equal(t, []boardChange{
+		{Op: deltaLeave, PreviousRank: 2, UserQuiz: bob},
+		{Op: deltaEnter, Rank: 1, UserQuiz: carol},
+		{Op: deltaMove, Rank: 2, PreviousRank: 1, UserQuiz: alice},
+	}, delta.Changes)
+
+	// Below the tracked ranks: no delta. The next frame must be the moderation's.
+	submitScore(t, ts, "dave", "quiz-1", 1)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "admin-1"}, adminGroup)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/admin/moderation/users/carol/scores", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	delta = readFrame[deltaFrame](t, conn)
+	require.Equal(t, wsTypeLeaderboardDelta, delta.Type)
+	require.Equal(t, []boardChange{
+		{Op: deltaLeave, PreviousRank: 1, UserQuiz: carol},
+		{Op: deltaMove, Rank: 1, PreviousRank: 2, UserQuiz: alice},
+		{Op: deltaEnter, Rank: 2, UserQuiz: bob},
+	}, delta.Changes)
+}
+
+func TestWebsocketLeaderboardTopics(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{DeltaTop: 10}}}, &mockRepository{})
+	conn := dialWS(t, ts, "")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "leaderboard:"}))
+	require.Equal(t, errCodeInvalidTopic, readWSMessage(t, conn).Code)
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "leaderboard:user:1"}))
+	require.Equal(t, errCodeInvalidTopic, readWSMessage(t, conn).Code)
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: "leaderboard:quiz:quiz-1"}))
+	require.Equal(t, errCodeNotImplemented, readWSMessage(t, conn).Code, "the mock repository has no scoped boards")
+}
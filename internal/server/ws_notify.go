@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// wsTopicNotify prefixes the topic of a user's notifications. A connection is subscribed to
+	// its user's topic once authenticated; clients cannot subscribe to or drop it themselves.
+	wsTopicNotify = "notify:"
+
+	wsTypeNotification = "notification"
+)
+
+type notificationFrame struct {
+	Type string `json:"type"`
+	events.Notification
+}
+
+// renderNotification turns the data of a notification event into the frame sent to the
+// connections of its user.
+func renderNotification(data []byte) (topics []string, frame []byte, ok bool) {
+	var n events.Notification
+	if err := json.Unmarshal(data, &n); err != nil || n.UserID == "" {
+		log.Printf("failed to decode notification: %v", err)
+		return nil, nil, false
+	}
+	frame, err := json.Marshal(notificationFrame{Type: wsTypeNotification, Notification: n})
+	if err != nil {
+		log.Printf("failed to encode notification: %v", err)
+		return nil, nil, false
+	}
+	return []string{wsTopicNotify + n.UserID}, frame, true
+}
+
+// bindUser subscribes an authenticated connection to its user's notifications and queues
+// the ones kept in the user's inbox. Notifications are put back when the connection closes
+// before they were queued.
+func (h *wsHub) bindUser(ctx context.Context, client *wsClient) {
+	tenant, userID := client.identity()
+	if userID == "" {
+		return
+	}
+	h.mu.Lock()
+	_, connected := h.clients[client]
+	if _, bound := client.subscriptions[wsTopicNotify+userID]; connected && !bound {
+		h.addTopicLocked(client, wsTopicNotify+userID)
+		// Quiz topics subscribed while anonymous count towards presence from now on.
+		for topic := range client.subscriptions {
+			if strings.HasPrefix(topic, wsTopicQuiz) {
+				h.trackPresence(client, topic, 1)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	inbox, ok := h.bus.(events.Inbox)
+	if !ok {
+		return
+	}
+	ctx = pkg.WithTenant(ctx, pkg.Tenant{ID: tenant})
+	payloads, err := inbox.Drain(ctx, userID)
+	if err != nil {
+		log.Printf("failed to read notification inbox: %v", err)
+		return
+	}
+	if len(payloads) == 0 {
+		return
+	}
+
+	frames := make([]wsOutbound, 0, len(payloads))
+	for _, payload := range payloads {
+		if _, frame, ok := renderNotification(payload); ok {
+			frames = append(frames, wsOutbound{data: frame})
+		}
+	}
+	if h.send(client, frames...) {
+		return
+	}
+	for _, payload := range payloads {
+		if err := inbox.Keep(ctx, userID, payload); err != nil {
+			log.Printf("failed to keep notification: %v", err)
+		}
+	}
+}
+
+// notified removes a notification from its user's inbox once the hub queued it for one of
+// their connections. Every instance holding a connection of the user does so; removing a
+// notification twice is harmless.
+func (h *wsHub) notified(ctx context.Context, msg events.Message) {
+	inbox, ok := h.bus.(events.Inbox)
+	if !ok {
+		return
+	}
+
+	var event events.Event
+	if err := json.Unmarshal(msg.Payload, &event); err != nil || event.Event != events.UserNotification {
+		return
+	}
+	var n events.Notification
+	if err := json.Unmarshal(event.Data, &n); err != nil || !n.Inbox {
+		return
+	}
+	if err := inbox.Delivered(pkg.WithTenant(ctx, pkg.Tenant{ID: msg.Tenant}), n.UserID, event.Data); err != nil {
+		log.Printf("failed to clear delivered notification: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func dialAs(t *testing.T, ts *httptest.Server, userID string) *websocket.Conn {
+	t.Helper()
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: userID})
+	require.NoError(t, err)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?access_token="+token, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func notify(t *testing.T, ts *httptest.Server, userID, body string) *http.Response {
+	t.Helper()
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "admin-1"}, adminGroup)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/notifications/users/"+userID, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestNotificationsReachEveryConnectionOfTheUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{Repo: &mockRepository{}, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	phone, laptop := dialAs(t, ts, "user-1"), dialAs(t, ts, "user-1")
+	other, anonymous := dialAs(t, ts, "user-2"), dialWS(t, ts, "")
+	time.Sleep(50 * time.Millisecond)
+
+	resp := notify(t, ts, "user-1", `{"kind":"overtaken","message":"bob passed you","data":{"rank":2},"inbox":true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, conn := range []*websocket.Conn{phone, laptop} {
+		frame := readFrame[notificationFrame](t, conn)
+		require.Equal(t, wsTypeNotification, frame.Type)
+		require.Equal(t, "overtaken", frame.Kind)
+		require.Equal(t, "bob passed you", frame.Message)
+		require.JSONEq(t, `{"rank":2}`, string(frame.Data))
+		require.NotEmpty(t, frame.ID)
+	}
+	for _, conn := range []*websocket.Conn{other, anonymous} {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, _, err := conn.ReadMessage()
+		require.Error(t, err, "notifications are private to their user")
+	}
+
+	require.Eventually(t, func() bool {
+		waiting, err := bus.Drain(ctx, "user-1")
+		return err == nil && len(waiting) == 0
+	}, time.Second, 10*time.Millisecond, "a delivered notification leaves the inbox")
+}
+
+func TestNotificationInboxDeliversOnConnect(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	require.Equal(t, http.StatusOK, notify(t, ts, "user-1", `{"kind":"quiz_ending","message":"quiz-1 ends in 1 minute","inbox":true}`).StatusCode)
+	require.Equal(t, http.StatusOK, notify(t, ts, "user-1", `{"kind":"not_kept"}`).StatusCode)
+	require.Equal(t, http.StatusBadRequest, notify(t, ts, "user-1", `{"message":"no kind"}`).StatusCode)
+
+	conn := dialWS(t, ts, "")
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteJSON(wsMessage{Type: wsTypeAuth, Token: token}))
+	require.Equal(t, wsMessage{Type: wsTypeAuthenticated, UserID: "user-1"}, readWSMessage(t, conn))
+
+	frame := readFrame[notificationFrame](t, conn)
+	require.Equal(t, "quiz_ending", frame.Kind)
+	require.True(t, frame.Inbox)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadMessage()
+	require.Error(t, err, "only kept notifications wait for the user")
+
+	again := dialAs(t, ts, "user-1")
+	again.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = again.ReadMessage()
+	require.Error(t, err, "the inbox is emptied once delivered")
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/presence"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// wsTopicPresence prefixes the topics of presence events: "presence:global" for users
+	// coming online or going offline, "presence:quiz:42" for users starting or stopping to
+	// watch a quiz.
+	wsTopicPresence = "presence:"
+
+	// wsPresenceHeartbeat is the default interval between presence heartbeats.
+	wsPresenceHeartbeat = 15 * time.Second
+	// wsPresenceRelease bounds the release of this instance's users on shutdown.
+	wsPresenceRelease = 5 * time.Second
+)
+
+// presenceEvent announces a user joining or leaving a scope. Count is the number of users
+// present in the scope afterwards; in a quiz scope they are its viewers, joined or not.
+type presenceEvent struct {
+	Action string `json:"action"`
+	UserID string `json:"user_id"`
+	QuizID string `json:"quiz_id,omitempty"`
+	Count  int64  `json:"count"`
+}
+
+// presenceTopic names the topic presence events of a scope are delivered on.
+func presenceTopic(quizID string) string {
+	if quizID == "" {
+		return wsTopicPresence + string(repositories.GlobalScope)
+	}
+	return wsTopicPresence + string(repositories.QuizScope(quizID))
+}
+
+type presenceKey struct {
+	tenant string
+	scope  repositories.Scope
+	userID string
+}
+
+type presenceOp struct {
+	presenceKey
+	join bool
+}
+
+// presenceTracker tells the presence store which users this instance holds. An authenticated
+// connection makes its user present in the tenant, and a viewer of a quiz while subscribed
+// to the quiz topic. Only a user's first and last connection on the instance reach the store, from
+// a single worker, so the hub never waits on Redis.
+type presenceTracker struct {
+	store     presence.Store
+	bus       events.Bus
+	tenants   []string
+	heartbeat time.Duration
+
+	mu sync.Mutex
+	// held counts this instance's connections of each user per scope.
+	held map[presenceKey]int
+	ops  []presenceOp
+	wake chan struct{}
+}
+
+// newPresenceTracker renews the claims of this instance in every configured tenant each
+// heartbeat (wsPresenceHeartbeat when 0), which also releases the users of stopped instances.
+func newPresenceTracker(store presence.Store, bus events.Bus, tenants []pkg.Tenant, heartbeat time.Duration) *presenceTracker {
+	if heartbeat <= 0 {
+		heartbeat = wsPresenceHeartbeat
+	}
+	ids := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		ids[i] = tenant.ID
+	}
+	return &presenceTracker{
+		store:     store,
+		bus:       bus,
+		tenants:   ids,
+		heartbeat: heartbeat,
+		held:      make(map[presenceKey]int),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// track counts a connection of a user entering (delta 1) or leaving (delta -1) a scope. It
+// never blocks, as callers hold the hub's lock.
+func (p *presenceTracker) track(tenant string, scope repositories.Scope, userID string, delta int) {
+	key := presenceKey{tenant: tenant, scope: scope, userID: userID}
+
+	p.mu.Lock()
+	before := p.held[key]
+	after := before + delta
+	if after <= 0 {
+		delete(p.held, key)
+	} else {
+		p.held[key] = after
+	}
+	if (before == 0) != (after <= 0) {
+		p.ops = append(p.ops, presenceOp{presenceKey: key, join: before == 0})
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run applies tracked changes and heartbeats until ctx is done, then releases this
+// instance's users.
+func (p *presenceTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(p.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.release()
+			return
+		case <-p.wake:
+			p.apply(ctx)
+		case <-ticker.C:
+			p.beat(ctx)
+		}
+	}
+}
+
+func (p *presenceTracker) apply(ctx context.Context) {
+	p.mu.Lock()
+	ops := p.ops
+	p.ops = nil
+	p.mu.Unlock()
+
+	for _, op := range ops {
+		tenantCtx := pkg.WithTenant(ctx, pkg.Tenant{ID: op.tenant})
+		var (
+			change  presence.Change
+			changed bool
+			err     error
+		)
+		if op.join {
+			change, changed, err = p.store.Join(tenantCtx, op.scope, op.userID)
+		} else {
+			change, changed, err = p.store.Leave(tenantCtx, op.scope, op.userID)
+		}
+		if err != nil {
+			// A lost join is restored by the next heartbeat that finds the claims lapsed; a lost
+			// leave lingers until this instance stops.
+			log.Printf("failed to record presence: %v", err)
+			continue
+		}
+		if changed {
+			p.announce(tenantCtx, change)
+		}
+	}
+}
+
+// beat renews this instance's claims in every tenant it knows of. When the claims of a
+// tenant lapsed, say after a long pause, its users are claimed again.
+func (p *presenceTracker) beat(ctx context.Context) {
+	for _, tenant := range p.knownTenants() {
+		tenantCtx := pkg.WithTenant(ctx, pkg.Tenant{ID: tenant})
+		changes, lapsed, err := p.store.Heartbeat(tenantCtx)
+		for _, change := range changes {
+			p.announce(tenantCtx, change)
+		}
+		if err != nil {
+			log.Printf("failed to renew presence: %v", err)
+			continue
+		}
+		if !lapsed {
+			continue
+		}
+
+		for _, key := range p.heldKeys(tenant) {
+			change, changed, err := p.store.Join(tenantCtx, key.scope, key.userID)
+			if err != nil {
+				log.Printf("failed to restore presence: %v", err)
+				break
+			}
+			if changed {
+				p.announce(tenantCtx, change)
+			}
+		}
+	}
+}
+
+// release takes this instance's users offline when the server stops.
+func (p *presenceTracker) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), wsPresenceRelease)
+	defer cancel()
+
+	for _, tenant := range p.knownTenants() {
+		tenantCtx := pkg.WithTenant(ctx, pkg.Tenant{ID: tenant})
+		changes, err := p.store.Release(tenantCtx)
+		if err != nil {
+			log.Printf("failed to release presence: %v", err)
+		}
+		for _, change := range changes {
+			p.announce(tenantCtx, change)
+		}
+	}
+}
+
+// knownTenants lists the configured tenants and any other tenant this instance holds users of.
+func (p *presenceTracker) knownTenants() []string {
+	tenants := append([]string(nil), p.tenants...)
+	seen := make(map[string]struct{}, len(tenants))
+	for _, tenant := range tenants {
+		seen[tenant] = struct{}{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.held {
+		if _, ok := seen[key.tenant]; !ok {
+			seen[key.tenant] = struct{}{}
+			tenants = append(tenants, key.tenant)
+		}
+	}
+	return tenants
+}
+
+func (p *presenceTracker) heldKeys(tenant string) []presenceKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var keys []presenceKey
+	for key := range p.held {
+		if key.tenant == tenant {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// announce relays a presence change live; presence events are frequent and not replayed.
+func (p *presenceTracker) announce(ctx context.Context, change presence.Change) {
+	event := presenceEvent{Action: change.Action, UserID: change.UserID, Count: change.Count}
+	if change.Scope != repositories.GlobalScope {
+		event.QuizID = change.Scope.QuizID()
+	}
+	if err := events.Announce(ctx, p.bus, events.Presence, event); err != nil {
+		log.Printf("failed to publish presence event: %v", err)
+	}
+}
+
+// trackPresence counts a connection's subscription change towards presence: its user's
+// notification topic stands for the user being online, a quiz topic for watching the quiz.
+// Quiz presence counts viewers, not players: it follows the subscription, not JoinQuiz, so
+// the hub never asks the store about membership. Anonymous connections are not counted.
+// Callers hold the hub's lock.
+func (h *wsHub) trackPresence(client *wsClient, topic string, delta int) {
+	if h.presence == nil {
+		return
+	}
+	tenant, userID := client.identity()
+	if userID == "" {
+		return
+	}
+
+	var scope repositories.Scope
+	switch {
+	case topic == wsTopicNotify+userID:
+		scope = repositories.GlobalScope
+	case strings.HasPrefix(topic, wsTopicQuiz):
+		scope = repositories.QuizScope(strings.TrimPrefix(topic, wsTopicQuiz))
+	default:
+		return
+	}
+	h.presence.track(tenant, scope, userID, delta)
+}
+
+// presenceTopics lists the topic a presence event is delivered on.
+func presenceTopics(event events.Event) []string {
+	var data presenceEvent
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return []string{presenceTopic("")}
+	}
+	return []string{presenceTopic(data.QuizID)}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/presence"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func subscribe(t *testing.T, conn *websocket.Conn, topic string) {
+	t.Helper()
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: topic}))
+	require.Equal(t, wsMessage{Type: wsTypeSubscribed, Topic: topic}, readWSMessage(t, conn))
+}
+
+func readPresence(t *testing.T, conn *websocket.Conn) presenceEvent {
+	t.Helper()
+
+	event := readFrame[events.Event](t, conn)
+	require.Equal(t, events.Presence, event.Event)
+	var data presenceEvent
+	require.NoError(t, json.Unmarshal(event.Data, &data))
+	return data
+}
+
+func getJSON[T any](t *testing.T, ts *httptest.Server, path string) (int, T) {
+	t.Helper()
+	return getJSONAs[T](t, ts, "", path)
+}
+
+// getJSONAs is getJSON with a token for userID, or none when it is empty.
+func getJSONAs[T any](t *testing.T, ts *httptest.Server, userID, path string) (int, T) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	require.NoError(t, err)
+	if userID != "" {
+		token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: userID})
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return resp.StatusCode, body
+}
+
+func TestWebsocketPresenceCountsQuizViewers(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	watcher := dialAs(t, ts, "watcher")
+	subscribe(t, watcher, "presence:quiz:quiz-1")
+
+	// user-1 never joins quiz-1: watching it is enough to count.
+	phone, laptop := dialAs(t, ts, "user-1"), dialAs(t, ts, "user-1")
+	subscribe(t, phone, "quiz:quiz-1")
+	require.Equal(t, presenceEvent{Action: presence.ActionJoin, UserID: "user-1", QuizID: "quiz-1", Count: 1}, readPresence(t, watcher))
+	subscribe(t, laptop, "quiz:quiz-1")
+	anonymous := dialWS(t, ts, "")
+	subscribe(t, anonymous, "quiz:quiz-1")
+
+	status, count := getJSON[presenceResponse](t, ts, "/presence/quiz:quiz-1")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, int64(1), count.Count, "a user counts once however many connections they hold; anonymous ones do not count")
+	_, members := getJSONAs[presenceMembersResponse](t, ts, "user-1", "/presence/quiz:quiz-1/members")
+	require.Equal(t, []string{"user-1"}, members.Members)
+	require.Equal(t, "0", members.Cursor)
+	_, online := getJSONAs[userPresenceResponse](t, ts, "user-2", "/presence/users/user-1")
+	require.True(t, online.Online)
+
+	phone.Close()
+	require.NoError(t, laptop.WriteJSON(wsMessage{Op: wsOpUnsubscribe, Topic: "quiz:quiz-1"}))
+	require.Equal(t, wsMessage{Type: wsTypeUnsubscribed, Topic: "quiz:quiz-1"}, readWSMessage(t, laptop))
+	require.Equal(t, presenceEvent{Action: presence.ActionLeave, UserID: "user-1", QuizID: "quiz-1", Count: 0}, readPresence(t, watcher),
+		"the user stops watching the quiz with their last connection on it")
+
+	_, online = getJSONAs[userPresenceResponse](t, ts, "user-2", "/presence/users/user-1")
+	require.True(t, online.Online, "the user is still online through the laptop")
+	laptop.Close()
+	require.Eventually(t, func() bool {
+		_, online := getJSONAs[userPresenceResponse](t, ts, "user-2", "/presence/users/user-1")
+		return !online.Online
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWebsocketPresenceOnline(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	watcher := dialAs(t, ts, "watcher")
+	subscribe(t, watcher, "presence:global")
+
+	conn := dialAs(t, ts, "user-1")
+	require.Equal(t, presenceEvent{Action: presence.ActionJoin, UserID: "user-1", Count: 2}, readPresence(t, watcher),
+		"the watcher is online too")
+	subscribe(t, conn, "quiz:quiz-1")
+	conn.Close()
+	require.Equal(t, presenceEvent{Action: presence.ActionLeave, UserID: "user-1", Count: 1}, readPresence(t, watcher),
+		"quiz presence stays off the global presence topic")
+}
+
+func TestWebsocketPresenceTopicsNeedAToken(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+
+	// Presence events name users, so anonymous connections could rebuild the member lists.
+	anonymous := dialWS(t, ts, "")
+	for _, topic := range []string{"presence:global", "presence:quiz:quiz-1"} {
+		require.NoError(t, anonymous.WriteJSON(wsMessage{Op: wsOpSubscribe, Topic: topic}))
+		require.Equal(t, errCodeForbiddenTopic, readWSMessage(t, anonymous).Code, topic)
+	}
+}
+
+func TestPresenceEndpoints(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	for _, user := range []string{"user-1", "user-2", "user-3"} {
+		dialAs(t, ts, user)
+	}
+	require.Eventually(t, func() bool {
+		_, count := getJSON[presenceResponse](t, ts, "/presence/global")
+		return count.Count == 3
+	}, time.Second, 10*time.Millisecond)
+
+	_, page := getJSONAs[presenceMembersResponse](t, ts, "user-1", "/presence/global/members?limit=2")
+	require.Equal(t, []string{"user-1", "user-2"}, page.Members)
+	_, page = getJSONAs[presenceMembersResponse](t, ts, "user-1", "/presence/global/members?limit=2&cursor="+page.Cursor)
+	require.Equal(t, []string{"user-3"}, page.Members)
+	require.Equal(t, "0", page.Cursor)
+
+	_, empty := getJSONAs[presenceMembersResponse](t, ts, "user-1", "/presence/quiz:quiz-9/members")
+	require.Equal(t, []string{}, empty.Members)
+
+	for _, path := range []string{"/presence/global/members", "/presence/users/user-1"} {
+		status, body := getJSON[errorResponse](t, ts, path)
+		require.Equal(t, http.StatusUnauthorized, status, "%s names users, so it needs a token", path)
+		require.Equal(t, errCodeMissingToken, body.Error.Code, path)
+	}
+
+	for path, code := range map[string]errorCode{
+		"/presence/nope":                    errCodeInvalidScope,
+		"/presence/global/members?cursor=x": errCodeInvalidCursor,
+		"/presence/global/members?limit=-1": errCodeInvalidPayload,
+		"/presence/quiz:/members?limit=2":   errCodeInvalidScope,
+	} {
+		status, body := getJSONAs[errorResponse](t, ts, "user-1", path)
+		require.Equal(t, http.StatusBadRequest, status, path)
+		require.Equal(t, code, body.Error.Code, path)
+	}
+}
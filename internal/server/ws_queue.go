@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// wsSendQueue is the default number of events buffered per connection.
+const wsSendQueue = 64
+
+// Slow-consumer policies, applied when an event finds a connection's queue full.
+const (
+	// wsDropOldest discards the oldest queued event to make room: the client sees the latest state.
+	wsDropOldest = "drop_oldest"
+	// wsDropNewest discards the incoming event: the client sees a prefix of the stream.
+	wsDropNewest = "drop_newest"
+	// wsDisconnect closes the connection so the client reconnects and reloads.
+	wsDisconnect = "disconnect"
+)
+
+func validSlowConsumer(policy string) error {
+	switch policy {
+	case "", wsDropOldest, wsDropNewest, wsDisconnect:
+		return nil
+	}
+	return fmt.Errorf("websocket slow consumer policy %q must be %s, %s or %s", policy, wsDropOldest, wsDropNewest, wsDisconnect)
+}
+
+// wsStats counts queue outcomes since the server started.
+type wsStats struct {
+	queued        atomic.Int64
+	droppedOldest atomic.Int64
+	droppedNewest atomic.Int64
+	disconnected  atomic.Int64
+}
+
+// wsMetrics is a point-in-time view of the hub, served at /admin/metrics/websocket.
+type wsMetrics struct {
+	Connections   int    `json:"connections"`
+	QueueCapacity int    `json:"queue_capacity"`
+	SlowConsumer  string `json:"slow_consumer"`
+	// QueueDepth sums the events waiting on every connection; MaxQueueDepth is the deepest one.
+	QueueDepth    int         `json:"queue_depth"`
+	MaxQueueDepth int         `json:"max_queue_depth"`
+	Queued        int64       `json:"queued"`
+	Dropped       wsDropStats `json:"dropped"`
+	Disconnected  int64       `json:"disconnected"`
+}
+
+type wsDropStats struct {
+	Oldest int64 `json:"oldest"`
+	Newest int64 `json:"newest"`
+}
+
+// enqueue hands a message to the connection's writer without blocking, applying the hub's
+// slow-consumer policy when the queue is full. Callers hold the hub's read lock, which keeps
+// remove from closing the queue underneath them.
+func (h *wsHub) enqueue(client *wsClient, message wsOutbound) {
+	select {
+	case client.send <- message:
+		h.stats.queued.Add(1)
+		return
+	default:
+	}
+
+	switch h.policy {
+	case wsDisconnect:
+		h.stats.disconnected.Add(1)
+		log.Printf("disconnecting slow websocket consumer")
+		client.close()
+		go h.remove(client)
+		return
+	case wsDropOldest:
+		// Only the writer competes for the queue, so at most one slot frees up under us.
+		select {
+		case <-client.send:
+			h.stats.droppedOldest.Add(1)
+		default:
+		}
+		select {
+		case client.send <- message:
+			h.stats.queued.Add(1)
+			return
+		default:
+		}
+	}
+	h.stats.droppedNewest.Add(1)
+}
+
+// writePump delivers queued events until remove closes the queue. After a failed write the
+// connection is closed and the rest of the queue is discarded.
+func (c *wsClient) writePump() {
+	failed := false
+	for message := range c.send {
+		if failed {
+			continue
+		}
+		c.replayMu.Lock()
+		err := c.deliver(message)
+		c.replayMu.Unlock()
+		if err != nil {
+			log.Printf("failed to send broadcast: %v", err)
+			c.close()
+			failed = true
+		}
+	}
+}
+
+// deliver writes an event unless a replay already wrote it. Callers hold replayMu.
+func (c *wsClient) deliver(message wsOutbound) error {
+	if message.seq != 0 && message.seq <= c.delivered {
+		return nil
+	}
+	if err := c.writeFrame(message); err != nil {
+		return err
+	}
+	c.delivered = max(c.delivered, message.seq)
+	return nil
+}
+
+func (h *wsHub) metrics() wsMetrics {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	m := wsMetrics{
+		Connections:   len(h.clients),
+		QueueCapacity: h.queueSize,
+		SlowConsumer:  h.policy,
+		Queued:        h.stats.queued.Load(),
+		Dropped: wsDropStats{
+			Oldest: h.stats.droppedOldest.Load(),
+			Newest: h.stats.droppedNewest.Load(),
+		},
+		Disconnected: h.stats.disconnected.Load(),
+	}
+	for client := range h.clients {
+		depth := len(client.send)
+		m.QueueDepth += depth
+		m.MaxQueueDepth = max(m.MaxQueueDepth, depth)
+	}
+	return m
+}
+
+func (a *apiHandlers) websocketMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.hub.metrics())
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// stalledClient returns a hub client whose queue is never drained, backed by a live connection.
+func stalledClient(t *testing.T, hub *wsHub) *wsClient {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		accepted <- conn
+	}))
+	t.Cleanup(ts.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.Close() })
+
+	client := &wsClient{conn: <-accepted, send: make(chan wsOutbound, hub.queueSize)}
+	hub.clients[client] = struct{}{}
+	return client
+}
+
+func drain(client *wsClient) []string {
+	var queued []string
+	for len(client.send) > 0 {
+		queued = append(queued, string((<-client.send).data))
+	}
+	return queued
+}
+
+func TestHubSlowConsumerPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		queued  []string
+		metrics wsMetrics
+	}{
+		{policy: wsDropOldest, queued: []string{"2", "3"}, metrics: wsMetrics{Queued: 3, Dropped: wsDropStats{Oldest: 1}}},
+		{policy: wsDropNewest, queued: []string{"1", "2"}, metrics: wsMetrics{Queued: 2, Dropped: wsDropStats{Newest: 1}}},
+		{policy: wsDisconnect, queued: []string{"1", "2"}, metrics: wsMetrics{Queued: 2, Disconnected: 1}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			hub := newHub(events.NewLocalBus(0), configs.WebsocketConfig{SendQueue: 2, SlowConsumer: tc.policy})
+			client := stalledClient(t, hub)
+
+			for _, message := range []string{"1", "2", "3"} {
+				hub.enqueue(client, wsOutbound{data: []byte(message)})
+			}
+
+			got := hub.metrics()
+			require.Len(t, client.send, 2)
+			require.Equal(t, tc.metrics.Queued, got.Queued)
+			require.Equal(t, tc.metrics.Dropped, got.Dropped)
+			require.Equal(t, tc.metrics.Disconnected, got.Disconnected)
+			require.Equal(t, tc.queued, drain(client))
+		})
+	}
+}
+
+func TestHubDisconnectRemovesSlowConsumer(t *testing.T) {
+	hub := newHub(events.NewLocalBus(0), configs.WebsocketConfig{SendQueue: 1, SlowConsumer: wsDisconnect})
+	client := stalledClient(t, hub)
+
+	hub.mu.RLock()
+	hub.enqueue(client, wsOutbound{seq: 1, data: []byte("1")})
+	hub.enqueue(client, wsOutbound{seq: 2, data: []byte("2")})
+	hub.mu.RUnlock()
+
+	require.Eventually(t, func() bool { return hub.metrics().Connections == 0 }, time.Second, 10*time.Millisecond)
+	_, ok := <-client.send
+	require.True(t, ok, "queued events stay readable until the queue is drained")
+	_, ok = <-client.send
+	require.False(t, ok, "the queue is closed once the client leaves the hub")
+}
+
+func TestNewRejectsUnknownSlowConsumerPolicy(t *testing.T) {
+	cfg := &configs.Config{Server: configs.Server{Websocket: configs.WebsocketConfig{SlowConsumer: "block"}}}
+	_, err := New(t.Context(), cfg, nil)
+	require.ErrorContains(t, err, "block")
+}
+
+func TestWebsocketMetricsEndpoint(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	subscribe(t, dialWS(t, ts, ""), wsTopicGlobal)
+	submitScore(t, ts, "user-1", "quiz-1", 10)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "ops"}, adminGroup)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/admin/metrics/websocket", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var metrics wsMetrics
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&metrics))
+	require.Equal(t, 1, metrics.Connections)
+	require.Equal(t, wsSendQueue, metrics.QueueCapacity)
+	require.Equal(t, wsDropOldest, metrics.SlowConsumer)
+	require.Equal(t, int64(1), metrics.Queued)
+}
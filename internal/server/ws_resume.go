@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/pkg"
+)
+
+const (
+	// wsResumeParam asks for the events after a sequence number on connect: /ws?resume_from=41.
+	wsResumeParam = "resume_from"
+	// wsOpResume asks for the same on an open connection, typically after subscribing:
+	// {"op":"resume","seq":41}.
+	wsOpResume    = "resume"
+	wsTypeResumed = "resumed"
+
+	// wsReplayPage is how many events are read from the bus at a time.
+	wsReplayPage = 100
+	// wsReplayLimit caps one replay; a client further behind is told to reload instead.
+	wsReplayLimit = 1000
+)
+
+// resumeParam parses the resume_from query parameter; ok is false when it is absent.
+func resumeParam(r *http.Request) (seq uint64, ok bool, err error) {
+	raw := r.URL.Query().Get(wsResumeParam)
+	if raw == "" {
+		return 0, false, nil
+	}
+	seq, err = strconv.ParseUint(raw, 10, 64)
+	return seq, err == nil, err
+}
+
+// addResuming registers a connection like add and replays the events it missed before any
+// live event reaches it; live events arriving meanwhile wait in its queue.
+func (h *wsHub) addResuming(ctx context.Context, client *wsClient, after uint64, topics ...string) {
+	client.replayMu.Lock()
+	defer client.replayMu.Unlock()
+	h.add(client, topics...)
+	h.replayLocked(ctx, client, after)
+}
+
+// resume replays the events after a sequence number on an open connection.
+func (h *wsHub) resume(ctx context.Context, client *wsClient, after uint64) {
+	client.replayMu.Lock()
+	defer client.replayMu.Unlock()
+	h.replayLocked(ctx, client, after)
+}
+
+// replayLocked writes the retained events after seq that the connection is subscribed to,
+// then acknowledges with the sequence it caught up to. When some events are no longer
+// retained, or too many were missed, the client is told to reload with a single resume_gap
+// before the acknowledgement. Events already written to the connection are never repeated.
+// Callers hold client.replayMu.
+func (h *wsHub) replayLocked(ctx context.Context, client *wsClient, after uint64) {
+	replayer, ok := h.bus.(events.Replayer)
+	if !ok {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeNotImplemented, Message: "the event bus keeps no history"})
+		return
+	}
+
+	tenant, _ := client.identity()
+	ctx = pkg.WithTenant(ctx, pkg.Tenant{ID: tenant})
+	after = max(after, client.delivered)
+	from := after
+
+	caughtUp, gap := false, false
+	for replayed := 0; replayed < wsReplayLimit && !caughtUp; {
+		msgs, complete, err := replayer.Replay(ctx, after, wsReplayPage)
+		if err != nil {
+			log.Printf("failed to replay events: %v", err)
+			sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeInternal, Message: "failed to replay events"})
+			return
+		}
+		gap = gap || !complete
+
+		for _, msg := range msgs {
+			after = msg.Seq
+			topics, data, ok := render(msg)
+			if !ok || !h.subscribed(client, topics) {
+				continue
+			}
+			if err := client.deliver(wsOutbound{seq: msg.Seq, data: data}); err != nil {
+				log.Printf("failed to replay event: %v", err)
+				return
+			}
+		}
+		replayed += len(msgs)
+		caughtUp = len(msgs) < wsReplayPage
+	}
+	if gap || !caughtUp {
+		sendResumeGap(client, from)
+	}
+
+	// Skip the filtered events too, so live ones are compared against where the replay stopped.
+	client.delivered = max(client.delivered, after)
+	sendWS(client, wsMessage{Type: wsTypeResumed, Seq: client.delivered})
+}
+
+func sendResumeGap(client *wsClient, seq uint64) {
+	sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeResumeGap, Seq: seq,
+		Message: "missed events are no longer retained; reload the leaderboard"})
+}
+
+// subscribed reports whether a connection receives an event sent on topics; see broadcast.
+func (h *wsHub) subscribed(client *wsClient, topics []string) bool {
+	if topics == nil {
+		return true
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, topic := range topics {
+		if _, ok := client.subscriptions[topic]; ok {
+			return true
+		}
+	}
+	return false
+}
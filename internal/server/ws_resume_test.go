@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// readSeq reads one frame and returns its "seq" and "type" fields.
+func readSeq(t *testing.T, conn *websocket.Conn) (uint64, string) {
+	t.Helper()
+
+	msg := readWSMessage(t, conn)
+	return msg.Seq, msg.Type
+}
+
+func dialResume(t *testing.T, ts *httptest.Server, query string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?"+query, nil)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestWebsocketResumeFromQueryParam(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	for _, quiz := range []string{"quiz-1", "quiz-2", "quiz-3"} {
+		submitScore(t, ts, "user-1", quiz, 1)
+	}
+
+	// A connection starts with its user's topic only, so that is what resuming on connect replays.
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	conn, _, err := dialResume(t, ts, "resume_from=1&access_token="+token)
+	require.NoError(t, err)
+	for _, want := range []uint64{2, 3} {
+		seq, typ := readSeq(t, conn)
+		require.Equal(t, want, seq)
+		require.Empty(t, typ, "replayed events keep their usual shape")
+	}
+	require.Equal(t, wsMessage{Type: wsTypeResumed, Seq: 3}, readWSMessage(t, conn))
+
+	submitScore(t, ts, "user-1", "quiz-4", 1)
+	seq, _ := readSeq(t, conn)
+	require.Equal(t, uint64(4), seq, "live events continue after the replay")
+
+	_, resp, err := dialResume(t, ts, "resume_from=latest")
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var body errorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, errCodeInvalidResume, body.Error.Code)
+}
+
+func TestWebsocketResumeOpHonoursTopics(t *testing.T) {
+	ts := newTestServer(t, &configs.Config{}, &mockRepository{})
+	for _, quiz := range []string{"quiz-1", "quiz-2", "quiz-1"} {
+		submitScore(t, ts, "user-1", quiz, 1)
+	}
+
+	conn := dialWS(t, ts, "")
+	subscribe(t, conn, "quiz:quiz-1")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpResume}))
+	for _, want := range []uint64{1, 3} {
+		seq, _ := readSeq(t, conn)
+		require.Equal(t, want, seq)
+	}
+	require.Equal(t, wsMessage{Type: wsTypeResumed, Seq: 3}, readWSMessage(t, conn))
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpResume, Seq: 1}))
+	require.Equal(t, wsMessage{Type: wsTypeResumed, Seq: 3}, readWSMessage(t, conn), "events already sent are not repeated")
+}
+
+func TestWebsocketResumeReportsGap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := events.NewLocalBus(2)
+	t.Cleanup(func() { bus.Close() })
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{Repo: &mockRepository{}, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	for _, quiz := range []string{"quiz-1", "quiz-2", "quiz-3", "quiz-4"} {
+		submitScore(t, ts, "user-1", quiz, 1)
+	}
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	conn, _, err := dialResume(t, ts, "resume_from=0&access_token="+token)
+	require.NoError(t, err)
+	for _, want := range []uint64{3, 4} {
+		seq, _ := readSeq(t, conn)
+		require.Equal(t, want, seq, "what is still retained is replayed")
+	}
+	gap := readWSMessage(t, conn)
+	require.Equal(t, errCodeResumeGap, gap.Code)
+	require.Zero(t, gap.Seq)
+	require.Equal(t, wsMessage{Type: wsTypeResumed, Seq: 4}, readWSMessage(t, conn))
+}
+
+func TestWebsocketResumeReportsOneGapPastTheLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// The stream is trimmed and still holds more than one replay.
+	bus := events.NewLocalBus(wsReplayLimit + wsReplayPage)
+	t.Cleanup(func() { bus.Close() })
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{Repo: &mockRepository{}, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+
+	published := wsReplayLimit + 2*wsReplayPage
+	for i := 0; i < published; i++ {
+		require.NoError(t, events.Publish(ctx, bus, events.SubmitQuiz, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: float64(i)}))
+	}
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	conn, _, err := dialResume(t, ts, "resume_from=0&access_token="+token)
+	require.NoError(t, err)
+
+	first := uint64(published - wsReplayLimit - wsReplayPage + 1)
+	for i := 0; i < wsReplayLimit; i++ {
+		seq, typ := readSeq(t, conn)
+		require.Equal(t, first+uint64(i), seq, typ)
+	}
+	gap := readWSMessage(t, conn)
+	require.Equal(t, wsMessage{Type: wsTypeError, Code: errCodeResumeGap, Message: gap.Message}, gap)
+	require.Equal(t, wsMessage{Type: wsTypeResumed, Seq: first + wsReplayLimit - 1}, readWSMessage(t, conn),
+		"one resume_gap covers both the trimmed and the unreplayed events")
+}
+
+func TestWithSeq(t *testing.T) {
+	require.JSONEq(t, `{"seq":5,"a":1}`, string(withSeq([]byte(`{"a":1}`), 5)))
+	require.JSONEq(t, `{"seq":5}`, string(withSeq([]byte(`{}`), 5)))
+	require.Equal(t, `{"a":1}`, string(withSeq([]byte(`{"a":1}`), 0)))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// RPC ops a websocket client may send instead of calling the REST routes, e.g.
+// {"op":"submit","id":"7","params":{"quiz_id":"42","score":90}}. The answer echoes the id:
+// {"type":"result","id":"7","result":{...}} carrying what the route would respond with, or
+// {"type":"error","id":"7","code":"not_joined",...} with the route's error code. Requests are
+// answered in the order they were sent.
+const (
+	wsOpJoin        = "join"
+	wsOpSubmit      = "submit"
+	wsOpLeave       = "leave"
+	wsOpLeaderboard = "leaderboard"
+	wsOpRank        = "rank"
+
+	wsTypeResult = "result"
+)
+
+// wsParams holds the parameters of every RPC op; each op reads those its route takes.
+type wsParams struct {
+	QuizID string  `json:"quiz_id,omitempty"`
+	Score  float64 `json:"score,omitempty"`
+	Scope  string  `json:"scope,omitempty"`
+	From   int64   `json:"from,omitempty"`
+	Limit  int64   `json:"limit,omitempty"`
+}
+
+func isRPC(op string) bool {
+	switch op {
+	case wsOpJoin, wsOpSubmit, wsOpLeave, wsOpLeaderboard, wsOpRank:
+		return true
+	}
+	return false
+}
+
+// handleRPC runs a request through the logic of its REST route, as the user the connection
+// authenticated as, and answers it on the connection.
+func handleRPC(ctx context.Context, api *apiHandlers, client *wsClient, msg wsMessage) {
+	if msg.ID == "" {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeInvalidPayload, Message: "an RPC request needs an id"})
+		return
+	}
+
+	result, failure := api.call(ctx, client, msg)
+	if failure != nil {
+		sendWS(client, wsMessage{Type: wsTypeError, ID: msg.ID, Code: failure.code, Message: failure.message})
+		return
+	}
+	sendWS(client, wsMessage{Type: wsTypeResult, ID: msg.ID, Result: result})
+}
+
+func (a *apiHandlers) call(ctx context.Context, client *wsClient, msg wsMessage) (any, *actionError) {
+	var params wsParams
+	if msg.Params != nil {
+		params = *msg.Params
+	}
+
+	// Only the leaderboard is public, as on REST.
+	_, userID := client.identity()
+	if userID == "" && msg.Op != wsOpLeaderboard {
+		return nil, &actionError{http.StatusUnauthorized, errCodeMissingToken, "the connection must authenticate first"}
+	}
+
+	switch msg.Op {
+	case wsOpJoin:
+		return a.join(ctx, userID, params.QuizID)
+	case wsOpSubmit:
+		return a.submit(ctx, userID, params.QuizID, params.Score)
+	case wsOpLeave:
+		return a.leave(ctx, userID, params.QuizID)
+	case wsOpRank:
+		return a.rank(ctx, userID, params.Scope)
+	default:
+		body, failure := a.leaderboardSlice(ctx, leaderboardRequest{From: params.From, Limit: params.Limit}, a.leaderboardVersion(ctx))
+		if failure != nil {
+			return nil, failure
+		}
+		return json.RawMessage(body), nil
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	miniredis "github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/sunary/emu-game/configs"
+	"github.com/sunary/emu-game/internal/bootstrap"
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// rpcFrame is the answer to an RPC request.
+type rpcFrame struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Code   errorCode       `json:"code"`
+	Result json.RawMessage `json:"result"`
+}
+
+func newRedisTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mr := miniredis.RunT(t)
+	repo, err := repositories.NewRedisRepository(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	require.NoError(t, err)
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+
+	srv, err := New(ctx, &configs.Config{}, &bootstrap.Backend{Repo: repo, Bus: bus})
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func sendRPC(t *testing.T, conn *websocket.Conn, op, id string, params wsParams) {
+	t.Helper()
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: op, ID: id, Params: &params}))
+}
+
+// readRPC skips broadcast events up to the answer of request id.
+func readRPC(t *testing.T, conn *websocket.Conn, id string) rpcFrame {
+	t.Helper()
+
+	for {
+		var frame rpcFrame
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		require.NoError(t, conn.ReadJSON(&frame))
+		if frame.ID == id {
+			return frame
+		}
+	}
+}
+
+func callRPC(t *testing.T, conn *websocket.Conn, op, id string, params wsParams) rpcFrame {
+	t.Helper()
+	sendRPC(t, conn, op, id, params)
+	return readRPC(t, conn, id)
+}
+
+func TestWebsocketRPC(t *testing.T) {
+	ts := newRedisTestServer(t)
+	conn := dialAs(t, ts, "user-1")
+
+	joined := callRPC(t, conn, wsOpJoin, "1", wsParams{QuizID: "quiz-1"})
+	require.Equal(t, wsTypeResult, joined.Type)
+	require.JSONEq(t, `{"message":"joined quiz quiz-1"}`, string(joined.Result))
+
+	again := callRPC(t, conn, wsOpJoin, "2", wsParams{QuizID: "quiz-1"})
+	require.Equal(t, wsTypeError, again.Type)
+	require.Equal(t, errCodeAlreadyJoined, again.Code)
+
+	submitted := callRPC(t, conn, wsOpSubmit, "3", wsParams{QuizID: "quiz-1", Score: 42})
+	require.Equal(t, wsTypeResult, submitted.Type)
+	require.JSONEq(t, `{"message":"submitted quiz quiz-1"}`, string(submitted.Result))
+	require.Equal(t, errCodeNotJoined, callRPC(t, conn, wsOpSubmit, "4", wsParams{QuizID: "quiz-1", Score: 1}).Code,
+		"the submit consumed the membership")
+
+	var board []models.UserQuiz
+	require.NoError(t, json.Unmarshal(callRPC(t, conn, wsOpLeaderboard, "5", wsParams{Limit: 10}).Result, &board))
+	require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: 42}}, board)
+
+	var ranked repositories.RankedEntry
+	require.NoError(t, json.Unmarshal(callRPC(t, conn, wsOpRank, "6", wsParams{Scope: "quiz:quiz-1"}).Result, &ranked))
+	require.Equal(t, repositories.RankedEntry{Rank: 1, UserQuiz: models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 42}}, ranked)
+	require.Equal(t, errCodeInvalidScope, callRPC(t, conn, wsOpRank, "7", wsParams{Scope: "weekly"}).Code)
+
+	// Requests may be pipelined; answers come in order and carry their ids.
+	sendRPC(t, conn, wsOpJoin, "8", wsParams{QuizID: "quiz-2"})
+	sendRPC(t, conn, wsOpLeave, "9", wsParams{QuizID: "quiz-2"})
+	sendRPC(t, conn, wsOpLeave, "10", wsParams{QuizID: "quiz-2"})
+	require.Equal(t, wsTypeResult, readRPC(t, conn, "8").Type)
+	left := readRPC(t, conn, "9")
+	require.JSONEq(t, `{"message":"left quiz quiz-2"}`, string(left.Result))
+	require.Equal(t, errCodeNotJoined, readRPC(t, conn, "10").Code)
+}
+
+func TestWebsocketRPCAnonymous(t *testing.T) {
+	ts := newRedisTestServer(t)
+	conn := dialWS(t, ts, "")
+
+	require.Equal(t, errCodeMissingToken, callRPC(t, conn, wsOpJoin, "1", wsParams{QuizID: "quiz-1"}).Code)
+	require.Equal(t, wsTypeResult, callRPC(t, conn, wsOpLeaderboard, "2", wsParams{}).Type,
+		"the leaderboard is public, as on REST")
+
+	require.NoError(t, conn.WriteJSON(wsMessage{Op: wsOpLeaderboard}))
+	var frame rpcFrame
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, wsTypeError, frame.Type)
+	require.Equal(t, errCodeInvalidPayload, frame.Code, "a request without an id cannot be answered")
+}
+
+func TestWebsocketRPCMsgpack(t *testing.T) {
+	ts := newRedisTestServer(t)
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	conn := dialEncoded(t, websocket.DefaultDialer, ts.URL, wsEncodingMsgpack, wsBearerProtocol, token)
+
+	request, err := msgpack.Marshal(map[string]any{"op": wsOpJoin, "id": "1", "params": map[string]any{"quiz_id": "quiz-1"}})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, request))
+	require.Equal(t, map[string]any{"type": wsTypeResult, "id": "1", "result": map[string]any{"message": "joined quiz quiz-1"}},
+		readMsgpack(t, conn))
+}
+
+func TestLeaveAndRankRoutes(t *testing.T) {
+	ts := newRedisTestServer(t)
+	requestAs := func(userID, method, path, body string) *http.Response {
+		token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: userID})
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	request := func(method, path string) *http.Response { return requestAs("user-1", method, path, "") }
+
+	resp := request(http.MethodPost, "/user/quiz/quiz-1/leave")
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	var failure errorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&failure))
+	require.Equal(t, errCodeNotJoined, failure.Error.Code)
+
+	resp = request(http.MethodGet, "/user/rank")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&failure))
+	require.Equal(t, errCodeNotRanked, failure.Error.Code)
+
+	for _, play := range []struct{ userID, score string }{{"user-2", "50"}, {"user-1", "30"}} {
+		require.Equal(t, http.StatusCreated, requestAs(play.userID, http.MethodPost, "/user/quiz/quiz-1/join", "{}").StatusCode)
+		require.Equal(t, http.StatusOK, requestAs(play.userID, http.MethodPost, "/user/quiz/quiz-1/submit", `{"score":`+play.score+`}`).StatusCode)
+	}
+	resp = request(http.MethodGet, "/user/rank?scope=global")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var ranked repositories.RankedEntry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ranked))
+	require.Equal(t, int64(2), ranked.Rank)
+
+	require.Equal(t, http.StatusCreated, requestAs("user-1", http.MethodPost, "/user/quiz/quiz-2/join", "{}").StatusCode)
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/user/quiz/quiz-2/leave").StatusCode)
+
+	token, err := pkg.EncodeJWT(pkg.StandardPayload{Sub: "user-1"})
+	require.NoError(t, err)
+	unsupported := newTestServer(t, &configs.Config{}, &mockRepository{})
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/user/quiz/quiz-1/leave"},
+		{http.MethodGet, "/user/rank"},
+	} {
+		req, err := http.NewRequest(route.method, unsupported.URL+route.path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode, route.path)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/sunary/emu-game/internal/events"
+)
+
+const (
+	// wsTopicGlobal carries every event of the tenant but presence, to the connections that
+	// subscribe to it.
+	wsTopicGlobal = "global"
+	// wsTopicQuiz and wsTopicUser prefix the topics of one quiz and one user.
+	wsTopicQuiz = "quiz:"
+	wsTopicUser = "user:"
+
+	// wsMaxTopics caps the subscriptions of one connection.
+	wsMaxTopics = 32
+	// wsMaxTopicLen bounds a topic name, keeping clients from growing the hub index unbounded.
+	wsMaxTopicLen = 128
+
+	invalidTopicMessage   = `topic must be "global", "quiz:<id>", "user:<id>", "leaderboard:<scope>" or "presence:<scope>"`
+	forbiddenTopicMessage = "user topics are only open to that user, presence topics to authenticated users"
+)
+
+// Operations a client may send, e.g. {"op":"subscribe","topic":"quiz:42"}.
+const (
+	wsOpSubscribe   = "subscribe"
+	wsOpUnsubscribe = "unsubscribe"
+)
+
+// Acknowledgements of subscription operations, e.g. {"type":"subscribed","topic":"quiz:42"}.
+const (
+	wsTypeSubscribed   = "subscribed"
+	wsTypeUnsubscribed = "unsubscribed"
+)
+
+// topicEvent holds the fields events are routed on; every event type names them the same way.
+type topicEvent struct {
+	UserID string `json:"user_id"`
+	QuizID string `json:"quiz_id"`
+}
+
+// validTopic reports whether topic is global, names one quiz or user, or follows the
+// leaderboard or presence of a scope.
+func validTopic(topic string) bool {
+	if topic == wsTopicGlobal {
+		return true
+	}
+	if len(topic) > wsMaxTopicLen {
+		return false
+	}
+	for _, prefix := range []string{wsTopicLeaderboard, wsTopicPresence} {
+		if strings.HasPrefix(topic, prefix) {
+			_, ok := topicScope(prefix, topic)
+			return ok
+		}
+	}
+	for _, prefix := range []string{wsTopicQuiz, wsTopicUser} {
+		if id, ok := strings.CutPrefix(topic, prefix); ok {
+			return id != ""
+		}
+	}
+	return false
+}
+
+// mayFollow reports whether a connection may subscribe to a valid topic: a user topic is
+// private to the user the connection authenticated as, and presence topics, which name the
+// users coming and going, are open to authenticated connections only.
+func mayFollow(client *wsClient, topic string) bool {
+	_, userID := client.identity()
+	if strings.HasPrefix(topic, wsTopicPresence) {
+		return userID != ""
+	}
+	id, ok := strings.CutPrefix(topic, wsTopicUser)
+	if !ok {
+		return true
+	}
+	return userID == id
+}
+
+// eventTopics lists the topics an event is delivered on; global is among them, so only
+// connections that subscribed to global receive the events of every quiz. A nil result means
+// every connection of the tenant, whatever it subscribed to: a restore replaces every board
+// at once.
+func eventTopics(event events.Event) []string {
+	if event.Event == events.LeaderboardRestored {
+		return nil
+	}
+
+	topics := []string{wsTopicGlobal}
+	var data topicEvent
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return topics
+	}
+	if data.QuizID != "" {
+		topics = append(topics, wsTopicQuiz+data.QuizID)
+	}
+	if data.UserID != "" {
+		topics = append(topics, wsTopicUser+data.UserID)
+	}
+	return topics
+}
+
+// ownTopics lists the topics a connection starts with: its user's, once authenticated. Every
+// other topic, global included, must be subscribed to.
+func ownTopics(client *wsClient) []string {
+	if _, userID := client.identity(); userID != "" {
+		return []string{wsTopicUser + userID}
+	}
+	return nil
+}
+
+// followUser subscribes a connection that authenticated after the upgrade to its user's topic.
+func (h *wsHub) followUser(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, connected := h.clients[client]; !connected {
+		return
+	}
+	for _, topic := range ownTopics(client) {
+		h.addTopicLocked(client, topic)
+	}
+}
+
+// handleSubscription applies a subscribe or unsubscribe operation and acknowledges it.
+// A user topic is private to the user the connection authenticated as; a leaderboard topic is
+// acknowledged through the connection's queue, followed by the current top of the scope.
+func handleSubscription(ctx context.Context, hub *wsHub, client *wsClient, msg wsMessage) {
+	if !validTopic(msg.Topic) {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeInvalidTopic, Topic: msg.Topic, Message: invalidTopicMessage})
+		return
+	}
+
+	if msg.Op == wsOpUnsubscribe {
+		hub.removeTopic(client, msg.Topic)
+		sendWS(client, wsMessage{Type: wsTypeUnsubscribed, Topic: msg.Topic})
+		return
+	}
+
+	if scope, ok := leaderboardScope(msg.Topic); ok {
+		if hub.deltas == nil {
+			sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeNotImplemented, Topic: msg.Topic,
+				Message: "leaderboard deltas are not enabled"})
+			return
+		}
+		hub.deltas.subscribe(ctx, client, msg.Topic, scope)
+		return
+	}
+	if !mayFollow(client, msg.Topic) {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeForbiddenTopic, Topic: msg.Topic, Message: forbiddenTopicMessage})
+		return
+	}
+	if !hub.addTopic(client, msg.Topic) {
+		sendWS(client, wsMessage{Type: wsTypeError, Code: errCodeTooManyTopics, Topic: msg.Topic,
+			Message: "too many subscriptions on this connection"})
+		return
+	}
+	sendWS(client, wsMessage{Type: wsTypeSubscribed, Topic: msg.Topic})
+}
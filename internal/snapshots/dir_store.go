@@ -0,0 +1,109 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sunary/emu-game/pkg"
+)
+
+const defaultTenantDir = "default"
+
+// DirStore keeps each snapshot as a JSON file under dir/<tenant>/<id>.json.
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+func (s *DirStore) Save(ctx context.Context, snapshot *Snapshot) error {
+	dir := s.tenantDir(ctx)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated snapshot behind.
+	tmp := filepath.Join(dir, snapshot.ID+".json.tmp")
+	if err := os.WriteFile(tmp, payload, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, snapshot.ID+".json"))
+}
+
+func (s *DirStore) List(ctx context.Context) ([]Meta, error) {
+	files, err := os.ReadDir(s.tenantDir(ctx))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var metas []Meta
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+
+		snapshot, err := s.Load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, snapshot.Meta)
+	}
+
+	sortNewestFirst(metas)
+	return metas, nil
+}
+
+func (s *DirStore) Load(ctx context.Context, id string) (*Snapshot, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+
+	payload, err := os.ReadFile(filepath.Join(s.tenantDir(ctx), id+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (s *DirStore) Delete(ctx context.Context, id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.tenantDir(ctx), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *DirStore) tenantDir(ctx context.Context) string {
+	tenant := pkg.GetTenant(ctx).ID
+	if tenant == "" {
+		tenant = defaultTenantDir
+	}
+	return filepath.Join(s.dir, tenant)
+}
@@ -0,0 +1,107 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// RedisStore keeps each snapshot as a JSON string under a versioned key next to the
+// tenant's leaderboards, indexed by a sorted set scored by creation time.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Save(ctx context.Context, snapshot *Snapshot) error {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	keys := s.keys(ctx)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, keys.Snapshot(snapshot.ID), payload, 0)
+		pipe.ZAdd(ctx, keys.Snapshots(), redis.Z{
+			Member: snapshot.ID,
+			Score:  float64(snapshot.CreatedAt.UnixMilli()),
+		})
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]Meta, error) {
+	ids, err := s.client.ZRevRange(ctx, s.keys(ctx).Snapshots(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]Meta, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := s.Load(ctx, id)
+		if err != nil {
+			// The index can briefly outlive a snapshot deleted by another instance.
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		metas = append(metas, snapshot.Meta)
+	}
+
+	sortNewestFirst(metas)
+	return metas, nil
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) (*Snapshot, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+
+	payload, err := s.client.Get(ctx, s.keys(ctx).Snapshot(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(payload, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+
+	keys := s.keys(ctx)
+	cmds, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys.Snapshot(id))
+		pipe.ZRem(ctx, keys.Snapshots(), id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cmds[0].(*redis.IntCmd).Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) keys(ctx context.Context) repositories.Keyspace {
+	return repositories.KeyspaceFor(s.client, pkg.GetTenant(ctx).ID)
+}
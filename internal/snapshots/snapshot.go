@@ -0,0 +1,296 @@
+// Package snapshots captures point-in-time copies of a tenant's leaderboards and quiz
+// memberships so they can be rolled back after a risky operation.
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/internal/transfer"
+	"github.com/sunary/emu-game/pkg"
+)
+
+// Version is the encoding version written into every snapshot.
+const Version = 1
+
+const idLayout = "20060102T150405.000000000Z"
+
+var ErrNotFound = errors.New("snapshot not found")
+
+// Meta describes a snapshot without its contents.
+type Meta struct {
+	ID          string    `json:"id"`
+	Version     int       `json:"version"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Label       string    `json:"label,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Entries     int       `json:"entries"`
+	Memberships int       `json:"memberships"`
+}
+
+// Snapshot is the global leaderboard, which also restores every quiz board, plus the
+// memberships active when it was taken.
+type Snapshot struct {
+	Meta
+	Scores  []models.UserQuiz         `json:"scores"`
+	Members []repositories.Membership `json:"members"`
+}
+
+// Store persists snapshots of the request tenant.
+type Store interface {
+	Save(ctx context.Context, snapshot *Snapshot) error
+	// List returns the snapshots of the request tenant, newest first.
+	List(ctx context.Context) ([]Meta, error)
+	Load(ctx context.Context, id string) (*Snapshot, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Source is a repository that supports whole-board and membership access.
+type Source interface {
+	repositories.ScopeStore
+	repositories.MembershipStore
+}
+
+// RestoredEvent is published on the bus after a restore so clients reload the leaderboard.
+type RestoredEvent struct {
+	SnapshotID string    `json:"snapshot_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Version is the leaderboard version after the restore, when the source tracks one.
+	Version int64 `json:"version,omitempty"`
+}
+
+type Manager struct {
+	source Source
+	store  Store
+	bus    events.Bus
+	now    func() time.Time
+}
+
+func NewManager(source Source, store Store, bus events.Bus) *Manager {
+	return &Manager{source: source, store: store, bus: bus, now: time.Now}
+}
+
+// Create snapshots the request tenant's leaderboards and memberships.
+func (m *Manager) Create(ctx context.Context, label string) (*Meta, error) {
+	scores, err := transfer.ReadScope(ctx, m.source, repositories.GlobalScope)
+	if err != nil {
+		return nil, fmt.Errorf("read leaderboard: %w", err)
+	}
+	members, err := m.source.ListMemberships(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read memberships: %w", err)
+	}
+
+	createdAt := m.now().UTC()
+	snapshot := &Snapshot{
+		Meta: Meta{
+			ID:          createdAt.Format(idLayout),
+			Version:     Version,
+			Tenant:      pkg.GetTenant(ctx).ID,
+			Label:       label,
+			CreatedAt:   createdAt,
+			Entries:     len(scores),
+			Memberships: len(members),
+		},
+		Scores:  scores,
+		Members: members,
+	}
+	if err := m.store.Save(ctx, snapshot); err != nil {
+		return nil, fmt.Errorf("save snapshot: %w", err)
+	}
+
+	return &snapshot.Meta, nil
+}
+
+func (m *Manager) List(ctx context.Context) ([]Meta, error) {
+	return m.store.List(ctx)
+}
+
+// Restore replaces the request tenant's leaderboards and memberships with the contents of a
+// snapshot and tells connected clients to refresh.
+func (m *Manager) Restore(ctx context.Context, id string) (*Meta, error) {
+	snapshot, err := m.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Version != Version {
+		return nil, fmt.Errorf("snapshot %s has unsupported version %d", id, snapshot.Version)
+	}
+
+	if err := m.source.ImportScores(ctx, repositories.GlobalScope, snapshot.Scores, true); err != nil {
+		return nil, fmt.Errorf("restore leaderboard: %w", err)
+	}
+	if err := m.source.ReplaceMemberships(ctx, snapshot.Members); err != nil {
+		return nil, fmt.Errorf("restore memberships: %w", err)
+	}
+
+	event := RestoredEvent{SnapshotID: snapshot.ID, CreatedAt: snapshot.CreatedAt}
+	if versioned, ok := m.source.(repositories.Versioned); ok {
+		if event.Version, err = versioned.LeaderboardVersion(ctx); err != nil {
+			log.Printf("failed to read leaderboard version: %v", err)
+		}
+	}
+	if err := events.Publish(ctx, m.bus, events.LeaderboardRestored, event); err != nil {
+		// The data is already restored; clients catch up on their next read.
+		log.Printf("failed to publish restore event: %v", err)
+	}
+
+	return &snapshot.Meta, nil
+}
+
+// Prune deletes all but the newest retain snapshots of the request tenant; a retain of
+// zero or less keeps everything.
+func (m *Manager) Prune(ctx context.Context, retain int) error {
+	metas, err := m.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	if retain <= 0 || len(metas) <= retain {
+		return nil
+	}
+
+	for _, meta := range metas[retain:] {
+		if err := m.store.Delete(ctx, meta.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schedule snapshots every tenant each interval and keeps the newest retain snapshots of
+// each, until ctx is done. A retain of zero or less keeps everything.
+func (m *Manager) Schedule(ctx context.Context, interval time.Duration, retain int, tenants []pkg.Tenant) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, tenant := range tenants {
+				tctx := pkg.WithTenant(ctx, tenant)
+				meta, err := m.Create(tctx, "scheduled")
+				if err != nil {
+					log.Printf("scheduled snapshot for tenant %q failed: %v", tenant.ID, err)
+					continue
+				}
+				log.Printf("created scheduled snapshot %s for tenant %q", meta.ID, tenant.ID)
+
+				if err := m.Prune(tctx, retain); err != nil {
+					log.Printf("pruning snapshots for tenant %q failed: %v", tenant.ID, err)
+				}
+			}
+		}
+	}
+}
+
+func sortNewestFirst(metas []Meta) {
+	sort.Slice(metas, func(i, j int) bool { return metas[i].ID > metas[j].ID })
+}
+
+// validID rejects ids that could escape the snapshot directory or key namespace.
+func validID(id string) error {
+	if _, err := time.Parse(idLayout, id); err != nil {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FindUser returns the ids of the request tenant's snapshots that hold entries or a
+// membership of the user, newest first.
+func (m *Manager) FindUser(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	err := m.eachSnapshot(ctx, func(snapshot *Snapshot) error {
+		if containsUser(snapshot, userID) {
+			ids = append(ids, snapshot.ID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// RewriteUser rewrites every snapshot of the request tenant that mentions the user. An
+// empty replacement drops the user's entries and membership; otherwise they are kept under
+// the replacement user id. It returns the ids of the rewritten snapshots.
+func (m *Manager) RewriteUser(ctx context.Context, userID, replacement string) ([]string, error) {
+	var ids []string
+	err := m.eachSnapshot(ctx, func(snapshot *Snapshot) error {
+		if !containsUser(snapshot, userID) {
+			return nil
+		}
+
+		scores := snapshot.Scores[:0]
+		for _, score := range snapshot.Scores {
+			if score.UserID == userID {
+				if replacement == "" {
+					continue
+				}
+				score.UserID = replacement
+			}
+			scores = append(scores, score)
+		}
+		members := snapshot.Members[:0]
+		for _, member := range snapshot.Members {
+			if member.UserID == userID {
+				if replacement == "" {
+					continue
+				}
+				member.UserID = replacement
+			}
+			members = append(members, member)
+		}
+
+		snapshot.Scores, snapshot.Members = scores, members
+		snapshot.Entries, snapshot.Memberships = len(scores), len(members)
+		if err := m.store.Save(ctx, snapshot); err != nil {
+			return fmt.Errorf("save snapshot %s: %w", snapshot.ID, err)
+		}
+		ids = append(ids, snapshot.ID)
+		return nil
+	})
+	return ids, err
+}
+
+func (m *Manager) eachSnapshot(ctx context.Context, fn func(*Snapshot) error) error {
+	metas, err := m.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, meta := range metas {
+		snapshot, err := m.store.Load(ctx, meta.ID)
+		if errors.Is(err, ErrNotFound) {
+			// Pruned since the listing.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsUser(snapshot *Snapshot, userID string) bool {
+	for _, score := range snapshot.Scores {
+		if score.UserID == userID {
+			return true
+		}
+	}
+	for _, member := range snapshot.Members {
+		if member.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
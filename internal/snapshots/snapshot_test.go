@@ -0,0 +1,162 @@
+package snapshots
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/events"
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+	"github.com/sunary/emu-game/pkg"
+)
+
+func newRedisClient(t *testing.T) redis.UniversalClient {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"dir":   func(t *testing.T) Store { return NewDirStore(t.TempDir()) },
+		"redis": func(t *testing.T) Store { return NewRedisStore(newRedisClient(t)) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			ctx := context.Background()
+			acme := pkg.WithTenant(ctx, pkg.Tenant{ID: "acme"})
+
+			metas, err := store.List(ctx)
+			require.NoError(t, err)
+			require.Empty(t, metas)
+
+			base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			for i, label := range []string{"first", "second"} {
+				createdAt := base.Add(time.Duration(i) * time.Minute)
+				require.NoError(t, store.Save(ctx, &Snapshot{
+					Meta:   Meta{ID: createdAt.Format(idLayout), Version: Version, Label: label, CreatedAt: createdAt, Entries: 1},
+					Scores: []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: float64(i)}},
+				}))
+			}
+
+			metas, err = store.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, metas, 2)
+			require.Equal(t, "second", metas[0].Label, "newest first")
+
+			tenantMetas, err := store.List(acme)
+			require.NoError(t, err)
+			require.Empty(t, tenantMetas, "snapshots are scoped to their tenant")
+
+			snapshot, err := store.Load(ctx, metas[1].ID)
+			require.NoError(t, err)
+			require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: 0}}, snapshot.Scores)
+
+			_, err = store.Load(acme, metas[1].ID)
+			require.ErrorIs(t, err, ErrNotFound)
+			_, err = store.Load(ctx, "../../etc/passwd")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, store.Delete(ctx, metas[1].ID))
+			require.ErrorIs(t, store.Delete(ctx, metas[1].ID), ErrNotFound)
+			metas, err = store.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, metas, 1)
+		})
+	}
+}
+
+func TestManagerCreateAndRestore(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repositories.NewRedisRepository(newRedisClient(t))
+	require.NoError(t, err)
+
+	bus := events.NewLocalBus(0)
+	t.Cleanup(func() { bus.Close() })
+	ch, err := bus.Subscribe(ctx)
+	require.NoError(t, err)
+
+	manager := NewManager(repo, NewDirStore(t.TempDir()), bus)
+
+	require.NoError(t, repo.JoinQuiz(ctx, "user-1", "quiz-1"))
+	require.NoError(t, repo.SubmitQuiz(ctx, models.UserQuiz{UserID: "user-1", QuizID: "quiz-1", Score: 10}))
+	require.NoError(t, repo.JoinQuiz(ctx, "user-2", "quiz-2"))
+
+	meta, err := manager.Create(ctx, "before migration")
+	require.NoError(t, err)
+	require.Equal(t, 1, meta.Entries)
+	require.Equal(t, 1, meta.Memberships)
+
+	// Simulate a bad operation: wipe the leaderboard and memberships.
+	require.NoError(t, repo.ImportScores(ctx, repositories.GlobalScope, []models.UserQuiz{{UserID: "user-3", QuizID: "quiz-2", Score: 99}}, true))
+	require.NoError(t, repo.ReplaceMemberships(ctx, nil))
+
+	restored, err := manager.Restore(ctx, meta.ID)
+	require.NoError(t, err)
+	require.Equal(t, meta.ID, restored.ID)
+
+	scores, err := repo.ListUserScores(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.UserQuiz{{UserID: "user-1", QuizID: "quiz-1", Score: 10}}, scores)
+	quiz2, err := repo.ListScopeScores(ctx, repositories.QuizScope("quiz-2"), 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, quiz2, "restoring the global board restores quiz boards too")
+
+	quizID, err := repo.GetQuizByUserID(ctx, "user-2")
+	require.NoError(t, err)
+	require.Equal(t, "quiz-2", quizID)
+
+	select {
+	case msg := <-ch:
+		var event events.Event
+		require.NoError(t, json.Unmarshal(msg.Payload, &event))
+		require.Equal(t, events.LeaderboardRestored, event.Event)
+		version, err := repo.LeaderboardVersion(ctx)
+		require.NoError(t, err)
+		require.JSONEq(t, fmt.Sprintf(`{"snapshot_id":%q,"created_at":%q,"version":%d}`, meta.ID, meta.CreatedAt.Format(time.RFC3339Nano), version), string(event.Data))
+	case <-time.After(time.Second):
+		t.Fatal("restore event not published")
+	}
+
+	_, err = manager.Restore(ctx, "20000101T000000.000000000Z")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestManagerPrune(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repositories.NewRedisRepository(newRedisClient(t))
+	require.NoError(t, err)
+
+	manager := NewManager(repo, NewDirStore(t.TempDir()), events.NewLocalBus(0))
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	manager.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		meta, err := manager.Create(ctx, "")
+		require.NoError(t, err)
+		ids = append(ids, meta.ID)
+	}
+
+	require.NoError(t, manager.Prune(ctx, 2))
+	metas, err := manager.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, metas, 2)
+	require.Equal(t, ids[3], metas[0].ID)
+	require.Equal(t, ids[2], metas[1].ID)
+}
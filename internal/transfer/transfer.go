@@ -0,0 +1,284 @@
+// Package transfer encodes and decodes whole leaderboards for the admin export and import
+// tooling. It only talks to storage through repositories.ScopeStore.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+const pageSize = 1000
+
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+)
+
+var (
+	ErrUnknownFormat = errors.New("format must be csv, json or ndjson")
+
+	csvHeader = []string{"rank", "user_id", "quiz_id", "score", "scope"}
+)
+
+// ParseFormat accepts an explicit format name, or infers it from a file name when raw is empty.
+func ParseFormat(raw, fileName string) (Format, error) {
+	if raw == "" {
+		raw = strings.TrimPrefix(filepath.Ext(fileName), ".")
+		if raw == "" {
+			return FormatJSON, nil
+		}
+	}
+
+	switch f := Format(strings.ToLower(raw)); f {
+	case FormatCSV, FormatJSON, FormatNDJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFormat, raw)
+	}
+}
+
+// Row is one ranked leaderboard entry; Rank is 1-based.
+type Row struct {
+	Rank   int64   `json:"rank"`
+	UserID string  `json:"user_id"`
+	QuizID string  `json:"quiz_id"`
+	Score  float64 `json:"score"`
+	Scope  string  `json:"scope,omitempty"`
+}
+
+// Document is the JSON export envelope carrying the export metadata.
+type Document struct {
+	Scope      string    `json:"scope"`
+	Tenant     string    `json:"tenant,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+	Count      int       `json:"count"`
+	Entries    []Row     `json:"entries"`
+}
+
+// ReadScope loads every entry of a scope, highest score first.
+func ReadScope(ctx context.Context, store repositories.ScopeStore, scope repositories.Scope) ([]models.UserQuiz, error) {
+	var entries []models.UserQuiz
+	for from := int64(0); ; from += pageSize {
+		page, err := store.ListScopeScores(ctx, scope, from, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(page) < pageSize {
+			return entries, nil
+		}
+	}
+}
+
+// Export writes a whole scope in the given format and returns the number of entries.
+func Export(ctx context.Context, store repositories.ScopeStore, scope repositories.Scope, tenant string, format Format, w io.Writer) (int, error) {
+	entries, err := ReadScope(ctx, store, scope)
+	if err != nil {
+		return 0, fmt.Errorf("read leaderboard: %w", err)
+	}
+
+	rows := make([]Row, 0, len(entries))
+	for i, entry := range entries {
+		rows = append(rows, Row{
+			Rank:   int64(i + 1),
+			UserID: entry.UserID,
+			QuizID: entry.QuizID,
+			Score:  entry.Score,
+			Scope:  string(scope),
+		})
+	}
+
+	switch format {
+	case FormatCSV:
+		err = writeCSV(w, rows)
+	case FormatNDJSON:
+		err = writeNDJSON(w, rows)
+	case FormatJSON:
+		for i := range rows {
+			rows[i].Scope = ""
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(Document{
+			Scope:      string(scope),
+			Tenant:     tenant,
+			ExportedAt: time.Now().UTC(),
+			Count:      len(rows),
+			Entries:    rows,
+		})
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(rows), nil
+}
+
+func writeCSV(w io.Writer, rows []Row) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			strconv.FormatInt(row.Rank, 10),
+			row.UserID,
+			row.QuizID,
+			strconv.FormatFloat(row.Score, 'f', -1, 64),
+			row.Scope,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeNDJSON(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode reads entries written by Export. Ranks are informational and ignored on import:
+// the leaderboard orders entries by score.
+func Decode(format Format, r io.Reader) ([]models.UserQuiz, error) {
+	var rows []Row
+	switch format {
+	case FormatCSV:
+		var err error
+		if rows, err = readCSV(r); err != nil {
+			return nil, err
+		}
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			raw := strings.TrimSpace(scanner.Text())
+			if raw == "" {
+				continue
+			}
+			var row Row
+			if err := json.Unmarshal([]byte(raw), &row); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rows = append(rows, row)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		var doc Document
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, err
+		}
+		rows = doc.Entries
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, format)
+	}
+
+	entries := make([]models.UserQuiz, 0, len(rows))
+	for i, row := range rows {
+		if row.UserID == "" || row.QuizID == "" {
+			return nil, fmt.Errorf("entry %d: user_id and quiz_id are required", i+1)
+		}
+		entries = append(entries, models.UserQuiz{UserID: row.UserID, QuizID: row.QuizID, Score: row.Score})
+	}
+	return entries, nil
+}
+
+func readCSV(r io.Reader) ([]Row, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"user_id", "quiz_id", "score"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %q", required)
+		}
+	}
+
+	rows := make([]Row, 0, len(records)-1)
+	for i, record := range records[1:] {
+		score, err := strconv.ParseFloat(record[columns["score"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid score: %w", i+2, err)
+		}
+		rows = append(rows, Row{
+			UserID: record[columns["user_id"]],
+			QuizID: record[columns["quiz_id"]],
+			Score:  score,
+		})
+	}
+	return rows, nil
+}
+
+// Plan summarises what an import would change on a scope.
+type Plan struct {
+	Added     int `json:"added"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+}
+
+// PlanImport compares the current entries of a scope with an import. Entries are identical
+// when user, quiz and score all match, mirroring leaderboard member semantics.
+func PlanImport(current, incoming []models.UserQuiz, replace bool) Plan {
+	existing := make(map[models.UserQuiz]struct{}, len(current))
+	for _, entry := range current {
+		existing[entry] = struct{}{}
+	}
+
+	var plan Plan
+	seen := make(map[models.UserQuiz]struct{}, len(incoming))
+	for _, entry := range incoming {
+		if _, dup := seen[entry]; dup {
+			continue
+		}
+		seen[entry] = struct{}{}
+
+		if _, ok := existing[entry]; ok {
+			plan.Unchanged++
+		} else {
+			plan.Added++
+		}
+	}
+
+	if replace {
+		for entry := range existing {
+			if _, ok := seen[entry]; !ok {
+				plan.Removed++
+			}
+		}
+	}
+	return plan
+}
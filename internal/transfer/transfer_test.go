@@ -0,0 +1,109 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunary/emu-game/internal/models"
+	"github.com/sunary/emu-game/internal/repositories"
+)
+
+func newTestStore(t *testing.T) *repositories.BoltRepository {
+	t.Helper()
+
+	repo, err := repositories.NewBoltRepository(filepath.Join(t.TempDir(), "emu.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+
+	return repo
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("", "scores.csv")
+	require.NoError(t, err)
+	require.Equal(t, FormatCSV, format)
+
+	format, err = ParseFormat("NDJSON", "scores.txt")
+	require.NoError(t, err)
+	require.Equal(t, FormatNDJSON, format)
+
+	format, err = ParseFormat("", "")
+	require.NoError(t, err)
+	require.Equal(t, FormatJSON, format)
+
+	_, err = ParseFormat("xml", "")
+	require.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	entries := []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 120.5},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 300},
+		{UserID: "user-3", QuizID: "quiz-2", Score: 50},
+	}
+
+	for _, format := range []Format{FormatCSV, FormatJSON, FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			source := newTestStore(t)
+			require.NoError(t, source.ImportScores(ctx, repositories.GlobalScope, entries, false))
+
+			var buf bytes.Buffer
+			n, err := Export(ctx, source, repositories.QuizScope("quiz-1"), "", format, &buf)
+			require.NoError(t, err)
+			require.Equal(t, 2, n)
+
+			decoded, err := Decode(format, &buf)
+			require.NoError(t, err)
+			require.Equal(t, []models.UserQuiz{entries[1], entries[0]}, decoded)
+
+			target := newTestStore(t)
+			require.NoError(t, target.ImportScores(ctx, repositories.QuizScope("quiz-1"), decoded, false))
+
+			imported, err := ReadScope(ctx, target, repositories.QuizScope("quiz-1"))
+			require.NoError(t, err)
+			require.Equal(t, decoded, imported)
+		})
+	}
+}
+
+func TestExportCSVIncludesRanks(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	require.NoError(t, store.ImportScores(ctx, repositories.GlobalScope, []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+		{UserID: "user-2", QuizID: "quiz-2", Score: 20},
+	}, false))
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, store, repositories.GlobalScope, "", FormatCSV, &buf)
+	require.NoError(t, err)
+	require.Equal(t, "rank,user_id,quiz_id,score,scope\n1,user-2,quiz-2,20,global\n2,user-1,quiz-1,10,global\n", buf.String())
+}
+
+func TestDecodeRejectsIncompleteRows(t *testing.T) {
+	_, err := Decode(FormatNDJSON, bytes.NewBufferString(`{"user_id":"u1","score":1}`+"\n"))
+	require.Error(t, err)
+
+	_, err = Decode(FormatCSV, bytes.NewBufferString("user_id,score\nu1,1\n"))
+	require.Error(t, err)
+}
+
+func TestPlanImport(t *testing.T) {
+	current := []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 20},
+	}
+	incoming := []models.UserQuiz{
+		{UserID: "user-1", QuizID: "quiz-1", Score: 10},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 25},
+		{UserID: "user-2", QuizID: "quiz-1", Score: 25},
+	}
+
+	require.Equal(t, Plan{Added: 1, Unchanged: 1}, PlanImport(current, incoming, false))
+	require.Equal(t, Plan{Added: 1, Unchanged: 1, Removed: 1}, PlanImport(current, incoming, true))
+}
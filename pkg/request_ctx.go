@@ -0,0 +1,15 @@
+package pkg
+
+import "context"
+
+const requestIDContextKey userContextKey = "request-id"
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// GetRequestID returns the ID assigned to the request, or "" outside of a request.
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
@@ -0,0 +1,18 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRequestIDAndGetRequestID(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	require.Equal(t, "req-1", GetRequestID(ctx))
+}
+
+func TestGetRequestIDEmptyWhenMissing(t *testing.T) {
+	require.Empty(t, GetRequestID(context.Background()))
+}
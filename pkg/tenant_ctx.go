@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+const tenantContextKey userContextKey = "tenant"
+
+// Tenant IDs end up in Redis keys and channel names, so they are restricted to a safe alphabet.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidTenantID reports whether id may name a tenant.
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// Tenant identifies the customer organization a request belongs to, together with its
+// configuration overrides. The zero value is the default tenant using global settings.
+type Tenant struct {
+	ID               string
+	MembershipTTL    time.Duration
+	LeaderboardLimit int64
+}
+
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// GetTenant returns the request tenant, or the default tenant when none was resolved.
+func GetTenant(ctx context.Context) Tenant {
+	tenant, _ := ctx.Value(tenantContextKey).(Tenant)
+	return tenant
+}
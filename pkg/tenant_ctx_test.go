@@ -0,0 +1,28 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTenantAndGetTenant(t *testing.T) {
+	ctx := WithTenant(context.Background(), Tenant{ID: "acme", MembershipTTL: time.Minute})
+
+	require.Equal(t, Tenant{ID: "acme", MembershipTTL: time.Minute}, GetTenant(ctx))
+}
+
+func TestGetTenantDefaultsWhenMissing(t *testing.T) {
+	require.Equal(t, Tenant{}, GetTenant(context.Background()))
+}
+
+func TestValidTenantID(t *testing.T) {
+	for _, id := range []string{"acme", "a", "acme-eu-1", "0day"} {
+		require.True(t, ValidTenantID(id), id)
+	}
+	for _, id := range []string{"", "Acme", "-acme", "acme:eu", "acme*", "{acme}", "acme eu"} {
+		require.False(t, ValidTenantID(id), id)
+	}
+}
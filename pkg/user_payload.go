@@ -22,6 +22,7 @@ type StandardPayload struct {
 	Phone              string `json:"phone"`
 	Name               string `json:"name"`
 	Sub                string `json:"sub"`
+	Tenant             string `json:"tenant,omitempty"`
 }
 
 type Payload struct {
@@ -31,11 +32,22 @@ type Payload struct {
 	Groups          []string `json:"groups"`
 }
 
-func EncodeJWT(payload StandardPayload) (string, error) {
+// HasGroup reports whether the token grants membership of the given group.
+func (p *Payload) HasGroup(group string) bool {
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func EncodeJWT(payload StandardPayload, groups ...string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Payload{
 		StandardPayload: payload,
 		Iss:             issuer,
 		Exp:             time.Now().Add(expiry).Unix(),
+		Groups:          groups,
 	})
 	tokenString, err := token.SignedString(secretKey)
 	if err != nil {